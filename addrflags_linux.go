@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package zeroconf
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// Netlink attribute carrying the full 32 bit address flags (linux/if_addr.h)
+const ifaFlags = 8
+
+// temporaryAddrs returns the RFC 4941 temporary IPv6 addresses of all
+// interfaces by interface index, as reported by the kernel.
+func temporaryAddrs() map[int]map[string]bool {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_INET6)
+	if err != nil {
+		return nil
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil
+	}
+	temporary := make(map[int]map[string]bool)
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+		ifam := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			continue
+		}
+		flags := uint32(ifam.Flags)
+		var ip net.IP
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.IFA_ADDRESS:
+				ip = net.IP(attr.Value)
+			case ifaFlags:
+				if len(attr.Value) >= 4 {
+					flags = *(*uint32)(unsafe.Pointer(&attr.Value[0]))
+				}
+			}
+		}
+		if ip != nil && flags&syscall.IFA_F_TEMPORARY != 0 {
+			if temporary[int(ifam.Index)] == nil {
+				temporary[int(ifam.Index)] = make(map[string]bool)
+			}
+			temporary[int(ifam.Index)][ip.String()] = true
+		}
+	}
+	return temporary
+}
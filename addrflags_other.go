@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package zeroconf
+
+// temporaryAddrs returns the RFC 4941 temporary IPv6 addresses of all
+// interfaces by interface index. Address flags are only available on Linux.
+func temporaryAddrs() map[int]map[string]bool {
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build darwin
+// +build darwin
+
+package zeroconf
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToDevice restricts a socket to the named interface with IP_BOUND_IF or
+// IPV6_BOUND_IF.
+func bindToDevice(c syscall.RawConn, network, device string) error {
+	iface, err := net.InterfaceByName(device)
+	if err != nil {
+		return err
+	}
+	cerr := c.Control(func(fd uintptr) {
+		if network == "udp6" {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, iface.Index)
+		} else {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BOUND_IF, iface.Index)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
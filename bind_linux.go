@@ -0,0 +1,23 @@
+//go:build linux
+// +build linux
+
+package zeroconf
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToDevice restricts a socket to the named interface with
+// SO_BINDTODEVICE.
+func bindToDevice(c syscall.RawConn, network, device string) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = unix.BindToDevice(int(fd), device)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package zeroconf
+
+import "syscall"
+
+// bindToDevice does nothing on this platform; the multicast groups are still
+// joined on the named interface only.
+func bindToDevice(c syscall.RawConn, network, device string) error {
+	return nil
+}
@@ -0,0 +1,135 @@
+package zeroconf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Domain enumeration queries of RFC 6763 section 11, in order of preference.
+var browseDomainQueries = []string{"db", "b", "lb"}
+
+// BrowseDomains discovers the recommended browse domains of the network
+// (RFC 6763 section 11). It multicasts the "db", "b" and "lb" meta-queries in
+// the .local domain and sends them via unicast DNS for the reverse-mapping
+// domains of the host's IPv4 networks, e.g. "0.1.168.192.in-addr.arpa.".
+//
+// The call blocks until the context expires and returns the domains found,
+// the default browse domains first. Like Browse, it consumes the resolver.
+func (r *Resolver) BrowseDomains(ctx context.Context) ([]string, error) {
+	c := r.c
+	msgCh := make(chan *inbound, 32)
+	if c.ipv4conn != nil {
+		go c.recv(ctx, c.ipv4conn, msgCh)
+	}
+	if c.ipv6conn != nil {
+		go c.recv(ctx, c.ipv6conn, msgCh)
+	}
+	defer c.shutdown()
+
+	m := new(dns.Msg)
+	m.RecursionDesired = false
+	for _, kind := range browseDomainQueries {
+		m.Question = append(m.Question, dns.Question{
+			Name:   fmt.Sprintf("%s._dns-sd._udp.local.", kind),
+			Qtype:  dns.TypePTR,
+			Qclass: dns.ClassINET,
+		})
+	}
+	if err := c.sendQuery(m); err != nil {
+		return nil, err
+	}
+
+	found := make(map[string][]string)
+	unicast := make(chan map[string][]string, 1)
+	go func() {
+		unicast <- c.reverseBrowseDomains(ctx)
+	}()
+
+	for {
+		select {
+		case in := <-msgCh:
+			for _, rr := range append(in.msg.Answer, in.msg.Extra...) {
+				ptr, ok := rr.(*dns.PTR)
+				if !ok {
+					continue
+				}
+				if kind := browseDomainKind(ptr.Hdr.Name, "local."); kind != "" {
+					found[kind] = append(found[kind], ptr.Ptr)
+				}
+			}
+		case domains := <-unicast:
+			for kind, list := range domains {
+				found[kind] = append(found[kind], list...)
+			}
+		case <-ctx.Done():
+			var domains []string
+			seen := make(map[string]bool)
+			for _, kind := range browseDomainQueries {
+				for _, domain := range found[kind] {
+					domain = strings.ToLower(dns.Fqdn(domain))
+					if !seen[domain] {
+						seen[domain] = true
+						domains = append(domains, domain)
+					}
+				}
+			}
+			return domains, nil
+		}
+	}
+}
+
+// reverseBrowseDomains sends the domain enumeration queries for the reverse
+// mapping domains of the IPv4 networks on the selected interfaces.
+func (c *client) reverseBrowseDomains(ctx context.Context) map[string][]string {
+	found := make(map[string][]string)
+	seen := make(map[string]bool)
+	for i := range c.ifaces {
+		addrs, err := c.ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			reverse, err := dns.ReverseAddr(ipnet.IP.Mask(ipnet.Mask).String())
+			if err != nil || seen[reverse] {
+				continue
+			}
+			seen[reverse] = true
+			for _, kind := range browseDomainQueries {
+				resp, err := c.exchangeUnicast(ctx, fmt.Sprintf("%s._dns-sd._udp.%s", kind, reverse), dns.TypePTR)
+				if err != nil {
+					continue
+				}
+				for _, rr := range resp.Answer {
+					if ptr, ok := rr.(*dns.PTR); ok {
+						found[kind] = append(found[kind], ptr.Ptr)
+					}
+				}
+			}
+		}
+	}
+	return found
+}
+
+// browseDomainKind returns the kind of a browse domain enumeration query name
+// in the given domain, or an empty string for other names.
+func browseDomainKind(name, domain string) string {
+	suffix := "._dns-sd._udp." + domain
+	if len(name) <= len(suffix) || !strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		return ""
+	}
+	kind := strings.ToLower(name[:len(name)-len(suffix)])
+	for _, k := range browseDomainQueries {
+		if kind == k {
+			return kind
+		}
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package zeroconf
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Intervals of the continuous queries of a browse, see RFC 6762 section 5.2.
+// The interval doubles after each query.
+const (
+	continuousQueryMin = time.Second
+	continuousQueryMax = 60 * time.Minute
+)
+
+// Browser continuously browses for the instances of a service type and keeps
+// an always-current view of them. It queries with the intervals prescribed by
+// RFC 6762 section 5.2, starting at one second and doubling up to 60 minutes,
+// queries the records of the instances again before they expire and drops
+// instances once they are withdrawn or their records expire.
+type Browser struct {
+	lock    sync.RWMutex
+	entries map[string]*ServiceEntry
+	onEvent func(DiscoveryEvent)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewBrowser starts browsing for all services of a given type in a given
+// domain. The options configure the resolver used by the browser. The browser
+// runs until it is closed.
+func NewBrowser(service, domain string, opts ...ClientOption) (*Browser, error) {
+	r, err := NewResolver(opts...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Browser{
+		entries: make(map[string]*ServiceEntry),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	events := make(chan DiscoveryEvent)
+	if err := r.BrowseEvents(ctx, service, domain, events); err != nil {
+		cancel()
+		return nil, err
+	}
+	go b.run(events)
+	return b, nil
+}
+
+// run applies the events of the browse to the view.
+func (b *Browser) run(events <-chan DiscoveryEvent) {
+	defer close(b.done)
+	for ev := range events {
+		key := ev.Entry.ServiceInstanceName()
+		b.lock.Lock()
+		if ev.Type == EntryRemoved {
+			delete(b.entries, key)
+		} else {
+			b.entries[key] = ev.Entry
+		}
+		onEvent := b.onEvent
+		b.lock.Unlock()
+		if onEvent != nil {
+			onEvent(ev)
+		}
+	}
+}
+
+// Entries returns the service instances currently available, ordered by
+// their instance names. The entries must not be modified.
+func (b *Browser) Entries() []*ServiceEntry {
+	b.lock.RLock()
+	entries := make([]*ServiceEntry, 0, len(b.entries))
+	for _, e := range b.entries {
+		entries = append(entries, e)
+	}
+	b.lock.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ServiceInstanceName() < entries[j].ServiceInstanceName()
+	})
+	return entries
+}
+
+// OnEvent sets a function which is called for every change of the view,
+// after it was applied. It is called from the browsing routine and should
+// not block. Passing nil removes it.
+func (b *Browser) OnEvent(f func(DiscoveryEvent)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.onEvent = f
+}
+
+// Close stops browsing and waits until the browser has shut down. The view
+// keeps the instances last seen.
+func (b *Browser) Close() {
+	b.cancel()
+	<-b.done
+}
@@ -0,0 +1,105 @@
+package zeroconf
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Meta-query name enumerating the service types of the .local domain
+// (RFC 6763 section 9)
+const serviceTypesName = "_services._dns-sd._udp.local."
+
+// BrowseTypes discovers the service types present on the network with the
+// service type enumeration meta-query. Each type, e.g. "_http._tcp", is sent
+// once on the channel, which is closed when the context expires. The query is
+// repeated with the intervals of a continuous browse, listing the types found
+// as known answers. Like Browse, it consumes the resolver.
+func (r *Resolver) BrowseTypes(ctx context.Context, types chan<- string) error {
+	c := r.c
+	msgCh := make(chan *inbound, 32)
+	if c.ipv4conn != nil {
+		go c.recv(ctx, c.ipv4conn, msgCh)
+	}
+	if c.ipv6conn != nil {
+		go c.recv(ctx, c.ipv6conn, msgCh)
+	}
+
+	records := newRecordCache()
+	if err := c.queryTypes(records); err != nil {
+		c.shutdown()
+		return err
+	}
+	go func() {
+		defer c.shutdown()
+		defer close(types)
+
+		seen := make(map[string]bool)
+		wait := continuousQueryMin
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		for {
+			select {
+			case in := <-msgCh:
+				now := time.Now()
+				for _, rr := range append(in.msg.Answer, in.msg.Extra...) {
+					ptr, ok := rr.(*dns.PTR)
+					if !ok || !strings.EqualFold(ptr.Hdr.Name, serviceTypesName) {
+						continue
+					}
+					records.add(ptr, now)
+					t := serviceTypeOf(ptr.Ptr)
+					if ptr.Hdr.Ttl == 0 || t == "" || seen[t] {
+						continue
+					}
+					seen[t] = true
+					records.setDelivered(ptr.Ptr)
+					select {
+					case types <- t:
+					case <-ctx.Done():
+						return
+					}
+				}
+			case <-timer.C:
+				if err := c.queryTypes(records); err != nil {
+					c.log().Debugf("Failed to query service types: %v", err)
+				}
+				if wait *= 2; wait > continuousQueryMax {
+					wait = continuousQueryMax
+				}
+				timer.Reset(wait)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// queryTypes sends the service type enumeration query, listing the fresh
+// types found so far as known answers.
+func (c *client) queryTypes(records *recordCache) error {
+	m := new(dns.Msg)
+	m.SetQuestion(serviceTypesName, dns.TypePTR)
+	m.RecursionDesired = false
+	m.Answer = records.knownAnswers(m.Question, time.Now())
+	m.Compress = true
+	for _, part := range splitQuery(m, maxQuerySize) {
+		if err := c.sendQuery(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serviceTypeOf returns the service type of a name listed by the service type
+// enumeration, without the domain, or an empty string for other names.
+func serviceTypeOf(name string) string {
+	const domain = ".local."
+	if len(name) <= len(domain) || !strings.EqualFold(name[len(name)-len(domain):], domain) {
+		return ""
+	}
+	return strings.ToLower(name[:len(name)-len(domain)])
+}
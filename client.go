@@ -3,9 +3,9 @@ package zeroconf
 import (
 	"context"
 	"fmt"
-	"log"
 	"net"
 	"strings"
+	"sync/atomic"
 
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
@@ -29,9 +29,42 @@ const (
 	IPv4AndIPv6 = (IPv4 | IPv6) //< Default option.
 )
 
+// AddrPreference specifies which address families of resolved hosts a client
+// asks for, keeps and lists first.
+type AddrPreference uint8
+
+// Options for AddrPreference.
+const (
+	NoAddrPreference AddrPreference = iota //< Default option.
+	PreferIPv4                             // IPv4 addresses are listed first
+	PreferIPv6                             // IPv6 addresses are listed first
+	IPv4Only                               // IPv6 addresses are dropped
+	IPv6Only                               // IPv4 addresses are dropped
+)
+
+// wantsIPv4 reports whether IPv4 addresses are asked for and kept.
+func (p AddrPreference) wantsIPv4() bool {
+	return p != IPv6Only
+}
+
+// wantsIPv6 reports whether IPv6 addresses are asked for and kept.
+func (p AddrPreference) wantsIPv6() bool {
+	return p != IPv4Only
+}
+
 type clientOpts struct {
 	listenOn IPType
 	ifaces   []net.Interface
+	hideOwn  bool
+	capture  *PcapWriter
+	addrPref AddrPreference
+	servers  []string
+	verify   time.Duration
+	history  int
+	group    multicastGroup
+	noLoop   bool
+	legacy   bool
+	logger   Logger
 }
 
 // ClientOption fills the option struct to configure intefaces, etc.
@@ -55,6 +88,90 @@ func SelectIfaces(ifaces []net.Interface) ClientOption {
 	}
 }
 
+// SelectAddrPreference selects which address families of resolved hosts are
+// queried for and kept, and which family is listed first by
+// ServiceEntry.V2. This is independent of the IP traffic the client uses.
+func SelectAddrPreference(p AddrPreference) ClientOption {
+	return func(o *clientOpts) {
+		o.addrPref = p
+	}
+}
+
+// SelectUnicastServers selects the DNS servers (host:port) used to browse and
+// look up services in domains other than "local". By default, the servers of
+// the system configuration (/etc/resolv.conf) are used.
+func SelectUnicastServers(servers ...string) ClientOption {
+	return func(o *clientOpts) {
+		o.servers = servers
+	}
+}
+
+// VerifyAdditionals accepts TXT and address records from the Additional
+// section for fast results, but queries them directly after the given delay.
+// If the answers differ from what was delivered, the entry is delivered again
+// with the verified data. This guards against responders and reflectors
+// sending stale additional records.
+func VerifyAdditionals(delay time.Duration) ClientOption {
+	return func(o *clientOpts) {
+		o.verify = delay
+	}
+}
+
+// KeepHistory sets the number of discovery events retained for
+// Resolver.History. A size of zero disables the history. By default, the
+// last 256 events are kept.
+func KeepHistory(size int) ClientOption {
+	return func(o *clientOpts) {
+		o.history = size
+	}
+}
+
+// HideOwnServices controls whether services registered by this process are
+// filtered from browse and lookup results. By default, they are included.
+func HideOwnServices(hide bool) ClientOption {
+	return func(o *clientOpts) {
+		o.hideOwn = hide
+	}
+}
+
+// SelectMulticastGroup makes the resolver use other multicast groups and
+// another port than 224.0.0.251, ff02::fb and 5353, e.g. to run tests on an
+// isolated mDNS network. A nil address keeps the default group of its family.
+func SelectMulticastGroup(ipv4, ipv6 net.IP, port int) ClientOption {
+	return func(o *clientOpts) {
+		o.group = newMulticastGroup(ipv4, ipv6, port)
+	}
+}
+
+// SelectMulticastLoopback controls whether the queries of the resolver are
+// looped back to the host, so responders in the same process or on the same
+// host see them. Loopback is enabled by default regardless of the OS default.
+func SelectMulticastLoopback(enabled bool) ClientOption {
+	return func(o *clientOpts) {
+		o.noLoop = !enabled
+	}
+}
+
+// SelectEphemeralPort makes the resolver send one-shot legacy queries
+// (RFC 6762 section 6.7) from a random port instead of joining the multicast
+// groups on port 5353, so it works where the port is held by another daemon.
+// Responders answer such queries with conventional unicast DNS replies, whose
+// records have a TTL of at most ten seconds. Announcements and the answers to
+// the queries of other hosts are not received.
+func SelectEphemeralPort() ClientOption {
+	return func(o *clientOpts) {
+		o.legacy = true
+	}
+}
+
+// CaptureTraffic writes all mDNS packets sent and received by the resolver to
+// the given pcap writer.
+func CaptureTraffic(pw *PcapWriter) ClientOption {
+	return func(o *clientOpts) {
+		o.capture = pw
+	}
+}
+
 // Resolver acts as entry point for service lookups and to browse the DNS-SD.
 type Resolver struct {
 	c *client
@@ -66,6 +183,7 @@ func NewResolver(options ...ClientOption) (*Resolver, error) {
 	// Apply default configuration and load supplied options.
 	var conf = clientOpts{
 		listenOn: IPv4AndIPv6,
+		history:  defaultEventLogSize,
 	}
 	for _, o := range options {
 		if o != nil {
@@ -82,17 +200,41 @@ func NewResolver(options ...ClientOption) (*Resolver, error) {
 	}, nil
 }
 
-// Browse for all services of a given type in a given domain.
-func (r *Resolver) Browse(ctx context.Context, service, domain string, entries chan<- *ServiceEntry) error {
-	params := defaultParams(service)
-	if domain != "" {
-		params.Domain = domain
-	}
-	params.Entries = entries
+// Browse for all services of a given type in a given domain. The type may name
+// a subtype (e.g. _printer._sub._http._tcp) to browse for the services
+// registered with it only. Delivered entries are queried again before their
+// records expire, as RFC 6762 section 5.2 prescribes; an entry whose records
+// expired is delivered again if it reappears.
+func (r *Resolver) Browse(ctx context.Context, service, domain string, entries chan<- *ServiceEntry, opts ...LookupOption) error {
+	params := newParams("", service, domain, entries)
+	params.apply(opts)
+	return r.browse(ctx, params)
+}
+
+// BrowseEvents browses for all services of a given type in a given domain and
+// reports the changes of the discovered entries: EntryAdded when an entry is
+// resolved for the first time, EntryUpdated when its SRV, TXT or address
+// records change, and EntryRemoved when it is withdrawn with a goodbye packet
+// or its records expire. The Entry of an event is the complete entry after the
+// change. Unlike Browse, it keeps querying for instances after the first one
+// is resolved. The events channel is closed when the context expires.
+func (r *Resolver) BrowseEvents(ctx context.Context, service, domain string, events chan<- DiscoveryEvent, opts ...LookupOption) error {
+	params := newParams("", service, domain, nil)
+	params.events = events
+	params.apply(opts)
+	return r.browse(ctx, params)
+}
+
+func (r *Resolver) browse(ctx context.Context, params *LookupParams) error {
 	ctx, cancel := context.WithCancel(ctx)
+	params.cancel = cancel
+	if !isLocalDomain(params.Domain) {
+		go r.c.resolveUnicast(ctx, params)
+		return nil
+	}
 	go r.c.mainloop(ctx, params)
 
-	err := r.c.query(params)
+	err := r.c.query(params, params.unicast)
 	if err != nil {
 		cancel()
 		return err
@@ -108,17 +250,61 @@ func (r *Resolver) Browse(ctx context.Context, service, domain string, entries c
 	return nil
 }
 
-// Lookup a specific service by its name and type in a given domain.
-func (r *Resolver) Lookup(ctx context.Context, instance, service, domain string, entries chan<- *ServiceEntry) error {
-	params := defaultParams(service)
-	params.Instance = instance
-	if domain != "" {
-		params.Domain = domain
+// Resolve looks up a service instance and waits until it is resolved. If the
+// context expires first, the entries resolved so far are returned, including
+// partial ones flagged by their Missing field, together with the context's
+// error. Like Lookup, it consumes the resolver.
+func (r *Resolver) Resolve(ctx context.Context, instance, service, domain string, opts ...LookupOption) ([]*ServiceEntry, error) {
+	entries := make(chan *ServiceEntry)
+	opts = append(opts, WithPartialResults(), WithMaxResults(1))
+	if err := r.Lookup(ctx, instance, service, domain, entries, opts...); err != nil {
+		return nil, err
+	}
+	var results []*ServiceEntry
+	for e := range entries {
+		results = append(results, e)
+	}
+	if len(results) == 0 || results[len(results)-1].Missing != 0 {
+		return results, ctx.Err()
+	}
+	return results, nil
+}
+
+// LookupInstance looks up a service instance and waits until its host name,
+// port and addresses are resolved. If the context expires first, it returns
+// the context's error; if the lookup ends without finding the instance, a
+// *net.DNSError. Like Lookup, it consumes the resolver.
+func (r *Resolver) LookupInstance(ctx context.Context, instance, service, domain string, opts ...LookupOption) (*ServiceEntry, error) {
+	results, err := r.Resolve(ctx, instance, service, domain, opts...)
+	if err != nil {
+		return nil, err
 	}
-	params.Entries = entries
+	if len(results) == 0 {
+		// The lookup ended without any result, e.g. as the queries failed
+		name := NewServiceRecord(instance, service, domain).ServiceInstanceName()
+		return nil, &net.DNSError{Err: "no such service instance", Name: name, IsNotFound: true}
+	}
+	return results[len(results)-1], nil
+}
+
+// CacheSize returns the number of service entries held by the running
+// lookups, delivered ones as well as those waiting for their addresses.
+func (r *Resolver) CacheSize() int {
+	return int(atomic.LoadInt64(&r.c.cached))
+}
+
+// Lookup a specific service by its name and type in a given domain.
+func (r *Resolver) Lookup(ctx context.Context, instance, service, domain string, entries chan<- *ServiceEntry, opts ...LookupOption) error {
+	params := newParams(instance, service, domain, entries)
+	params.apply(opts)
 	ctx, cancel := context.WithCancel(ctx)
+	params.cancel = cancel
+	if !isLocalDomain(params.Domain) {
+		go r.c.resolveUnicast(ctx, params)
+		return nil
+	}
 	go r.c.mainloop(ctx, params)
-	err := r.c.query(params)
+	err := r.c.query(params, params.unicast)
 	if err != nil {
 		// cancel mainloop
 		cancel()
@@ -135,16 +321,33 @@ func (r *Resolver) Lookup(ctx context.Context, instance, service, domain string,
 	return nil
 }
 
-// defaultParams returns a default set of QueryParams.
-func defaultParams(service string) *LookupParams {
-	return NewLookupParams("", service, "local", make(chan *ServiceEntry))
+// newParams returns the QueryParams for a browse or lookup. The domain
+// defaults to "local".
+func newParams(instance, service, domain string, entries chan<- *ServiceEntry) *LookupParams {
+	if domain == "" {
+		domain = "local"
+	}
+	return NewLookupParams(instance, service, domain, entries)
 }
 
 // Client structure encapsulates both IPv4/IPv6 UDP connections.
 type client struct {
+	// Number of entries held by the running lookups, updated atomically.
+	// It is the first field to keep it 64-bit aligned.
+	cached int64
+
 	ipv4conn *ipv4.PacketConn
 	ipv6conn *ipv6.PacketConn
 	ifaces   []net.Interface
+	hideOwn  bool
+	capture  *PcapWriter
+	addrPref AddrPreference
+	servers  []string
+	verify   time.Duration
+	events   *eventLog
+	group    multicastGroup
+	legacy   bool
+	logger   Logger
 }
 
 // Client structure constructor
@@ -157,58 +360,128 @@ func newClient(opts clientOpts) (*client, error) {
 	var ipv4conn *ipv4.PacketConn
 	if (opts.listenOn & IPv4) > 0 {
 		var err error
-		ipv4conn, err = joinUdp4Multicast(ifaces)
+		if opts.legacy {
+			ipv4conn, err = listenUdp4Ephemeral()
+		} else {
+			ipv4conn, err = joinUdp4Multicast(ifaces, opts.group, socketOpts{reusePort: true})
+		}
 		if err != nil {
 			return nil, err
 		}
+		if opts.noLoop {
+			ipv4conn.SetMulticastLoopback(false)
+		}
 	}
 	// IPv6 interfaces
 	var ipv6conn *ipv6.PacketConn
 	if (opts.listenOn & IPv6) > 0 {
 		var err error
-		ipv6conn, err = joinUdp6Multicast(ifaces)
+		if opts.legacy {
+			ipv6conn, err = listenUdp6Ephemeral()
+		} else {
+			ipv6conn, err = joinUdp6Multicast(ifaces, opts.group, socketOpts{reusePort: true})
+		}
 		if err != nil {
 			return nil, err
 		}
+		if opts.noLoop {
+			ipv6conn.SetMulticastLoopback(false)
+		}
 	}
 
 	return &client{
 		ipv4conn: ipv4conn,
 		ipv6conn: ipv6conn,
 		ifaces:   ifaces,
+		hideOwn:  opts.hideOwn,
+		capture:  opts.capture,
+		addrPref: opts.addrPref,
+		servers:  opts.servers,
+		verify:   opts.verify,
+		group:    opts.group.orDefault(),
+		legacy:   opts.legacy,
+		logger:   opts.logger,
+		events:   newEventLog(opts.history),
 	}, nil
 }
 
 // Start listeners and waits for the shutdown signal from exit channel
 func (c *client) mainloop(ctx context.Context, params *LookupParams) {
 	// start listening for responses
-	msgCh := make(chan *dns.Msg, 32)
+	msgCh := make(chan *inbound, 32)
 	if c.ipv4conn != nil {
 		go c.recv(ctx, c.ipv4conn, msgCh)
 	}
 	if c.ipv6conn != nil {
 		go c.recv(ctx, c.ipv6conn, msgCh)
 	}
+	c.processMsgs(ctx, params, msgCh)
+}
 
+// processMsgs collects service entries from received messages and delivers
+// them to the subscriber until the context expires.
+func (c *client) processMsgs(ctx context.Context, params *LookupParams, msgCh <-chan *inbound) {
 	// Iterate through channels from listeners goroutines
 	var entries, sentEntries map[string]*ServiceEntry
 	sentEntries = make(map[string]*ServiceEntry)
+	// Entries lacking addresses, waiting for address records of their host
+	pending := make(map[string]*ServiceEntry)
+	addrQueried := make(map[string]time.Time)
+	// Number of distinct instances delivered
+	var delivered int
+	// Entries using records of the Additional section, and delivered entries
+	// waiting for their verification
+	unverified := make(map[string]bool)
+	verifying := make(map[string]*ServiceEntry)
+	// Origin of the last message
+	var from net.Addr
+	// Entries lacking an SRV record, kept for partial results
+	partial := make(map[string]*ServiceEntry)
+	// Whether the lookup completed before the context expired
+	var complete bool
+	// Number of entries last added to the resolver's cache size
+	var cached int
+	defer func() { atomic.AddInt64(&c.cached, -int64(cached)) }()
+	// TTLs of the received records. Delivered entries are queried again
+	// before their records expire and removed once they do.
+	records := params.records
+	if records == nil {
+		records = newRecordCache()
+	}
+	expiryCheck := time.NewTicker(time.Second)
+	defer expiryCheck.Stop()
 	for {
+		if n := len(sentEntries) + len(pending); n != cached {
+			atomic.AddInt64(&c.cached, int64(n-cached))
+			cached = n
+		}
 		select {
 		case <-ctx.Done():
 			// Context expired. Notify subscriber that we are done here.
+			if params.partial && !complete {
+				deliverPartial(params, sentEntries, pending, partial)
+			}
 			params.done()
 			c.shutdown()
 			return
-		case msg := <-msgCh:
+		case now := <-expiryCheck.C:
+			c.expireRecords(params, records, sentEntries, verifying, now)
+		case in := <-msgCh:
+			msg := in.msg
+			from = in.from
 			entries = make(map[string]*ServiceEntry)
 			sections := append(msg.Answer, msg.Ns...)
+			extraStart := len(sections)
 			sections = append(sections, msg.Extra...)
 
-			for _, answer := range sections {
+			if len(verifying) > 0 {
+				c.checkVerified(params, in, verifying, sentEntries)
+			}
+
+			for i, answer := range sections {
 				switch rr := answer.(type) {
 				case *dns.PTR:
-					if params.ServiceName() != rr.Hdr.Name {
+					if params.browseName() != rr.Hdr.Name {
 						continue
 					}
 					if params.ServiceInstanceName() != "" && params.ServiceInstanceName() != rr.Ptr {
@@ -216,7 +489,7 @@ func (c *client) mainloop(ctx context.Context, params *LookupParams) {
 					}
 					if _, ok := entries[rr.Ptr]; !ok {
 						entries[rr.Ptr] = NewServiceEntry(
-							trimDot(strings.Replace(rr.Ptr, rr.Hdr.Name, "", -1)),
+							trimDot(strings.Replace(rr.Ptr, params.ServiceName(), "", -1)),
 							params.Service,
 							params.Domain)
 					}
@@ -235,6 +508,8 @@ func (c *client) mainloop(ctx context.Context, params *LookupParams) {
 					}
 					entries[rr.Hdr.Name].HostName = rr.Target
 					entries[rr.Hdr.Name].Port = int(rr.Port)
+					entries[rr.Hdr.Name].priority = rr.Priority
+					entries[rr.Hdr.Name].weight = rr.Weight
 					entries[rr.Hdr.Name].TTL = rr.Hdr.Ttl
 				case *dns.TXT:
 					if params.ServiceInstanceName() != "" && params.ServiceInstanceName() != rr.Hdr.Name {
@@ -250,48 +525,147 @@ func (c *client) mainloop(ctx context.Context, params *LookupParams) {
 					}
 					entries[rr.Hdr.Name].Text = rr.Txt
 					entries[rr.Hdr.Name].TTL = rr.Hdr.Ttl
+					if i >= extraStart {
+						unverified[rr.Hdr.Name] = true
+					}
 				}
 			}
+			// Remember where and when the records were received.
+			now := time.Now()
+			for _, e := range entries {
+				e.ifIndex = in.ifIndex
+				e.received = now
+			}
+			c.trackRecords(params, records, sections, entries, pending, sentEntries, now)
 			// Associate IPs in a second round as other fields should be filled by now.
-			for _, answer := range sections {
+			for i, answer := range sections {
 				switch rr := answer.(type) {
 				case *dns.A:
+					if !c.addrPref.wantsIPv4() {
+						continue
+					}
+					c.addrRefresh(params, rr.Hdr, entries, sentEntries, in.ifIndex, now)
 					for k, e := range entries {
 						if e.HostName == rr.Hdr.Name {
 							entries[k].AddrIPv4 = append(entries[k].AddrIPv4, rr.A)
+							unverified[k] = unverified[k] || i >= extraStart
+						}
+					}
+					for _, e := range pending {
+						if e.HostName == rr.Hdr.Name {
+							e.AddrIPv4 = append(e.AddrIPv4, rr.A)
 						}
 					}
 				case *dns.AAAA:
+					if !c.addrPref.wantsIPv6() {
+						continue
+					}
+					c.addrRefresh(params, rr.Hdr, entries, sentEntries, in.ifIndex, now)
 					for k, e := range entries {
 						if e.HostName == rr.Hdr.Name {
 							entries[k].AddrIPv6 = append(entries[k].AddrIPv6, rr.AAAA)
+							unverified[k] = unverified[k] || i >= extraStart
+						}
+					}
+					for _, e := range pending {
+						if e.HostName == rr.Hdr.Name {
+							e.AddrIPv6 = append(e.AddrIPv6, rr.AAAA)
 						}
 					}
 				}
 			}
+			// Pending entries which are resolved by now are delivered, unless
+			// this message carried newer data for them.
+			for k, e := range pending {
+				if len(e.AddrIPv4) == 0 && len(e.AddrIPv6) == 0 {
+					continue
+				}
+				delete(pending, k)
+				if _, ok := entries[k]; !ok {
+					entries[k] = e
+				}
+			}
 		}
 
 		if len(entries) > 0 {
 			for k, e := range entries {
 				if e.TTL == 0 {
+					if sent, ok := sentEntries[k]; ok {
+						c.events.add(EntryRemoved, sent, from)
+						params.deliver(EntryRemoved, sent, from)
+					}
 					delete(entries, k)
 					delete(sentEntries, k)
+					records.forget(k)
+					delete(pending, k)
+					delete(partial, k)
+					delete(unverified, k)
+					delete(verifying, k)
+					continue
+				}
+				if sent, ok := sentEntries[k]; ok {
+					if params.events != nil {
+						updated := mergeUpdate(sent, e)
+						sentEntries[k] = updated
+						if !Equal(sent, updated) {
+							c.events.add(EntryUpdated, updated, from)
+							params.deliver(EntryUpdated, updated, from)
+						}
+					}
 					continue
 				}
-				if _, ok := sentEntries[k]; ok {
+				if params.maxResults > 0 && delivered >= params.maxResults {
 					continue
 				}
-				// Require at least one resolved IP address for ServiceEntry
-				// TODO: wait some more time as chances are high both will arrive.
+				// A subtype browse only takes instances pointed to by the
+				// subtype, not all instances of the type.
+				if len(params.Subtypes) > 0 && params.Instance == "" && !records.has(params.browseName(), dns.TypePTR, k) {
+					continue
+				}
+				// Require at least one resolved IP address for ServiceEntry.
+				// Otherwise, ask for the addresses of its host and keep it
+				// until they arrive.
 				if len(e.AddrIPv4) == 0 && len(e.AddrIPv6) == 0 {
+					if e.HostName == "" {
+						if params.partial {
+							partial[k] = mergePartial(partial[k], e)
+						}
+					} else {
+						pending[k] = e
+						if now := time.Now(); now.Sub(addrQueried[e.HostName]) > time.Second {
+							addrQueried[e.HostName] = now
+							c.queryAddrs(e.HostName)
+						}
+					}
+					continue
+				}
+				e.addrPref = c.addrPref
+				if c.hideOwn && isOwnService(e) {
 					continue
 				}
 				// Submit entry to subscriber and cache it.
 				// This is also a point to possibly stop probing actively for a
 				// service entry.
-				params.Entries <- e
+				params.deliver(EntryAdded, e, from)
 				sentEntries[k] = e
-				params.disableProbing()
+				records.setDelivered(k)
+				c.events.add(EntryAdded, e, from)
+				delete(partial, k)
+				if params.events == nil {
+					params.disableProbing()
+				}
+				if c.verify > 0 && unverified[k] {
+					verifying[k] = e
+					time.AfterFunc(c.verify, func() { c.queryVerify(e) })
+				}
+				delete(unverified, k)
+				delivered++
+				if params.maxResults > 0 && delivered >= params.maxResults {
+					// Enough results. Stop like on an expired context.
+					complete = true
+					params.cancel()
+					break
+				}
 			}
 			// reset entries
 			entries = make(map[string]*ServiceEntry)
@@ -299,30 +673,343 @@ func (c *client) mainloop(ctx context.Context, params *LookupParams) {
 	}
 }
 
+// mergePartial merges the records of an incomplete entry into the partial
+// entry collected so far.
+func mergePartial(old, e *ServiceEntry) *ServiceEntry {
+	if old == nil {
+		return e
+	}
+	if len(e.Text) > 0 {
+		old.Text = e.Text
+	}
+	if e.TTL > 0 {
+		old.TTL = e.TTL
+	}
+	return old
+}
+
+// addrRefresh makes room for the address record of the host of a delivered
+// entry which is received without the entry's SRV record, so a changed
+// address is reported when browsing for events. Goodbye records for addresses
+// are ignored, since they do not withdraw the service.
+func (c *client) addrRefresh(params *LookupParams, hdr dns.RR_Header, entries, sentEntries map[string]*ServiceEntry, ifIndex int, now time.Time) {
+	if params.events == nil || hdr.Ttl == 0 {
+		return
+	}
+	for k, sent := range sentEntries {
+		if _, ok := entries[k]; ok || sent.HostName != hdr.Name {
+			continue
+		}
+		entries[k] = &ServiceEntry{
+			ServiceRecord: sent.ServiceRecord,
+			HostName:      sent.HostName,
+			Port:          sent.Port,
+			TTL:           hdr.Ttl,
+			priority:      sent.priority,
+			weight:        sent.weight,
+			ifIndex:       ifIndex,
+			received:      now,
+		}
+	}
+}
+
+// mergeUpdate merges the records of a received entry into a copy of the entry
+// delivered before. The addresses of a family are replaced if the received
+// entry has any.
+func mergeUpdate(sent, e *ServiceEntry) *ServiceEntry {
+	updated := *sent
+	if e.HostName != "" {
+		updated.HostName = e.HostName
+		updated.Port = e.Port
+		updated.priority = e.priority
+		updated.weight = e.weight
+	}
+	if e.Text != nil {
+		updated.Text = e.Text
+	}
+	if len(e.AddrIPv4) > 0 {
+		updated.AddrIPv4 = e.AddrIPv4
+	}
+	if len(e.AddrIPv6) > 0 {
+		updated.AddrIPv6 = e.AddrIPv6
+	}
+	updated.TTL = e.TTL
+	updated.ifIndex = e.ifIndex
+	updated.received = e.received
+	return &updated
+}
+
+// trackRecords starts tracking the TTLs of the received records of service
+// instances matching the lookup and of the addresses of their hosts.
+func (c *client) trackRecords(params *LookupParams, records *recordCache, sections []dns.RR, entries, pending, sentEntries map[string]*ServiceEntry, now time.Time) {
+	isHost := func(name string) bool {
+		for _, m := range []map[string]*ServiceEntry{entries, pending, sentEntries} {
+			for _, e := range m {
+				if strings.EqualFold(e.HostName, name) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, rr := range sections {
+		var instance string
+		switch rr := rr.(type) {
+		case *dns.PTR:
+			if rr.Hdr.Name != params.browseName() {
+				continue
+			}
+			instance = rr.Ptr
+		case *dns.SRV, *dns.TXT:
+			instance = rr.Header().Name
+			if !strings.HasSuffix(instance, params.ServiceName()) {
+				continue
+			}
+		case *dns.A, *dns.AAAA:
+			if isHost(rr.Header().Name) {
+				records.add(rr, now)
+			}
+			continue
+		default:
+			continue
+		}
+		if params.ServiceInstanceName() == "" || params.ServiceInstanceName() == instance {
+			records.add(rr, now)
+		}
+	}
+}
+
+// expireRecords queries the records of delivered entries which are about to
+// expire, and removes the entries whose PTR and SRV records expired. When
+// browsing for events, expired addresses are removed from the entries.
+func (c *client) expireRecords(params *LookupParams, records *recordCache, sentEntries, verifying map[string]*ServiceEntry, now time.Time) {
+	expired, questions := records.expire(now)
+	if len(questions) > 0 {
+		m := new(dns.Msg)
+		m.RecursionDesired = false
+		for _, q := range questions {
+			if isQueried(q, params, sentEntries) {
+				m.Question = append(m.Question, q)
+			}
+		}
+		if len(m.Question) > 0 {
+			if err := c.sendQuery(m); err != nil {
+				c.log().Debugf("Failed to query expiring records: %v", err)
+			}
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	for k, sent := range sentEntries {
+		if records.has(params.browseName(), dns.TypePTR, k) || records.has(k, dns.TypeSRV, "") {
+			continue
+		}
+		delete(sentEntries, k)
+		delete(verifying, k)
+		records.forget(k)
+		c.events.add(EntryRemoved, sent, nil)
+		params.deliver(EntryRemoved, sent, nil)
+	}
+	if params.events == nil {
+		return
+	}
+	for _, rk := range expired {
+		if rk.rrtype != dns.TypeA && rk.rrtype != dns.TypeAAAA {
+			continue
+		}
+		for k, sent := range sentEntries {
+			if !strings.EqualFold(sent.HostName, rk.name) {
+				continue
+			}
+			updated := *sent
+			updated.AddrIPv4 = withoutAddr(sent.AddrIPv4, rk.data)
+			updated.AddrIPv6 = withoutAddr(sent.AddrIPv6, rk.data)
+			if len(updated.AddrIPv4)+len(updated.AddrIPv6) == 0 || Equal(sent, &updated) {
+				continue
+			}
+			sentEntries[k] = &updated
+			c.events.add(EntryUpdated, &updated, nil)
+			params.deliver(EntryUpdated, &updated, nil)
+		}
+	}
+}
+
+// isQueried reports whether a record due to be queried again belongs to a
+// delivered entry.
+func isQueried(q dns.Question, params *LookupParams, sentEntries map[string]*ServiceEntry) bool {
+	if q.Qtype == dns.TypePTR {
+		return len(sentEntries) > 0 && strings.EqualFold(q.Name, params.browseName())
+	}
+	for k, e := range sentEntries {
+		switch q.Qtype {
+		case dns.TypeSRV, dns.TypeTXT:
+			if strings.EqualFold(q.Name, k) {
+				return true
+			}
+		case dns.TypeA, dns.TypeAAAA:
+			if strings.EqualFold(q.Name, e.HostName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withoutAddr returns the addresses without the given one.
+func withoutAddr(addrs []net.IP, addr string) []net.IP {
+	var kept []net.IP
+	for _, ip := range addrs {
+		if ip.String() != addr {
+			kept = append(kept, ip)
+		}
+	}
+	return kept
+}
+
+// deliverPartial delivers the entries which were not completely resolved,
+// flagging the data they lack.
+func deliverPartial(params *LookupParams, sentEntries, pending, partial map[string]*ServiceEntry) {
+	for k, e := range pending {
+		if _, ok := sentEntries[k]; ok {
+			continue
+		}
+		if p, ok := partial[k]; ok && len(e.Text) == 0 {
+			e.Text = p.Text
+		}
+		e.Missing = MissingAddrs
+		params.deliver(EntryAdded, e, nil)
+	}
+	for k, e := range partial {
+		if _, ok := sentEntries[k]; ok {
+			continue
+		}
+		if _, ok := pending[k]; ok {
+			continue
+		}
+		e.Missing = MissingSRV | MissingAddrs
+		params.deliver(EntryAdded, e, nil)
+	}
+}
+
+// queryVerify queries the SRV, TXT and address records of a delivered entry
+// directly, so they are received in the Answer section.
+func (c *client) queryVerify(e *ServiceEntry) error {
+	m := new(dns.Msg)
+	m.RecursionDesired = false
+	m.Question = []dns.Question{
+		{Name: e.ServiceInstanceName(), Qtype: dns.TypeSRV, Qclass: dns.ClassINET},
+		{Name: e.ServiceInstanceName(), Qtype: dns.TypeTXT, Qclass: dns.ClassINET},
+	}
+	if c.addrPref.wantsIPv4() {
+		m.Question = append(m.Question, dns.Question{Name: e.HostName, Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	}
+	if c.addrPref.wantsIPv6() {
+		m.Question = append(m.Question, dns.Question{Name: e.HostName, Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	}
+	return c.sendQuery(m)
+}
+
+// checkVerified compares the Answer section of a message with the entries
+// waiting for verification. An entry whose verified records differ is
+// delivered again with the verified data.
+func (c *client) checkVerified(params *LookupParams, in *inbound, verifying, sentEntries map[string]*ServiceEntry) {
+	for k, old := range verifying {
+		verified := *old
+		var found, v4, v6 bool
+		for _, answer := range in.msg.Answer {
+			switch rr := answer.(type) {
+			case *dns.SRV:
+				if strings.EqualFold(rr.Hdr.Name, k) {
+					verified.HostName = rr.Target
+					verified.Port = int(rr.Port)
+					found = true
+				}
+			case *dns.TXT:
+				if strings.EqualFold(rr.Hdr.Name, k) {
+					verified.Text = rr.Txt
+					found = true
+				}
+			case *dns.A:
+				if c.addrPref.wantsIPv4() && strings.EqualFold(rr.Hdr.Name, old.HostName) {
+					if !v4 {
+						verified.AddrIPv4 = nil
+						v4 = true
+					}
+					verified.AddrIPv4 = append(verified.AddrIPv4, rr.A)
+					found = true
+				}
+			case *dns.AAAA:
+				if c.addrPref.wantsIPv6() && strings.EqualFold(rr.Hdr.Name, old.HostName) {
+					if !v6 {
+						verified.AddrIPv6 = nil
+						v6 = true
+					}
+					verified.AddrIPv6 = append(verified.AddrIPv6, rr.AAAA)
+					found = true
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+		delete(verifying, k)
+		if !Equal(old, &verified) {
+			verified.ifIndex = in.ifIndex
+			verified.received = time.Now()
+			params.deliver(EntryUpdated, &verified, in.from)
+			sentEntries[k] = &verified
+			c.events.add(EntryUpdated, &verified, in.from)
+		}
+	}
+}
+
 // Shutdown client will close currently open connections and channel implicitly.
 func (c *client) shutdown() {
 	if c.ipv4conn != nil {
+		releaseSocket(c.ipv4conn)
 		c.ipv4conn.Close()
 	}
 	if c.ipv6conn != nil {
+		releaseSocket(c.ipv6conn)
 		c.ipv6conn.Close()
 	}
 }
 
+// inbound is a received DNS message along with its origin.
+type inbound struct {
+	msg     *dns.Msg
+	ifIndex int
+	from    net.Addr
+}
+
 // Data receiving routine reads from connection, unpacks packets into dns.Msg
 // structures and sends them to a given msgCh channel
-func (c *client) recv(ctx context.Context, l interface{}, msgCh chan *dns.Msg) {
-	var readFrom func([]byte) (n int, src net.Addr, err error)
+func (c *client) recv(ctx context.Context, l interface{}, msgCh chan *inbound) {
+	var readFrom func([]byte) (n int, ifIndex int, src net.Addr, err error)
 
 	switch pConn := l.(type) {
 	case *ipv6.PacketConn:
-		readFrom = func(b []byte) (n int, src net.Addr, err error) {
-			n, _, src, err = pConn.ReadFrom(b)
+		readFrom = func(b []byte) (n int, ifIndex int, src net.Addr, err error) {
+			var cm *ipv6.ControlMessage
+			n, cm, src, err = pConn.ReadFrom(b)
+			if cm != nil {
+				ifIndex = cm.IfIndex
+			}
 			return
 		}
 	case *ipv4.PacketConn:
-		readFrom = func(b []byte) (n int, src net.Addr, err error) {
-			n, _, src, err = pConn.ReadFrom(b)
+		readFrom = func(b []byte) (n int, ifIndex int, src net.Addr, err error) {
+			var cm *ipv4.ControlMessage
+			n, cm, src, err = pConn.ReadFrom(b)
+			if cm != nil {
+				ifIndex = cm.IfIndex
+			}
+			return
+		}
+	case Transport:
+		readFrom = func(b []byte) (n int, ifIndex int, src net.Addr, err error) {
+			n, ifIndex, _, src, err = pConn.ReadFrom(b)
 			return
 		}
 
@@ -330,7 +1017,9 @@ func (c *client) recv(ctx context.Context, l interface{}, msgCh chan *dns.Msg) {
 		return
 	}
 
-	buf := make([]byte, 65536)
+	bufp := getBuffer()
+	defer putBuffer(bufp)
+	buf := *bufp
 	var fatalErr error
 	for {
 		// Handles the following cases:
@@ -341,18 +1030,19 @@ func (c *client) recv(ctx context.Context, l interface{}, msgCh chan *dns.Msg) {
 			return
 		}
 
-		n, _, err := readFrom(buf)
+		n, ifIndex, from, err := readFrom(buf)
 		if err != nil {
 			fatalErr = err
 			continue
 		}
+		c.capture.write(from, nil, buf[:n])
 		msg := new(dns.Msg)
 		if err := msg.Unpack(buf[:n]); err != nil {
 			// log.Printf("[WARN] mdns: Failed to unpack packet: %v", err)
 			continue
 		}
 		select {
-		case msgCh <- msg:
+		case msgCh <- &inbound{msg: msg, ifIndex: ifIndex, from: from}:
 			// Submit decoded DNS message and continue.
 		case <-ctx.Done():
 			// Abort.
@@ -373,17 +1063,32 @@ func (c *client) periodicQuery(ctx context.Context, params *LookupParams) error
 	bo := backoff.NewExponentialBackOff()
 	bo.InitialInterval = 4 * time.Second
 	bo.MaxInterval = 60 * time.Second
+	// Browsing for events never stops querying. It continues with the
+	// intervals of RFC 6762 section 5.2, after the initial query was sent.
+	continuous := params.events != nil
+	if continuous {
+		bo.InitialInterval = continuousQueryMin
+		bo.Multiplier = 2
+		bo.RandomizationFactor = 0
+		bo.MaxInterval = continuousQueryMax
+		bo.MaxElapsedTime = 0
+	}
 	bo.Reset()
 
-	for {
+	// The initial query was sent already. It is repeated right away, unless
+	// the intervals above apply or it asked for unicast responses, which
+	// should get the chance to arrive first.
+	for first := true; ; first = false {
 		// Do periodic query.
-		if err := c.query(params); err != nil {
-			return err
+		if !(first && (continuous || params.unicast)) {
+			if err := c.query(params, false); err != nil {
+				return err
+			}
 		}
 		// Backoff and cancel logic.
 		wait := bo.NextBackOff()
 		if wait == backoff.Stop {
-			log.Println("periodicQuery: abort due to timeout")
+			c.log().Debugf("periodic query aborted due to timeout")
 			return nil
 		}
 		select {
@@ -403,7 +1108,8 @@ func (c *client) periodicQuery(ctx context.Context, params *LookupParams) error
 
 // Performs the actual query by service name (browse) or service instance name (lookup),
 // start response listeners goroutines and loops over the entries channel.
-func (c *client) query(params *LookupParams) error {
+// With unicast set, the questions ask for unicast responses.
+func (c *client) query(params *LookupParams, unicast bool) error {
 	var serviceName, serviceInstanceName string
 	serviceName = fmt.Sprintf("%s.%s.", trimDot(params.Service), trimDot(params.Domain))
 	if params.Instance != "" {
@@ -419,34 +1125,70 @@ func (c *client) query(params *LookupParams) error {
 		}
 		m.RecursionDesired = false
 	} else {
-		m.SetQuestion(serviceName, dns.TypePTR)
+		m.SetQuestion(params.browseName(), dns.TypePTR)
 		m.RecursionDesired = false
 	}
-	if err := c.sendQuery(m); err != nil {
-		return err
+	if unicast {
+		for i := range m.Question {
+			m.Question[i].Qclass |= qClassUnicastResponse
+		}
+	}
+	// Known answers let responders suppress the records this lookup holds
+	// already (RFC 6762 section 7.1). Legacy queries are answered in full.
+	if !c.legacy {
+		m.Answer = params.records.knownAnswers(m.Question, time.Now())
+	}
+	m.Compress = true
+	for _, part := range splitQuery(m, maxQuerySize) {
+		if err := c.sendQuery(part); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// queryAddrs asks for the address records of a host, restricted to the
+// preferred address families.
+func (c *client) queryAddrs(host string) error {
+	m := new(dns.Msg)
+	m.RecursionDesired = false
+	if c.addrPref.wantsIPv4() {
+		m.Question = append(m.Question, dns.Question{Name: host, Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	}
+	if c.addrPref.wantsIPv6() {
+		m.Question = append(m.Question, dns.Question{Name: host, Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	}
+	return c.sendQuery(m)
+}
+
 // Pack the dns.Msg and write to available connections (multicast)
 func (c *client) sendQuery(msg *dns.Msg) error {
+	// Known answers repeat the service name, which compresses well
+	msg.Compress = true
+	if c.legacy {
+		// Legacy queries carry an ID like conventional DNS queries, which
+		// the replies echo, and announce the size of the receive buffer,
+		// so the replies are not truncated to 512 bytes.
+		msg.Id = dns.Id()
+		if msg.IsEdns0() == nil {
+			msg.SetEdns0(maxMessageSize, false)
+		}
+	}
 	buf, err := msg.Pack()
 	if err != nil {
 		return err
 	}
 	if c.ipv4conn != nil {
-		var wcm ipv4.ControlMessage
 		for ifi := range c.ifaces {
-			wcm.IfIndex = c.ifaces[ifi].Index
-			c.ipv4conn.WriteTo(buf, &wcm, ipv4Addr)
+			writeTo4(c.ipv4conn, buf, c.ifaces[ifi].Index, c.group.ipv4)
+			c.capture.write(nil, c.group.ipv4, buf)
 		}
 	}
 	if c.ipv6conn != nil {
-		var wcm ipv6.ControlMessage
 		for ifi := range c.ifaces {
-			wcm.IfIndex = c.ifaces[ifi].Index
-			c.ipv6conn.WriteTo(buf, &wcm, ipv6Addr)
+			writeTo6(c.ipv6conn, buf, c.ifaces[ifi].Index, c.group.ipv6)
+			c.capture.write(nil, c.group.ipv6, buf)
 		}
 	}
 	return nil
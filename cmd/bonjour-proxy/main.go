@@ -0,0 +1,188 @@
+// Command bonjour-proxy advertises services running on other hosts, e.g. VMs
+// or containers, in the local network. The services are read from a JSON file:
+//
+//	[
+//	  {
+//	    "name": "My NAS",
+//	    "service": "_smb._tcp",
+//	    "host": "nas",
+//	    "ips": ["192.168.1.20"],
+//	    "port": 445,
+//	    "text": ["model=Xserve"]
+//	  }
+//	]
+//
+// Every service is health checked by connecting to its port. Services whose
+// backend stops responding are withdrawn until it responds again. Services
+// without IPs are published with the addresses of the local interfaces and
+// are not health checked. A failed registration is retried with a backoff.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+var (
+	config   = flag.String("config", "services.json", "JSON file listing the services to advertise.")
+	interval = flag.Duration("interval", 10*time.Second, "Interval of the health checks. 0 disables them.")
+	timeout  = flag.Duration("timeout", 2*time.Second, "Timeout of a single health check.")
+	failures = flag.Int("failures", 3, "Number of failed health checks after which a service is withdrawn.")
+)
+
+// Backoff of the retries of a failed registration.
+const (
+	retryMin = time.Second
+	retryMax = time.Minute
+)
+
+// proxyService describes a remote service to advertise.
+type proxyService struct {
+	Name    string   `json:"name"`
+	Service string   `json:"service"`
+	Domain  string   `json:"domain"`
+	Host    string   `json:"host"`
+	IPs     []string `json:"ips"`
+	Port    int      `json:"port"`
+	Text    []string `json:"text"`
+	TTL     uint32   `json:"ttl"`
+}
+
+func main() {
+	flag.Parse()
+
+	f, err := os.Open(*config)
+	if err != nil {
+		log.Fatalln("Failed to open config:", err)
+	}
+	var services []proxyService
+	err = json.NewDecoder(f).Decode(&services)
+	f.Close()
+	if err != nil {
+		log.Fatalln("Failed to parse config:", err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, svc := range services {
+		wg.Add(1)
+		go func(svc proxyService) {
+			defer wg.Done()
+			advertise(svc, stop)
+		}(svc)
+	}
+
+	// Clean exit.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	log.Println("Shutting down.")
+	close(stop)
+	wg.Wait()
+}
+
+// advertise keeps the service registered while its backend is healthy.
+func advertise(svc proxyService, stop <-chan struct{}) {
+	var server *zeroconf.Server
+	defer func() {
+		if server != nil {
+			server.Shutdown()
+		}
+	}()
+
+	var failed int
+	var retry time.Duration
+	for {
+		healthy := *interval == 0 || check(svc)
+		if healthy {
+			failed = 0
+		} else {
+			failed++
+		}
+
+		switch {
+		case healthy && server == nil:
+			var err error
+			server, err = register(svc)
+			if err != nil {
+				retry = nextRetry(retry)
+				log.Printf("Failed to register %q, retrying in %v: %v", svc.Name, retry, err)
+			} else {
+				retry = 0
+				log.Printf("Published %q (%s) at %s:%d", svc.Name, svc.Service, svc.Host, svc.Port)
+			}
+		case !healthy && server != nil && failed >= *failures:
+			log.Printf("Withdrawing %q: backend not responding", svc.Name)
+			server.Shutdown()
+			server = nil
+		}
+
+		var tc <-chan time.Time
+		switch {
+		case healthy && server == nil && retry > 0:
+			tc = time.After(retry)
+		case *interval > 0:
+			tc = time.After(*interval)
+		}
+		select {
+		case <-stop:
+			return
+		case <-tc:
+		}
+	}
+}
+
+// nextRetry doubles the delay before the next registration attempt.
+func nextRetry(prev time.Duration) time.Duration {
+	if prev == 0 {
+		return retryMin
+	}
+	if prev *= 2; prev > retryMax {
+		return retryMax
+	}
+	return prev
+}
+
+func register(svc proxyService) (*zeroconf.Server, error) {
+	var ips []net.IP
+	for _, s := range svc.IPs {
+		if ip := net.ParseIP(s); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			log.Printf("Ignoring invalid IP %q of %q", s, svc.Name)
+		}
+	}
+	domain := svc.Domain
+	if domain == "" {
+		domain = "local."
+	}
+	return zeroconf.RegisterProxy(svc.Name, svc.Service, domain, svc.Port, svc.Host, svc.Text, nil, svc.TTL, zeroconf.WithIPs(ips...))
+}
+
+// check reports whether any address of the service accepts connections.
+// Services without IPs are published with the addresses of the local
+// interfaces, so there is no remote backend to check.
+func check(svc proxyService) bool {
+	if len(svc.IPs) == 0 {
+		return true
+	}
+	for _, ip := range svc.IPs {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(svc.Port)), *timeout)
+		if err == nil {
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+// Command bonjour is a tool to inspect DNS-SD services in the local network.
+//
+// Usage:
+//
+//	bonjour browse [-service _http._tcp,_ipp._tcp] [-domain local.] [-wait 10] [-tui]
+//
+// With -tui, browse shows a live view of the services grouped by type, which
+// follows services as they are added, updated and removed until interrupted.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s browse [flags]\n\nFlags of browse:\n", os.Args[0])
+	browseFlags().PrintDefaults()
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "browse":
+		browse(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+type browseOpts struct {
+	services string
+	domain   string
+	wait     int
+	tui      bool
+}
+
+var opts browseOpts
+
+func browseFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("browse", flag.ExitOnError)
+	fs.StringVar(&opts.services, "service", "_workstation._tcp", "Comma separated list of service types to browse for.")
+	fs.StringVar(&opts.domain, "domain", "local.", "Set the search domain. For local networks, default is fine.")
+	fs.IntVar(&opts.wait, "wait", 0, "Duration in [s] to run discovery. 0 runs until interrupted.")
+	fs.BoolVar(&opts.tui, "tui", false, "Show an interactive live view instead of logging entries.")
+	return fs
+}
+
+func browse(args []string) {
+	browseFlags().Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if opts.wait > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(opts.wait)*time.Second)
+		defer cancel()
+	}
+
+	var types []string
+	for _, service := range strings.Split(opts.services, ",") {
+		if service = strings.TrimSpace(service); service != "" {
+			types = append(types, service)
+		}
+	}
+
+	if opts.tui {
+		v, err := newView(types, opts.domain)
+		if err != nil {
+			log.Fatalln("Failed to browse:", err.Error())
+		}
+		defer v.close()
+		v.render()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-v.changed:
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			v.render()
+		}
+	}
+
+	// Each service type is browsed by its own resolver, as a resolver
+	// dispatches received messages to a single browse.
+	entries := make(chan *zeroconf.ServiceEntry)
+	for _, service := range types {
+		resolver, err := zeroconf.NewResolver(nil)
+		if err != nil {
+			log.Fatalln("Failed to initialize resolver:", err.Error())
+		}
+		results := make(chan *zeroconf.ServiceEntry)
+		go func(results <-chan *zeroconf.ServiceEntry) {
+			for entry := range results {
+				entries <- entry
+			}
+		}(results)
+		if err := resolver.Browse(ctx, service, opts.domain, results); err != nil {
+			log.Fatalln("Failed to browse:", err.Error())
+		}
+	}
+
+	for {
+		select {
+		case entry := <-entries:
+			log.Printf("%s (%s) %s:%d %v %v %v", entry.Instance, entry.Service, entry.HostName, entry.Port, entry.AddrIPv4, entry.AddrIPv6, entry.Text)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
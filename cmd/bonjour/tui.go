@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// ANSI escape sequences used to draw the live view.
+const (
+	clearScreen = "\x1b[H\x1b[2J"
+	bold        = "\x1b[1m"
+	dim         = "\x1b[2m"
+	reset       = "\x1b[0m"
+	hideCursor  = "\x1b[?25l"
+	showCursor  = "\x1b[?25h"
+)
+
+// view shows the services found by one browser per service type. The
+// browsers keep the services current; the view keeps a log of the changes.
+type view struct {
+	types    []string
+	browsers map[string]*zeroconf.Browser // by type
+	// changed is signaled when a browser reported a change
+	changed chan struct{}
+
+	lock   sync.Mutex
+	last   map[string]*zeroconf.ServiceEntry // by instance name
+	events []string
+}
+
+// newView starts browsing for the service types.
+func newView(types []string, domain string) (*view, error) {
+	v := &view{
+		types:    types,
+		browsers: make(map[string]*zeroconf.Browser),
+		changed:  make(chan struct{}, 1),
+		last:     make(map[string]*zeroconf.ServiceEntry),
+	}
+	for _, t := range types {
+		b, err := zeroconf.NewBrowser(t, domain)
+		if err != nil {
+			v.closeBrowsers()
+			return nil, err
+		}
+		b.OnEvent(v.event)
+		v.browsers[t] = b
+	}
+	fmt.Print(hideCursor)
+	return v, nil
+}
+
+func (v *view) close() {
+	v.closeBrowsers()
+	fmt.Print(showCursor)
+}
+
+func (v *view) closeBrowsers() {
+	for _, b := range v.browsers {
+		b.Close()
+	}
+}
+
+// event logs a change reported by a browser and signals the change.
+func (v *view) event(ev zeroconf.DiscoveryEvent) {
+	v.lock.Lock()
+	name := ev.Entry.ServiceInstanceName()
+	var what, details string
+	switch ev.Type {
+	case zeroconf.EntryAdded:
+		what = "added"
+		v.last[name] = ev.Entry
+	case zeroconf.EntryUpdated:
+		what = "updated"
+		details = describe(zeroconf.Diff(v.last[name], ev.Entry))
+		v.last[name] = ev.Entry
+	case zeroconf.EntryRemoved:
+		what = "removed"
+		delete(v.last, name)
+	}
+	line := fmt.Sprintf("%s %-7s %s (%s)", ev.Time.Format("15:04:05"), what, ev.Entry.Instance, ev.Entry.Service)
+	if details != "" {
+		line += ": " + details
+	}
+	v.events = append(v.events, line)
+	if len(v.events) > 5 {
+		v.events = v.events[len(v.events)-5:]
+	}
+	v.lock.Unlock()
+
+	select {
+	case v.changed <- struct{}{}:
+	default:
+	}
+}
+
+// describe summarizes the changes of an updated entry.
+func describe(changes []zeroconf.Change) string {
+	var list []string
+	for _, c := range changes {
+		switch c.Kind {
+		case zeroconf.HostNameChanged:
+			list = append(list, fmt.Sprintf("host %s -> %s", c.Old, c.New))
+		case zeroconf.PortChanged:
+			list = append(list, fmt.Sprintf("port %s -> %s", c.Old, c.New))
+		case zeroconf.TXTChanged:
+			switch {
+			case !c.OldSet:
+				list = append(list, fmt.Sprintf("+%s=%s", c.Key, c.New))
+			case !c.NewSet:
+				list = append(list, "-"+c.Key)
+			default:
+				list = append(list, fmt.Sprintf("%s=%s -> %s", c.Key, c.Old, c.New))
+			}
+		case zeroconf.AddrAdded:
+			list = append(list, "+"+c.Addr.String())
+		case zeroconf.AddrRemoved:
+			list = append(list, "-"+c.Addr.String())
+		}
+	}
+	return strings.Join(list, ", ")
+}
+
+// render redraws the whole screen.
+func (v *view) render() {
+	now := time.Now()
+
+	var b strings.Builder
+	b.WriteString(clearScreen)
+	fmt.Fprintf(&b, "%sbonjour browse%s  %s  (Ctrl-C to quit)\n\n", bold, reset, now.Format("15:04:05"))
+
+	types := append([]string(nil), v.types...)
+	sort.Strings(types)
+	for _, t := range types {
+		entries := v.browsers[t].Entries()
+		fmt.Fprintf(&b, "%s%s%s (%d)\n", bold, t, reset, len(entries))
+
+		tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "  INSTANCE\tHOST\tADDRESSES\tPORT\tTTL\tTXT")
+		for _, e := range entries {
+			ttl := "-"
+			if expiry := e.V2().Expiry; !expiry.IsZero() {
+				ttl = expiry.Sub(now).Truncate(time.Second).String()
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%d\t%s\t%s\n",
+				e.Instance, e.HostName, addrs(e), e.Port, ttl, strings.Join(e.Text, " "))
+		}
+		tw.Flush()
+		b.WriteString("\n")
+	}
+
+	v.lock.Lock()
+	events := v.events
+	v.lock.Unlock()
+	if len(events) > 0 {
+		fmt.Fprintf(&b, "%sRecent events%s\n", dim, reset)
+		for _, e := range events {
+			fmt.Fprintf(&b, "%s  %s%s\n", dim, e, reset)
+		}
+	}
+	os.Stdout.WriteString(b.String())
+}
+
+func addrs(e *zeroconf.ServiceEntry) string {
+	var list []string
+	for _, ips := range [][]net.IP{e.AddrIPv4, e.AddrIPv6} {
+		for _, ip := range ips {
+			list = append(list, ip.String())
+		}
+	}
+	return strings.Join(list, ",")
+}
@@ -0,0 +1,157 @@
+package zeroconf
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ChangeKind identifies what changed between two service entries.
+type ChangeKind uint8
+
+// Kinds of changes reported by Diff.
+const (
+	// HostNameChanged reports a different SRV target host.
+	HostNameChanged ChangeKind = iota + 1
+	// PortChanged reports a different SRV port.
+	PortChanged
+	// TXTChanged reports a TXT key which was added, removed or modified.
+	TXTChanged
+	// AddrAdded reports an address which is new.
+	AddrAdded
+	// AddrRemoved reports an address which is gone.
+	AddrRemoved
+)
+
+// Change describes a single difference between two service entries.
+type Change struct {
+	Kind ChangeKind
+	// Key is the TXT key for TXTChanged.
+	Key string
+	// Old and New hold the previous and current value for HostNameChanged,
+	// PortChanged and TXTChanged. For TXT keys which were added or removed,
+	// the respective side is empty and OldSet or NewSet is false.
+	Old, New       string
+	OldSet, NewSet bool
+	// Addr is the address for AddrAdded and AddrRemoved.
+	Addr net.IP
+}
+
+// Equal reports whether two entries describe the same service instance with
+// the same host, port, TXT data and addresses. The TTL, the order of TXT keys
+// and the order of addresses are not considered.
+func Equal(a, b *ServiceEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if !strings.EqualFold(a.ServiceInstanceName(), b.ServiceInstanceName()) {
+		return false
+	}
+	return len(Diff(a, b)) == 0
+}
+
+// Diff returns the changes from entry a to entry b. Entries are expected to
+// describe the same service instance.
+func Diff(a, b *ServiceEntry) []Change {
+	if a == nil {
+		a = &ServiceEntry{}
+	}
+	if b == nil {
+		b = &ServiceEntry{}
+	}
+	var changes []Change
+	if !strings.EqualFold(trimDot(a.HostName), trimDot(b.HostName)) {
+		changes = append(changes, Change{
+			Kind:   HostNameChanged,
+			Old:    a.HostName,
+			OldSet: a.HostName != "",
+			New:    b.HostName,
+			NewSet: b.HostName != "",
+		})
+	}
+	if a.Port != b.Port {
+		changes = append(changes, Change{
+			Kind:   PortChanged,
+			Old:    strconv.Itoa(a.Port),
+			OldSet: true,
+			New:    strconv.Itoa(b.Port),
+			NewSet: true,
+		})
+	}
+	changes = append(changes, diffTXT(a.Text, b.Text)...)
+	changes = append(changes, diffAddrs(a.addrs(), b.addrs())...)
+	return changes
+}
+
+// diffTXT compares TXT data by key, ignoring key case and order.
+func diffTXT(a, b []string) []Change {
+	type value struct {
+		key, value string
+	}
+	index := func(text []string) ([]string, map[string]value) {
+		var keys []string
+		m := make(map[string]value)
+		for _, s := range text {
+			k, v := splitTXT(s)
+			lk := strings.ToLower(k)
+			if _, ok := m[lk]; ok {
+				continue
+			}
+			keys = append(keys, lk)
+			m[lk] = value{k, v}
+		}
+		return keys, m
+	}
+	aKeys, aMap := index(a)
+	bKeys, bMap := index(b)
+
+	var changes []Change
+	for _, k := range aKeys {
+		old := aMap[k]
+		cur, ok := bMap[k]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: TXTChanged, Key: old.key, Old: old.value, OldSet: true})
+		case cur.value != old.value:
+			changes = append(changes, Change{Kind: TXTChanged, Key: cur.key, Old: old.value, OldSet: true, New: cur.value, NewSet: true})
+		}
+	}
+	for _, k := range bKeys {
+		if _, ok := aMap[k]; !ok {
+			cur := bMap[k]
+			changes = append(changes, Change{Kind: TXTChanged, Key: cur.key, New: cur.value, NewSet: true})
+		}
+	}
+	return changes
+}
+
+// diffAddrs compares address lists, ignoring order and duplicates.
+func diffAddrs(a, b []net.IP) []Change {
+	contains := func(list []net.IP, ip net.IP) bool {
+		for _, x := range list {
+			if x.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	var changes []Change
+	for i, ip := range a {
+		if !contains(b, ip) && !contains(a[:i], ip) {
+			changes = append(changes, Change{Kind: AddrRemoved, Addr: ip})
+		}
+	}
+	for i, ip := range b {
+		if !contains(a, ip) && !contains(b[:i], ip) {
+			changes = append(changes, Change{Kind: AddrAdded, Addr: ip})
+		}
+	}
+	return changes
+}
+
+// addrs returns all IPv4 and IPv6 addresses of the entry.
+func (e *ServiceEntry) addrs() []net.IP {
+	addrs := make([]net.IP, 0, len(e.AddrIPv4)+len(e.AddrIPv6))
+	addrs = append(addrs, e.AddrIPv4...)
+	return append(addrs, e.AddrIPv6...)
+}
@@ -0,0 +1,153 @@
+package zeroconf
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	base := func() *ServiceEntry {
+		e := NewServiceEntry("printer", "_ipp._tcp", "local.")
+		e.HostName = "host.local."
+		e.Port = 631
+		e.Text = []string{"txtvers=1", "rp=ipp/print", "color"}
+		e.AddrIPv4 = []net.IP{net.ParseIP("192.0.2.1")}
+		e.AddrIPv6 = []net.IP{net.ParseIP("2001:db8::1")}
+		return e
+	}
+	tests := []struct {
+		name   string
+		change func(e *ServiceEntry)
+		want   []Change
+	}{
+		{
+			name:   "unchanged",
+			change: func(e *ServiceEntry) {},
+		},
+		{
+			name: "reordered and TTL",
+			change: func(e *ServiceEntry) {
+				e.Text = []string{"color", "RP=ipp/print", "txtvers=1"}
+				e.AddrIPv4 = append(e.AddrIPv4, net.ParseIP("192.0.2.1"))
+				e.TTL = 120
+			},
+		},
+		{
+			name:   "host name case",
+			change: func(e *ServiceEntry) { e.HostName = "HOST.local" },
+		},
+		{
+			name:   "host name",
+			change: func(e *ServiceEntry) { e.HostName = "other.local." },
+			want: []Change{{
+				Kind: HostNameChanged,
+				Old:  "host.local.", OldSet: true,
+				New: "other.local.", NewSet: true,
+			}},
+		},
+		{
+			name:   "port",
+			change: func(e *ServiceEntry) { e.Port = 8631 },
+			want: []Change{{
+				Kind: PortChanged,
+				Old:  "631", OldSet: true,
+				New: "8631", NewSet: true,
+			}},
+		},
+		{
+			name:   "TXT key added",
+			change: func(e *ServiceEntry) { e.Text = append(e.Text, "duplex=T") },
+			want: []Change{{
+				Kind: TXTChanged, Key: "duplex",
+				New: "T", NewSet: true,
+			}},
+		},
+		{
+			name:   "TXT key removed",
+			change: func(e *ServiceEntry) { e.Text = e.Text[:2] },
+			want: []Change{{
+				Kind: TXTChanged, Key: "color",
+				OldSet: true,
+			}},
+		},
+		{
+			name:   "TXT value modified",
+			change: func(e *ServiceEntry) { e.Text[1] = "rp=ipp/scan" },
+			want: []Change{{
+				Kind: TXTChanged, Key: "rp",
+				Old: "ipp/print", OldSet: true,
+				New: "ipp/scan", NewSet: true,
+			}},
+		},
+		{
+			name: "address added",
+			change: func(e *ServiceEntry) {
+				e.AddrIPv4 = append(e.AddrIPv4, net.ParseIP("192.0.2.2"))
+			},
+			want: []Change{{Kind: AddrAdded, Addr: net.ParseIP("192.0.2.2")}},
+		},
+		{
+			name:   "address removed",
+			change: func(e *ServiceEntry) { e.AddrIPv6 = nil },
+			want:   []Change{{Kind: AddrRemoved, Addr: net.ParseIP("2001:db8::1")}},
+		},
+		{
+			name: "address replaced",
+			change: func(e *ServiceEntry) {
+				e.AddrIPv4 = []net.IP{net.ParseIP("192.0.2.3")}
+			},
+			want: []Change{
+				{Kind: AddrRemoved, Addr: net.ParseIP("192.0.2.1")},
+				{Kind: AddrAdded, Addr: net.ParseIP("192.0.2.3")},
+			},
+		},
+		{
+			name: "port and TXT",
+			change: func(e *ServiceEntry) {
+				e.Port = 8631
+				e.Text = e.Text[1:]
+			},
+			want: []Change{
+				{Kind: PortChanged, Old: "631", OldSet: true, New: "8631", NewSet: true},
+				{Kind: TXTChanged, Key: "txtvers", Old: "1", OldSet: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := base(), base()
+			tt.change(b)
+			got := Diff(a, b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+			if eq := Equal(a, b); eq != (len(tt.want) == 0) {
+				t.Errorf("Equal() = %v, want %v", eq, len(tt.want) == 0)
+			}
+		})
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a := NewServiceEntry("printer", "_ipp._tcp", "local.")
+	b := NewServiceEntry("Printer", "_ipp._tcp", "local.")
+	other := NewServiceEntry("scanner", "_ipp._tcp", "local.")
+	tests := []struct {
+		name string
+		a, b *ServiceEntry
+		want bool
+	}{
+		{"both nil", nil, nil, true},
+		{"one nil", a, nil, false},
+		{"instance case", a, b, true},
+		{"other instance", a, other, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Equal(tt.a, tt.b); got != tt.want {
+				t.Errorf("Equal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
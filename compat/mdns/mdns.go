@@ -0,0 +1,122 @@
+// Package mdns mirrors the client API of github.com/hashicorp/mdns on top of
+// this implementation's resolver, easing migration of one-shot lookups:
+//
+//	import "github.com/grandcat/zeroconf/compat/mdns"
+//
+// Only the query side is provided. Services are registered with the main
+// package.
+package mdns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	bonjour "github.com/grandcat/zeroconf"
+)
+
+// ServiceEntry is returned after we query for a service.
+type ServiceEntry struct {
+	Name       string
+	Host       string
+	AddrV4     net.IP
+	AddrV6     net.IP
+	Port       int
+	Info       string
+	InfoFields []string
+
+	Addr net.IP // @Deprecated
+}
+
+// QueryParam is used to customize how a Lookup is performed.
+type QueryParam struct {
+	Service             string               // Service to lookup
+	Domain              string               // Lookup domain, default "local"
+	Timeout             time.Duration        // Lookup timeout, default 1 second
+	Interface           *net.Interface       // Multicast interface to use
+	Entries             chan<- *ServiceEntry // Entries Channel
+	WantUnicastResponse bool                 // Unicast response desired, as per 5.4 in RFC
+	DisableIPv4         bool                 // Whether to disable usage of IPv4 for MDNS operations. Does not affect discovered addresses.
+	DisableIPv6         bool                 // Whether to disable usage of IPv6 for MDNS operations. Does not affect discovered addresses.
+}
+
+// DefaultParams is used to return a default set of QueryParam's.
+func DefaultParams(service string) *QueryParam {
+	return &QueryParam{
+		Service: service,
+		Domain:  "local",
+		Timeout: time.Second,
+		Entries: make(chan *ServiceEntry),
+	}
+}
+
+// Query looks up a given service, in a domain, waiting at most for a timeout
+// before finishing the query. The results are streamed to a channel. Sends
+// will not block, so clients should make sure to either read or buffer.
+func Query(params *QueryParam) error {
+	var opts []bonjour.ClientOption
+	if params.Interface != nil {
+		opts = append(opts, bonjour.SelectIfaces([]net.Interface{*params.Interface}))
+	}
+	switch {
+	case params.DisableIPv4 && params.DisableIPv6:
+		return nil
+	case params.DisableIPv4:
+		opts = append(opts, bonjour.SelectIPTraffic(bonjour.IPv6))
+	case params.DisableIPv6:
+		opts = append(opts, bonjour.SelectIPTraffic(bonjour.IPv4))
+	}
+	resolver, err := bonjour.NewResolver(opts...)
+	if err != nil {
+		return err
+	}
+
+	timeout := params.Timeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	results := make(chan *bonjour.ServiceEntry)
+	if err := resolver.Browse(ctx, params.Service, params.Domain, results); err != nil {
+		return err
+	}
+	for entry := range results {
+		select {
+		case params.Entries <- convert(entry):
+		default:
+		}
+	}
+	return nil
+}
+
+// Lookup is the same as Query, however it uses all the default parameters.
+func Lookup(service string, entries chan<- *ServiceEntry) error {
+	params := DefaultParams(service)
+	params.Entries = entries
+	return Query(params)
+}
+
+// convert maps a resolver entry to the hashicorp/mdns entry shape.
+func convert(e *bonjour.ServiceEntry) *ServiceEntry {
+	entry := &ServiceEntry{
+		Name:       e.ServiceInstanceName(),
+		Host:       e.HostName,
+		Port:       e.Port,
+		Info:       strings.Join(e.Text, "|"),
+		InfoFields: e.Text,
+	}
+	if len(e.AddrIPv4) > 0 {
+		entry.AddrV4 = e.AddrIPv4[0]
+	}
+	if len(e.AddrIPv6) > 0 {
+		entry.AddrV6 = e.AddrIPv6[0]
+	}
+	entry.Addr = entry.AddrV4
+	if entry.Addr == nil {
+		entry.Addr = entry.AddrV6
+	}
+	return entry
+}
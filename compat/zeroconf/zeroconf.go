@@ -0,0 +1,94 @@
+// Package zeroconf mirrors the API of github.com/grandcat/zeroconf on top of
+// this implementation, so projects can switch by changing the import path:
+//
+//	import "github.com/grandcat/zeroconf/compat/zeroconf"
+//
+// Types are aliases of their counterparts in the main package, so values can
+// be passed between both APIs.
+package zeroconf
+
+import (
+	"net"
+
+	bonjour "github.com/grandcat/zeroconf"
+)
+
+// Aliased types.
+type (
+	ServiceRecord = bonjour.ServiceRecord
+	ServiceEntry  = bonjour.ServiceEntry
+	LookupParams  = bonjour.LookupParams
+	Resolver      = bonjour.Resolver
+	ClientOption  = bonjour.ClientOption
+	IPType        = bonjour.IPType
+)
+
+// Options for IPType.
+const (
+	IPv4        = bonjour.IPv4
+	IPv6        = bonjour.IPv6
+	IPv4AndIPv6 = bonjour.IPv4AndIPv6
+)
+
+// Constructors and options shared with the main package.
+var (
+	NewServiceRecord = bonjour.NewServiceRecord
+	NewServiceEntry  = bonjour.NewServiceEntry
+	NewLookupParams  = bonjour.NewLookupParams
+	NewResolver      = bonjour.NewResolver
+	SelectIPTraffic  = bonjour.SelectIPTraffic
+	SelectIfaces     = bonjour.SelectIfaces
+)
+
+// Server wraps a registered service.
+type Server struct {
+	s *bonjour.Server
+}
+
+// Register a service by given arguments. This call will take the system's
+// hostname and lookup IP by that hostname.
+func Register(instance, service, domain string, port int, text []string, ifaces []net.Interface) (*Server, error) {
+	s, err := bonjour.Register(instance, service, domain, port, text, ifaces, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{s: s}, nil
+}
+
+// RegisterProxy registers a service proxy. This call will skip the
+// hostname/IP lookup and will use the provided values.
+func RegisterProxy(instance, service, domain string, port int, host string, ips []string, text []string, ifaces []net.Interface) (*Server, error) {
+	var addrs []net.IP
+	for _, ip := range ips {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: ip}
+		}
+		addrs = append(addrs, addr)
+	}
+	s, err := bonjour.RegisterProxy(instance, service, domain, port, host, text, ifaces, 0, bonjour.WithIPs(addrs...))
+	if err != nil {
+		return nil, err
+	}
+	return &Server{s: s}, nil
+}
+
+// Shutdown closes all udp connections and unregisters the service.
+func (s *Server) Shutdown() {
+	s.s.Shutdown()
+}
+
+// SetText updates and announces the TXT records.
+func (s *Server) SetText(text []string) {
+	s.s.SetText(text)
+}
+
+// TTL sets the TTL for DNS replies.
+func (s *Server) TTL(ttl uint32) {
+	s.s.TTL(ttl)
+}
+
+// Server returns the underlying server of the main package.
+func (s *Server) Server() *bonjour.Server {
+	return s.s
+}
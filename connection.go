@@ -1,29 +1,25 @@
 package zeroconf
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"syscall"
 
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 )
 
+// IP TTL and hop limit of outgoing packets. Responders may check that the
+// packets were sent with 255 to ensure they originate from the local link
+// (RFC6762 section 11).
+const multicastTTL = 255
+
 var (
 	// Multicast groups used by mDNS
 	mdnsGroupIPv4 = net.IPv4(224, 0, 0, 251)
 	mdnsGroupIPv6 = net.ParseIP("ff02::fb")
 
-	// mDNS wildcard addresses
-	mdnsWildcardAddrIPv4 = &net.UDPAddr{
-		IP:   net.ParseIP("224.0.0.0"),
-		Port: 5353,
-	}
-	mdnsWildcardAddrIPv6 = &net.UDPAddr{
-		IP: net.ParseIP("ff02::"),
-		// IP:   net.ParseIP("fd00::12d3:26e7:48db:e7d"),
-		Port: 5353,
-	}
-
 	// mDNS endpoint addresses
 	ipv4Addr = &net.UDPAddr{
 		IP:   mdnsGroupIPv4,
@@ -35,15 +31,86 @@ var (
 	}
 )
 
-func joinUdp6Multicast(interfaces []net.Interface) (*ipv6.PacketConn, error) {
-	udpConn, err := net.ListenUDP("udp6", mdnsWildcardAddrIPv6)
+// socketOpts configures the multicast sockets.
+type socketOpts struct {
+	// Set SO_REUSEPORT in addition to SO_REUSEADDR
+	reusePort bool
+	// Name of the interface to bind the socket to, if any
+	device string
+}
+
+// listenMulticastUDP binds the mDNS port, sharing it with other responders on
+// the host.
+func listenMulticastUDP(network string, addr *net.UDPAddr, opts socketOpts) (net.PacketConn, error) {
+	reuse := reuseControl(opts.reusePort)
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			if reuse != nil {
+				if err := reuse(network, address, c); err != nil {
+					return err
+				}
+			}
+			if opts.device != "" {
+				return bindToDevice(c, network, opts.device)
+			}
+			return nil
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), network, addr.String())
+	if err != nil {
+		return nil, &bindError{network: network, err: err}
+	}
+	return conn, nil
+}
+
+// multicastGroup holds the IPv4 and IPv6 multicast group addresses used for
+// mDNS. Tests may use other groups or another port to run an isolated mDNS
+// network on a shared host.
+type multicastGroup struct {
+	ipv4 *net.UDPAddr
+	ipv6 *net.UDPAddr
+}
+
+// defaultGroup is the mDNS multicast group of RFC6762
+var defaultGroup = multicastGroup{ipv4: ipv4Addr, ipv6: ipv6Addr}
+
+// newMulticastGroup returns the multicast group with the given addresses, or
+// the default addresses for nil, on the given port.
+func newMulticastGroup(ipv4, ipv6 net.IP, port int) multicastGroup {
+	if ipv4 == nil {
+		ipv4 = mdnsGroupIPv4
+	}
+	if ipv6 == nil {
+		ipv6 = mdnsGroupIPv6
+	}
+	return multicastGroup{
+		ipv4: &net.UDPAddr{IP: ipv4, Port: port},
+		ipv6: &net.UDPAddr{IP: ipv6, Port: port},
+	}
+}
+
+// orDefault returns the group, or the default group if it is not set.
+func (g multicastGroup) orDefault() multicastGroup {
+	if g.ipv4 == nil {
+		return defaultGroup
+	}
+	return g
+}
+
+func joinUdp6Multicast(interfaces []net.Interface, group multicastGroup, opts socketOpts) (*ipv6.PacketConn, error) {
+	group = group.orDefault()
+	laddr := &net.UDPAddr{IP: listenIPv6, Port: group.ipv6.Port}
+	udpConn, err := listenMulticastUDP("udp6", laddr, opts)
 	if err != nil {
 		return nil, err
 	}
 
 	// Join multicast groups to receive announcements
 	pkConn := ipv6.NewPacketConn(udpConn)
-	pkConn.SetControlMessage(ipv6.FlagInterface, true)
+	pkConn.SetControlMessage(ipv6.FlagInterface|ipv6.FlagHopLimit, true)
+	pkConn.SetMulticastHopLimit(multicastTTL)
+	pkConn.SetHopLimit(multicastTTL)
+	pkConn.SetMulticastLoopback(true)
 
 	if len(interfaces) == 0 {
 		interfaces = listMulticastInterfaces()
@@ -52,21 +119,23 @@ func joinUdp6Multicast(interfaces []net.Interface) (*ipv6.PacketConn, error) {
 
 	var failedJoins int
 	for _, iface := range interfaces {
-		if err := pkConn.JoinGroup(&iface, &net.UDPAddr{IP: mdnsGroupIPv6}); err != nil {
+		if err := pkConn.JoinGroup(&iface, &net.UDPAddr{IP: group.ipv6.IP}); err != nil {
 			// log.Println("Udp6 JoinGroup failed for iface ", iface)
 			failedJoins++
 		}
 	}
 	if failedJoins == len(interfaces) {
 		pkConn.Close()
-		return nil, fmt.Errorf("udp6: failed to join any of these interfaces: %v", interfaces)
+		return nil, fmt.Errorf("udp6: %w: failed to join any of these interfaces: %v", ErrNoInterface, interfaces)
 	}
 
 	return pkConn, nil
 }
 
-func joinUdp4Multicast(interfaces []net.Interface) (*ipv4.PacketConn, error) {
-	udpConn, err := net.ListenUDP("udp4", mdnsWildcardAddrIPv4)
+func joinUdp4Multicast(interfaces []net.Interface, group multicastGroup, opts socketOpts) (*ipv4.PacketConn, error) {
+	group = group.orDefault()
+	laddr := &net.UDPAddr{IP: listenIPv4, Port: group.ipv4.Port}
+	udpConn, err := listenMulticastUDP("udp4", laddr, opts)
 	if err != nil {
 		// log.Printf("[ERR] bonjour: Failed to bind to udp4 mutlicast: %v", err)
 		return nil, err
@@ -74,7 +143,10 @@ func joinUdp4Multicast(interfaces []net.Interface) (*ipv4.PacketConn, error) {
 
 	// Join multicast groups to receive announcements
 	pkConn := ipv4.NewPacketConn(udpConn)
-	pkConn.SetControlMessage(ipv4.FlagInterface, true)
+	pkConn.SetControlMessage(ipv4.FlagInterface|ipv4.FlagTTL, true)
+	pkConn.SetMulticastTTL(multicastTTL)
+	pkConn.SetTTL(multicastTTL)
+	pkConn.SetMulticastLoopback(true)
 
 	if len(interfaces) == 0 {
 		interfaces = listMulticastInterfaces()
@@ -83,19 +155,73 @@ func joinUdp4Multicast(interfaces []net.Interface) (*ipv4.PacketConn, error) {
 
 	var failedJoins int
 	for _, iface := range interfaces {
-		if err := pkConn.JoinGroup(&iface, &net.UDPAddr{IP: mdnsGroupIPv4}); err != nil {
+		if err := pkConn.JoinGroup(&iface, &net.UDPAddr{IP: group.ipv4.IP}); err != nil {
 			// log.Println("Udp4 JoinGroup failed for iface ", iface)
 			failedJoins++
 		}
 	}
 	if failedJoins == len(interfaces) {
 		pkConn.Close()
-		return nil, fmt.Errorf("udp4: failed to join any of these interfaces: %v", interfaces)
+		return nil, fmt.Errorf("udp4: %w: failed to join any of these interfaces: %v", ErrNoInterface, interfaces)
 	}
 
 	return pkConn, nil
 }
 
+// listenUdp4Ephemeral opens a socket on a random port for legacy unicast
+// queries (RFC6762 section 6.7). It joins no multicast group; responders
+// send their replies to it directly.
+func listenUdp4Ephemeral() (*ipv4.PacketConn, error) {
+	udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, &bindError{"udp4", err}
+	}
+	pkConn := ipv4.NewPacketConn(udpConn)
+	pkConn.SetControlMessage(ipv4.FlagInterface, true)
+	pkConn.SetMulticastTTL(multicastTTL)
+	pkConn.SetMulticastLoopback(true)
+	return pkConn, nil
+}
+
+// listenUdp6Ephemeral is the IPv6 counterpart of listenUdp4Ephemeral.
+func listenUdp6Ephemeral() (*ipv6.PacketConn, error) {
+	udpConn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6unspecified})
+	if err != nil {
+		return nil, &bindError{"udp6", err}
+	}
+	pkConn := ipv6.NewPacketConn(udpConn)
+	pkConn.SetControlMessage(ipv6.FlagInterface, true)
+	pkConn.SetMulticastHopLimit(multicastTTL)
+	pkConn.SetMulticastLoopback(true)
+	return pkConn, nil
+}
+
+// rejoinMulticast leaves and re-joins the multicast group on the given
+// interfaces, which refreshes memberships the kernel or a switch has dropped.
+// It returns the number of interfaces joined successfully.
+func rejoinMulticast(conn Transport, group net.IP, interfaces []net.Interface) int {
+	var joined int
+	for _, iface := range interfaces {
+		conn.LeaveGroup(&iface, &net.UDPAddr{IP: group})
+		if err := conn.JoinGroup(&iface, &net.UDPAddr{IP: group}); err == nil {
+			joined++
+		}
+	}
+	return joined
+}
+
+// refreshInterfaces looks up the current state of the given interfaces by
+// index. Interfaces which no longer exist are left out.
+func refreshInterfaces(interfaces []net.Interface) []net.Interface {
+	var refreshed []net.Interface
+	for _, iface := range interfaces {
+		if current, err := net.InterfaceByIndex(iface.Index); err == nil {
+			refreshed = append(refreshed, *current)
+		}
+	}
+	return refreshed
+}
+
 func listMulticastInterfaces() []net.Interface {
 	var interfaces []net.Interface
 	ifaces, err := net.Interfaces()
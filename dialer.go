@@ -0,0 +1,96 @@
+package zeroconf
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// Time a Dialer waits for the addresses of a .local host by default
+const defaultDialLookupTimeout = 5 * time.Second
+
+// Dialer connects to addresses whose host names are in the .local domain by
+// resolving them with multicast DNS. Other addresses are dialed with the
+// embedded net.Dialer, which uses the system resolver. It can serve as the
+// DialContext of an http.Transport, so HTTP clients reach discovered services
+// by their host names:
+//
+//	d := &zeroconf.Dialer{}
+//	client := &http.Client{Transport: &http.Transport{DialContext: d.DialContext}}
+//	resp, err := client.Get("http://mybox.local:8080/")
+type Dialer struct {
+	net.Dialer
+	// Options configure the resolver created for every lookup.
+	Options []ClientOption
+	// LookupTimeout limits the time to resolve a .local host name. It
+	// defaults to five seconds.
+	LookupTimeout time.Duration
+}
+
+// Dial connects to the address on the named network, see DialContext.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address on the named network using the
+// provided context. A host name in the .local domain is resolved with
+// LookupHost and its addresses are tried in turn until a connection is made.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || !IsLocalHost(host) {
+		return d.Dialer.DialContext(ctx, network, address)
+	}
+	ips, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	var lastErr error
+	for _, ip := range ips {
+		if !matchesNetwork(network, ip) {
+			continue
+		}
+		conn, err := d.Dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "no suitable address found", Addr: host}}
+	}
+	return nil, lastErr
+}
+
+// lookup resolves the addresses of a .local host with a new resolver.
+func (d *Dialer) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	timeout := d.LookupTimeout
+	if timeout <= 0 {
+		timeout = defaultDialLookupTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	r, err := NewResolver(d.Options...)
+	if err != nil {
+		return nil, err
+	}
+	return r.LookupHost(ctx, host)
+}
+
+// IsLocalHost reports whether a host name is in the .local domain and is
+// resolved with multicast DNS.
+func IsLocalHost(host string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), ".local")
+}
+
+// matchesNetwork reports whether an address can be dialed on the network.
+// Networks without an address family match all addresses.
+func matchesNetwork(network string, ip net.IP) bool {
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return ip.To4() != nil
+	case strings.HasSuffix(network, "6"):
+		return ip.To4() == nil
+	}
+	return true
+}
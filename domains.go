@@ -0,0 +1,69 @@
+package zeroconf
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// WithDomainEnumeration makes the server answer the domain enumeration
+// meta-queries of RFC 6763 section 11 with the given browse and registration
+// domains. The first domain of each list is announced as the default domain.
+//
+// Browse domains are returned for "b._dns-sd._udp", "db._dns-sd._udp" and
+// "lb._dns-sd._udp", registration domains for "r._dns-sd._udp" and
+// "dr._dns-sd._udp".
+func WithDomainEnumeration(browse, registration []string) ServerOption {
+	return func(o *serverOpts) {
+		o.browseDomains = browse
+		o.registerDomains = registration
+	}
+}
+
+// handleDomainQuestion answers a domain enumeration query. It reports whether
+// the question was such a query.
+func (s *Server) handleDomainQuestion(q dns.Question, resp *dns.Msg, ttl uint32) bool {
+	if len(s.opts.browseDomains) == 0 && len(s.opts.registerDomains) == 0 {
+		return false
+	}
+	suffix := "._dns-sd._udp." + trimDot(s.entry().Domain) + "."
+	if len(q.Name) <= len(suffix) || !strings.EqualFold(q.Name[len(q.Name)-len(suffix):], suffix) {
+		return false
+	}
+
+	var domains []string
+	switch strings.ToLower(q.Name[:len(q.Name)-len(suffix)]) {
+	case "b", "lb":
+		domains = s.opts.browseDomains
+	case "db":
+		domains = first(s.opts.browseDomains)
+	case "r":
+		domains = s.opts.registerDomains
+	case "dr":
+		domains = first(s.opts.registerDomains)
+	default:
+		return false
+	}
+	if !typeMatches(q.Qtype, dns.TypePTR) {
+		return true
+	}
+	for _, domain := range domains {
+		resp.Answer = append(resp.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			Ptr: dns.Fqdn(domain),
+		})
+	}
+	return true
+}
+
+func first(list []string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+	return list[:1]
+}
@@ -0,0 +1,53 @@
+package zeroconf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned when registering services and hosts. Callers can test for
+// them with errors.Is; errors wrapping a failure of the network stack also
+// unwrap to the underlying error.
+var (
+	// ErrMissingInstance is returned if a service has no instance name.
+	ErrMissingInstance = errors.New("Missing service instance name")
+	// ErrMissingService is returned if a service has no service type.
+	ErrMissingService = errors.New("Missing service name")
+	// ErrMissingHost is returned if no host name is given or if the host
+	// name of the system cannot be determined.
+	ErrMissingHost = errors.New("Missing host name")
+	// ErrMissingPort is returned if a service has no port.
+	ErrMissingPort = errors.New("Missing port")
+	// ErrInvalidAddress is returned for a nil or unspecified address.
+	ErrInvalidAddress = errors.New("Invalid address")
+	// ErrNoAddresses is returned if a host has no address to publish.
+	ErrNoAddresses = errors.New("No address to publish")
+	// ErrNoInterface is returned if no multicast group could be joined on
+	// any of the interfaces.
+	ErrNoInterface = errors.New("No supported interface")
+	// ErrUnknownInterface is returned for an interface name that does not
+	// exist or is not used by the server.
+	ErrUnknownInterface = errors.New("Unknown interface")
+	// ErrSocketBind is returned if a multicast socket cannot be opened.
+	ErrSocketBind = errors.New("Could not bind socket")
+	// ErrShutdown is returned if a server or registrar is already shut down.
+	ErrShutdown = errors.New("Already shutdown")
+	// ErrNameConflict is reported if the name of a service or host is
+	// already taken and renaming is disabled or impossible.
+	ErrNameConflict = errors.New("Name already in use")
+)
+
+// bindError wraps the error of opening a socket. It matches ErrSocketBind
+// and unwraps to the error of the network stack.
+type bindError struct {
+	network string
+	err     error
+}
+
+func (e *bindError) Error() string {
+	return fmt.Sprintf("%s: %v: %v", e.network, ErrSocketBind, e.err)
+}
+
+func (e *bindError) Is(target error) bool { return target == ErrSocketBind }
+
+func (e *bindError) Unwrap() error { return e.err }
@@ -0,0 +1,117 @@
+package zeroconf
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Number of discovery events kept by a resolver by default
+const defaultEventLogSize = 256
+
+// DiscoveryEventType classifies an entry of the resolver history.
+type DiscoveryEventType uint8
+
+// Kinds of discovery events recorded by a Resolver.
+const (
+	// EntryAdded is recorded when an entry is delivered for the first time.
+	EntryAdded DiscoveryEventType = iota + 1
+	// EntryUpdated is recorded when a delivered entry is delivered again with
+	// changed data.
+	EntryUpdated
+	// EntryRemoved is recorded when a delivered entry is withdrawn with a
+	// goodbye packet, or when its records expire while browsing for events.
+	EntryRemoved
+)
+
+func (t DiscoveryEventType) String() string {
+	switch t {
+	case EntryAdded:
+		return "EntryAdded"
+	case EntryUpdated:
+		return "EntryUpdated"
+	case EntryRemoved:
+		return "EntryRemoved"
+	}
+	return "Unknown"
+}
+
+// DiscoveryEvent is an entry of the resolver history, or a change of an entry
+// reported by Resolver.BrowseEvents.
+type DiscoveryEvent struct {
+	Type DiscoveryEventType
+	Time time.Time
+	// Entry is the entry as delivered, or as last seen for EntryRemoved.
+	Entry *ServiceEntry
+	// Interface is the index of the interface the records were received on.
+	Interface int
+	// From is the address of the responder, if known.
+	From net.Addr
+}
+
+func newDiscoveryEvent(t DiscoveryEventType, e *ServiceEntry, from net.Addr) DiscoveryEvent {
+	return DiscoveryEvent{
+		Type:      t,
+		Time:      time.Now(),
+		Entry:     e,
+		Interface: e.ifIndex,
+		From:      from,
+	}
+}
+
+// eventLog is a bounded ring buffer of discovery events.
+type eventLog struct {
+	sync.Mutex
+	events []DiscoveryEvent
+	next   int
+	full   bool
+}
+
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		return nil
+	}
+	return &eventLog{events: make([]DiscoveryEvent, size)}
+}
+
+// add records an event. It is a no-op on a nil log.
+func (l *eventLog) add(t DiscoveryEventType, e *ServiceEntry, from net.Addr) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.events[l.next] = newDiscoveryEvent(t, e, from)
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// last returns up to n of the most recent events, oldest first.
+func (l *eventLog) last(n int) []DiscoveryEvent {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.events)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	events := make([]DiscoveryEvent, n)
+	for i := range events {
+		events[i] = l.events[(l.next-n+i+len(l.events))%len(l.events)]
+	}
+	return events
+}
+
+// History returns up to n of the most recent discovery events of the
+// resolver, oldest first. If n is not positive, all retained events are
+// returned.
+func (r *Resolver) History(n int) []DiscoveryEvent {
+	return r.c.events.last(n)
+}
@@ -0,0 +1,106 @@
+package zeroconf
+
+import "net"
+
+// ServerEventType identifies a stage in the lifecycle of a Server.
+type ServerEventType uint8
+
+// Lifecycle stages reported to a ServerEventHandler.
+const (
+	// Starting is reported once the sockets are open, before probing starts.
+	Starting ServerEventType = iota + 1
+	// Probing is reported when probing for the service name starts.
+	Probing
+	// Announced is reported when the initial announcements have been sent.
+	Announced
+	// InterfaceJoined is reported for every interface the server answers on.
+	InterfaceJoined
+	// InterfaceLost is reported when an interface is gone or no longer up.
+	InterfaceLost
+	// ShuttingDown is reported before the goodbye packets are sent.
+	ShuttingDown
+	// Stopped is reported once all sockets and routines are closed.
+	Stopped
+	// Woke is reported when the system resumed from sleep and the service
+	// is probed and announced again.
+	Woke
+	// Conflict is reported when another responder claims the service's
+	// names. A conflict found while probing leads to a rename of the
+	// service, unless disabled with WithoutRename.
+	Conflict
+)
+
+func (t ServerEventType) String() string {
+	switch t {
+	case Starting:
+		return "Starting"
+	case Probing:
+		return "Probing"
+	case Announced:
+		return "Announced"
+	case InterfaceJoined:
+		return "InterfaceJoined"
+	case InterfaceLost:
+		return "InterfaceLost"
+	case ShuttingDown:
+		return "ShuttingDown"
+	case Stopped:
+		return "Stopped"
+	case Woke:
+		return "Woke"
+	case Conflict:
+		return "Conflict"
+	}
+	return "Unknown"
+}
+
+// ServerEvent describes a lifecycle change of a Server.
+type ServerEvent struct {
+	Type ServerEventType
+	// Interface is set for InterfaceJoined and InterfaceLost events.
+	Interface *net.Interface
+	// Service is the service entry the server publishes at the time of the event.
+	Service *ServiceEntry
+}
+
+// ServerEventHandler is called synchronously for every lifecycle event.
+// It must not block.
+type ServerEventHandler func(ServerEvent)
+
+// emit reports a lifecycle event to the configured handler, if any.
+func (s *Server) emit(t ServerEventType, iface *net.Interface) {
+	if s.opts.onEvent == nil {
+		return
+	}
+	s.opts.onEvent(ServerEvent{
+		Type:      t,
+		Interface: iface,
+		Service:   s.entry(),
+	})
+}
+
+// checkInterface reports an InterfaceLost event once for an interface that
+// disappeared or went down. Once the interface is up again, it is reported
+// again the next time it is lost.
+func (s *Server) checkInterface(iface net.Interface) {
+	if s.opts.onEvent == nil {
+		return
+	}
+	current, err := net.InterfaceByIndex(iface.Index)
+	if err == nil && current.Flags&net.FlagUp != 0 {
+		s.lostLock.Lock()
+		delete(s.lost, iface.Index)
+		s.lostLock.Unlock()
+		return
+	}
+	s.lostLock.Lock()
+	if s.lost == nil {
+		s.lost = make(map[int]bool)
+	}
+	seen := s.lost[iface.Index]
+	s.lost[iface.Index] = true
+	s.lostLock.Unlock()
+	if !seen {
+		s.emit(InterfaceLost, &iface)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
@@ -25,7 +26,7 @@ var (
 func main() {
 	flag.Parse()
 
-	server, err := zeroconf.RegisterProxy(*name, *service, *domain, *port, *host, []string{*ip}, []string{"txtv=0", "lo=1", "la=2"}, nil)
+	server, err := zeroconf.RegisterProxy(*name, *service, *domain, *port, *host, []string{"txtv=0", "lo=1", "la=2"}, nil, 0, zeroconf.WithIPs(net.ParseIP(*ip)))
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,94 @@
+package zeroconf
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// HealthCheck queries the server's own records over separate multicast
+// sockets and waits until a response was observed on every interface of the
+// server. It detects deployments where the service is registered but not
+// visible, e.g. due to firewalls. If the context expires first, the error
+// lists the interfaces without a response. The query is repeated with
+// doubling intervals, starting at one second (RFC 6762 section 5.2).
+func (s *Server) HealthCheck(ctx context.Context) error {
+	ifaces := s.interfaces()
+	if len(ifaces) == 0 {
+		return fmt.Errorf("no interfaces to check")
+	}
+	c, err := newClient(clientOpts{listenOn: IPv4AndIPv6, ifaces: ifaces, group: s.opts.group})
+	if err != nil {
+		return err
+	}
+	defer c.shutdown()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgCh := make(chan *inbound, 32)
+	if c.ipv4conn != nil {
+		go c.recv(ctx, c.ipv4conn, msgCh)
+	}
+	if c.ipv6conn != nil {
+		go c.recv(ctx, c.ipv6conn, msgCh)
+	}
+
+	// The records asked for, by name
+	names := make(map[string]uint16)
+	q := new(dns.Msg)
+	q.RecursionDesired = false
+	for _, intf := range ifaces {
+		name, qtype := s.instanceName(intf.Index), dns.TypeSRV
+		if s.hostOnly {
+			name, qtype = s.entry().HostName, dns.TypeANY
+		}
+		if _, ok := names[strings.ToLower(name)]; !ok {
+			names[strings.ToLower(name)] = qtype
+			q.Question = append(q.Question, dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET})
+		}
+	}
+
+	seen := make(map[int]bool)
+	wait := continuousQueryMin
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	c.sendQuery(q)
+	for {
+		select {
+		case <-ctx.Done():
+			var missing []string
+			for _, intf := range ifaces {
+				if !seen[intf.Index] {
+					missing = append(missing, intf.Name)
+				}
+			}
+			return fmt.Errorf("service not visible on %s: %v", strings.Join(missing, ", "), ctx.Err())
+		case <-timer.C:
+			c.sendQuery(q)
+			if wait *= 2; wait > continuousQueryMax {
+				wait = continuousQueryMax
+			}
+			timer.Reset(wait)
+		case in := <-msgCh:
+			if !in.msg.Response {
+				continue
+			}
+			for _, rr := range in.msg.Answer {
+				qtype, ok := names[strings.ToLower(rr.Header().Name)]
+				if ok && (qtype == dns.TypeANY || rr.Header().Rrtype == qtype) {
+					seen[in.ifIndex] = true
+				}
+			}
+			visible := true
+			for _, intf := range ifaces {
+				visible = visible && seen[intf.Index]
+			}
+			if visible {
+				return nil
+			}
+		}
+	}
+}
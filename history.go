@@ -0,0 +1,76 @@
+package zeroconf
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// historyKey identifies a record sent on an interface.
+type historyKey struct {
+	ifIndex int
+	record  string
+}
+
+// historyEntry holds the time a record was last multicast along with its TTL.
+type historyEntry struct {
+	sent time.Time
+	ttl  uint32
+}
+
+// recordHistory tracks when records were last multicast on each interface.
+type recordHistory struct {
+	sync.Mutex
+	sent map[historyKey]historyEntry
+}
+
+// recordKey returns an identifier for the record's name, type, class and
+// rdata, ignoring the TTL and the cache flush bit.
+func recordKey(rr dns.RR) string {
+	c := dns.Copy(rr)
+	hdr := c.Header()
+	hdr.Name = strings.ToLower(hdr.Name)
+	hdr.Ttl = 0
+	hdr.Class &^= qClassCacheFlush
+	return c.String()
+}
+
+// markSent records that the given records were multicast on an interface.
+func (h *recordHistory) markSent(records []dns.RR, ifIndex int, now time.Time) {
+	h.Lock()
+	defer h.Unlock()
+	if h.sent == nil {
+		h.sent = make(map[historyKey]historyEntry)
+	}
+	for _, rr := range records {
+		h.sent[historyKey{ifIndex, recordKey(rr)}] = historyEntry{now, rr.Header().Ttl}
+	}
+	// Forget records whose TTL has expired since they were sent.
+	if len(h.sent) > 256 {
+		for k, e := range h.sent {
+			if now.Sub(e.sent) > time.Duration(e.ttl)*time.Second {
+				delete(h.sent, k)
+			}
+		}
+	}
+}
+
+// sentWithin reports whether the record was multicast on the interface within
+// the given duration.
+func (h *recordHistory) sentWithin(rr dns.RR, ifIndex int, d time.Duration, now time.Time) bool {
+	h.Lock()
+	defer h.Unlock()
+	e, ok := h.sent[historyKey{ifIndex, recordKey(rr)}]
+	return ok && now.Sub(e.sent) < d
+}
+
+// seenWithin reports whether the record was seen on the interface within the
+// given duration with a TTL of at least ttl.
+func (h *recordHistory) seenWithin(rr dns.RR, ifIndex int, d time.Duration, ttl uint32, now time.Time) bool {
+	h.Lock()
+	defer h.Unlock()
+	e, ok := h.sent[historyKey{ifIndex, recordKey(rr)}]
+	return ok && now.Sub(e.sent) < d && e.ttl >= ttl
+}
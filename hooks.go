@@ -0,0 +1,44 @@
+package zeroconf
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// OnQuery sets a function which is called for every question the server
+// receives, before it is answered. It reports whether the question is
+// answered, so applications can log or meter queries, or implement policies
+// like not answering certain subnets. A nil function answers all questions.
+func (s *Server) OnQuery(h func(q dns.Question, from net.Addr) bool) {
+	s.hooksLock.Lock()
+	s.onQuery = h
+	s.hooksLock.Unlock()
+}
+
+// OnResponseSent sets a function which is called for every response sent,
+// with the destination: the querier for unicast responses, or the multicast
+// group address.
+func (s *Server) OnResponseSent(h func(resp *dns.Msg, to net.Addr)) {
+	s.hooksLock.Lock()
+	s.onResponse = h
+	s.hooksLock.Unlock()
+}
+
+// acceptQuestion reports whether the question is to be answered.
+func (s *Server) acceptQuestion(q dns.Question, from net.Addr) bool {
+	s.hooksLock.RLock()
+	h := s.onQuery
+	s.hooksLock.RUnlock()
+	return h == nil || h(q, from)
+}
+
+// responseSent reports a sent response to the configured function, if any.
+func (s *Server) responseSent(resp *dns.Msg, to net.Addr) {
+	s.hooksLock.RLock()
+	h := s.onResponse
+	s.hooksLock.RUnlock()
+	if h != nil {
+		h(resp, to)
+	}
+}
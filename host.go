@@ -0,0 +1,107 @@
+package zeroconf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// RegisterHost publishes just a host name with its address records and the
+// corresponding reverse PTR records, without any service. If no addresses are
+// given, the addresses of the interfaces are published; ErrNoAddresses is
+// returned if they have none. Like a service, the
+// host name is probed for before its records are announced; if it is already
+// taken, the records are not published.
+func RegisterHost(host string, ips []net.IP, ifaces []net.Interface, ttl uint32, opts ...ServerOption) (*Server, error) {
+	if host == "" {
+		return nil, ErrMissingHost
+	}
+	entry := NewServiceEntry("", "", "local.")
+	entry.HostName = host
+	if !strings.HasSuffix(trimDot(entry.HostName), trimDot(entry.Domain)) {
+		entry.HostName = fmt.Sprintf("%s.%s.", trimDot(entry.HostName), trimDot(entry.Domain))
+	} else if !strings.HasSuffix(entry.HostName, ".") {
+		entry.HostName += "."
+	}
+	for _, ip := range ips {
+		if ip == nil || ip.IsUnspecified() {
+			return nil, fmt.Errorf("%w %v", ErrInvalidAddress, ip)
+		}
+		if ip.To4() != nil {
+			entry.AddrIPv4 = append(entry.AddrIPv4, ip)
+		} else {
+			entry.AddrIPv6 = append(entry.AddrIPv6, ip)
+		}
+	}
+
+	s, err := newServer(ifaces, ttl, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ips) == 0 && len(s.opts.ips) == 0 && !s.pending && !s.opts.customTransport && !hasUsableAddrs(s.interfaces()) {
+		// Nothing to publish; the server was never started.
+		if s.ipv4conn != nil {
+			s.ipv4conn.Close()
+		}
+		if s.ipv6conn != nil {
+			s.ipv6conn.Close()
+		}
+		return nil, ErrNoAddresses
+	}
+
+	s.hostOnly = true
+	s.setService(entry)
+	s.start()
+
+	return s, nil
+}
+
+// handleHostQuestion answers questions for the host name and the reverse
+// names of its addresses.
+func (s *Server) handleHostQuestion(q dns.Question, resp *dns.Msg, ttl uint32, ifIndex int) {
+	if strings.EqualFold(q.Name, s.entry().HostName) {
+		s.composeAddrAnswers(resp, q.Qtype, ttl, ifIndex)
+		return
+	}
+	if typeMatches(q.Qtype, dns.TypePTR) {
+		resp.Answer = s.appendReverse(resp.Answer, q.Name, ttl, ifIndex, false)
+	}
+}
+
+// composeHostAnswers adds all address and reverse PTR records of the host.
+func (s *Server) composeHostAnswers(resp *dns.Msg, ttl uint32, ifIndex int, flushCache bool) {
+	resp.Answer = s.appendAddrs(resp.Answer, ttl, ifIndex, flushCache)
+	resp.Answer = s.appendReverse(resp.Answer, "", ttl, ifIndex, flushCache)
+}
+
+// appendReverse adds the reverse PTR records (in-addr.arpa/ip6.arpa) mapping
+// the host's addresses to its name. If name is set, only the record of that
+// name is added.
+func (s *Server) appendReverse(list []dns.RR, name string, ttl uint32, ifIndex int, flushCache bool) []dns.RR {
+	ttl = s.hostTTL(ttl)
+	var cacheFlushBit uint16
+	if flushCache {
+		cacheFlushBit = qClassCacheFlush
+	}
+	e := s.entry()
+	v4, v6 := s.addrs(e, ifIndex)
+	for _, ip := range append(v4, v6...) {
+		reverse, err := dns.ReverseAddr(ip.String())
+		if err != nil || (name != "" && !strings.EqualFold(name, reverse)) {
+			continue
+		}
+		list = append(list, &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   reverse,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET | cacheFlushBit,
+				Ttl:    ttl,
+			},
+			Ptr: e.HostName,
+		})
+	}
+	return list
+}
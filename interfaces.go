@@ -0,0 +1,246 @@
+package zeroconf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AddInterface starts answering on the given interface of a running server.
+// The multicast groups are joined on it and the records are announced on just
+// that interface. Adding an interface the server already uses is a no-op.
+func (s *Server) AddInterface(iface net.Interface) error {
+	s.setAutoInterfaces(false)
+	s.rejoinLock.Lock()
+	defer s.rejoinLock.Unlock()
+	return s.addInterface(iface)
+}
+
+// autoInterfaces reports whether the interfaces follow the multicast
+// interfaces of the system.
+func (s *Server) autoInterfaces() bool {
+	s.shutdownLock.Lock()
+	defer s.shutdownLock.Unlock()
+	return s.autoIfaces
+}
+
+func (s *Server) setAutoInterfaces(auto bool) {
+	s.shutdownLock.Lock()
+	s.autoIfaces = auto
+	s.shutdownLock.Unlock()
+}
+
+// addInterface joins the multicast groups on the interface and announces the
+// records on it. The caller must hold rejoinLock.
+func (s *Server) addInterface(iface net.Interface) error {
+	current := s.interfaces()
+	for _, intf := range current {
+		if intf.Index == iface.Index {
+			return nil
+		}
+	}
+
+	var joined bool
+	if s.ipv4conn != nil && s.ipv4conn.JoinGroup(&iface, &net.UDPAddr{IP: s.group().ipv4.IP}) == nil {
+		joined = true
+	}
+	if s.ipv6conn != nil && s.ipv6conn.JoinGroup(&iface, &net.UDPAddr{IP: s.group().ipv6.IP}) == nil {
+		joined = true
+	}
+	if !joined {
+		return fmt.Errorf("%w: failed to join interface %s", ErrNoInterface, iface.Name)
+	}
+
+	ifaces := make([]net.Interface, 0, len(current)+1)
+	s.setInterfaces(append(append(ifaces, current...), iface))
+	s.responses.clear()
+
+	s.lostLock.Lock()
+	delete(s.lost, iface.Index)
+	s.lostLock.Unlock()
+	s.emit(InterfaceJoined, &iface)
+
+	if !s.track() {
+		return nil
+	}
+	go func() {
+		defer s.shutdownEnd.Done()
+		for i := 0; i < multicastRepetitions; i++ {
+			if i > 0 && !s.sleep(time.Second) {
+				return
+			}
+			if err := s.multicastResponse(s.composeAnnouncement(iface.Index), iface.Index); err != nil {
+				s.log().Errorf("failed to send announcement: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// RemoveInterface stops answering on the named interface of a running server.
+// Goodbye packets are sent on just that interface before its multicast groups
+// are left.
+func (s *Server) RemoveInterface(name string) error {
+	s.rejoinLock.Lock()
+	defer s.rejoinLock.Unlock()
+	pos := -1
+	for i, intf := range s.interfaces() {
+		if strings.EqualFold(intf.Name, name) {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return fmt.Errorf("%w %s", ErrUnknownInterface, name)
+	}
+	s.setAutoInterfaces(false)
+	s.removeInterface(pos, true)
+	return nil
+}
+
+// removeInterface stops answering on the interface at the given position of
+// the interface list, sending goodbye packets on it first if requested. The
+// caller must hold rejoinLock.
+func (s *Server) removeInterface(pos int, goodbye bool) {
+	current := s.interfaces()
+	iface := current[pos]
+
+	if goodbye {
+		if err := s.multicastResponse(s.composeGoodbye(iface.Index), iface.Index); err != nil {
+			s.log().Errorf("failed to send goodbye: %v", err)
+		}
+	}
+	if s.ipv4conn != nil {
+		s.ipv4conn.LeaveGroup(&iface, &net.UDPAddr{IP: s.group().ipv4.IP})
+	}
+	if s.ipv6conn != nil {
+		s.ipv6conn.LeaveGroup(&iface, &net.UDPAddr{IP: s.group().ipv6.IP})
+	}
+
+	ifaces := make([]net.Interface, 0, len(current)-1)
+	ifaces = append(ifaces, current[:pos]...)
+	s.setInterfaces(append(ifaces, current[pos+1:]...))
+	s.responses.clear()
+
+	s.emit(InterfaceLost, &iface)
+}
+
+// monitorLoop periodically checks the network interfaces for changes. If
+// the interfaces were picked automatically, newly appeared interfaces are
+// joined and vanished ones are dropped. If the addresses of an interface
+// changed, e.g. after a Wi-Fi reconnect, the multicast groups are joined again
+// and the records are announced with the new addresses.
+func (s *Server) monitorLoop() {
+	defer s.shutdownEnd.Done()
+
+	addrs := make(map[int]string)
+	for _, iface := range s.interfaces() {
+		addrs[iface.Index] = interfaceAddrs(iface)
+	}
+	ticker := time.NewTicker(s.opts.monitorIval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shouldShutdown:
+			return
+		case <-ticker.C:
+		}
+		if s.refreshInterfaces(addrs) {
+			s.rejoin(true)
+		}
+	}
+}
+
+// refreshInterfaces updates the interface list and the known addresses per
+// interface, and reports whether the addresses of a remaining interface
+// changed.
+func (s *Server) refreshInterfaces(addrs map[int]string) bool {
+	auto := s.autoInterfaces()
+	s.rejoinLock.Lock()
+	defer s.rejoinLock.Unlock()
+
+	s.temporary.refresh()
+	current := make(map[int]net.Interface)
+	if auto {
+		for _, iface := range listMulticastInterfaces() {
+			current[iface.Index] = iface
+		}
+		ifaces := s.interfaces()
+		for pos := len(ifaces) - 1; pos >= 0; pos-- {
+			if _, ok := current[ifaces[pos].Index]; !ok {
+				delete(addrs, ifaces[pos].Index)
+				s.removeInterface(pos, false)
+			}
+		}
+		for _, iface := range current {
+			if _, ok := addrs[iface.Index]; ok {
+				continue
+			}
+			if err := s.addInterface(iface); err != nil {
+				continue
+			}
+			addrs[iface.Index] = interfaceAddrs(iface)
+		}
+	} else {
+		for _, iface := range refreshInterfaces(s.interfaces()) {
+			current[iface.Index] = iface
+		}
+	}
+
+	var changed bool
+	for _, iface := range s.interfaces() {
+		latest, ok := current[iface.Index]
+		if !ok {
+			continue
+		}
+		if a := interfaceAddrs(latest); a != addrs[iface.Index] {
+			addrs[iface.Index] = a
+			changed = true
+			s.responses.clear()
+		}
+	}
+	return changed
+}
+
+// interfaceAddrs returns the published addresses of an interface as a string
+// for comparison.
+func interfaceAddrs(iface net.Interface) string {
+	v4, v6 := addrsForInterface(&iface)
+	var list []string
+	for _, ip := range append(v4, v6...) {
+		list = append(list, ip.String())
+	}
+	return strings.Join(list, ",")
+}
+
+// Time after which the temporary addresses are looked up again, if the
+// interfaces are not monitored
+const temporaryAddrsRefresh = time.Minute
+
+// temporaryAddrCache holds the temporary IPv6 addresses of all interfaces, so
+// they are not looked up for every answer.
+type temporaryAddrCache struct {
+	sync.Mutex
+	addrs   map[int]map[string]bool
+	updated time.Time
+}
+
+// get returns the temporary addresses of the interface with the given index.
+func (c *temporaryAddrCache) get(ifIndex int) map[string]bool {
+	c.Lock()
+	defer c.Unlock()
+	if time.Since(c.updated) > temporaryAddrsRefresh {
+		c.addrs, c.updated = temporaryAddrs(), time.Now()
+	}
+	return c.addrs[ifIndex]
+}
+
+// refresh looks up the temporary addresses again.
+func (c *temporaryAddrCache) refresh() {
+	addrs := temporaryAddrs()
+	c.Lock()
+	defer c.Unlock()
+	c.addrs, c.updated = addrs, time.Now()
+}
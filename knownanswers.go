@@ -0,0 +1,78 @@
+package zeroconf
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Aggregation window for multi-packet known-answer lists (RFC6762 section
+// 7.2): 400ms plus a random delay of up to 100ms.
+const (
+	knownAnswerWait   = 400 * time.Millisecond
+	knownAnswerJitter = 100
+)
+
+// truncatedQuery is a query with the TC bit set, waiting for the packets
+// with the rest of its known answers.
+type truncatedQuery struct {
+	query   *dns.Msg
+	ifIndex int
+	from    net.Addr
+	timer   *time.Timer
+}
+
+// collectKnownAnswers merges the known-answer packets following a query with
+// the TC bit set. It returns the query to answer, or false if the server must
+// wait for more known answers. A query waiting for known answers is answered
+// at the latest when the aggregation window has passed.
+func (s *Server) collectKnownAnswers(query *dns.Msg, ifIndex int, from net.Addr) (*dns.Msg, bool) {
+	key := from.String()
+	s.truncatedLock.Lock()
+	defer s.truncatedLock.Unlock()
+
+	t := s.truncated[key]
+	if t == nil {
+		if !query.Truncated {
+			return query, true
+		}
+		if s.truncated == nil {
+			s.truncated = make(map[string]*truncatedQuery)
+		}
+		t = &truncatedQuery{query: query.Copy(), ifIndex: ifIndex, from: from}
+		t.timer = time.AfterFunc(knownAnswerDelay(), func() { s.flushKnownAnswers(key) })
+		s.truncated[key] = t
+		return nil, false
+	}
+
+	t.query.Question = append(t.query.Question, query.Question...)
+	t.query.Answer = append(t.query.Answer, query.Answer...)
+	if query.Truncated {
+		t.timer.Reset(knownAnswerDelay())
+		return nil, false
+	}
+	t.timer.Stop()
+	delete(s.truncated, key)
+	t.query.Truncated = false
+	return t.query, true
+}
+
+// flushKnownAnswers answers a truncated query once its aggregation window has
+// passed without the final known-answer packet.
+func (s *Server) flushKnownAnswers(key string) {
+	s.truncatedLock.Lock()
+	t := s.truncated[key]
+	delete(s.truncated, key)
+	s.truncatedLock.Unlock()
+	if t == nil {
+		return
+	}
+	t.query.Truncated = false
+	s.handleQuery(t.query, t.ifIndex, t.from)
+}
+
+func knownAnswerDelay() time.Duration {
+	return knownAnswerWait + time.Duration(rand.Intn(knownAnswerJitter))*time.Millisecond
+}
@@ -0,0 +1,53 @@
+package zeroconf
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// Maximum TTL of records in legacy unicast responses (RFC6762 section 6.7)
+const legacyUnicastTTL = 10
+
+// handleLegacyQuery answers a query from a conventional DNS resolver, which
+// sent it from a source port other than 5353. All questions are answered in
+// a single unicast response which echoes the query ID and the questions. The
+// records carry no cache flush bit and a TTL of at most 10 seconds.
+// Responses too large for the querier are truncated.
+func (s *Server) handleLegacyQuery(query *dns.Msg, ifIndex int, from net.Addr) error {
+	resp := dns.Msg{}
+	resp.SetReply(query)
+	resp.Compress = true
+	resp.RecursionDesired = false
+	resp.Authoritative = true
+	resp.Answer = []dns.RR{}
+	resp.Extra = []dns.RR{}
+	for _, q := range query.Question {
+		if !s.acceptQuestion(q, from) {
+			continue
+		}
+		if err := s.handleQuestion(q, &resp, query, ifIndex, true); err != nil {
+			s.log().Debugf("failed to handle question %v: %v", q, err)
+		}
+	}
+	if len(resp.Answer) == 0 {
+		return nil
+	}
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			hdr.Class &^= qClassCacheFlush
+			if hdr.Ttl > legacyUnicastTTL {
+				hdr.Ttl = legacyUnicastTTL
+			}
+		}
+	}
+	// The response must fit into a single packet of the size the querier
+	// accepts; if answers have to be left out, the TC bit is set.
+	size := dns.MinMsgSize
+	if opt := query.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+		size = int(opt.UDPSize())
+	}
+	resp.Truncate(size)
+	return s.unicastResponse(&resp, ifIndex, from)
+}
@@ -0,0 +1,45 @@
+package zeroconf
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// RegisterFromListener registers a service in the local domain on all
+// interfaces, deriving the port from the listener. If the listener is bound
+// to a specific address, only that address is published. Listeners bound to a
+// loopback address are rejected, as peers could not connect to them.
+//
+// The returned listener wraps ln: closing it also shuts the server down, so
+// the service is withdrawn together with the listener. Closing ln directly
+// leaves the registration in place.
+func RegisterFromListener(instance, service string, ln net.Listener, text []string, opts ...ServerOption) (*Server, net.Listener, error) {
+	addr, ok := ln.Addr().(*net.TCPAddr)
+	if !ok {
+		return nil, nil, fmt.Errorf("Unsupported listener address %s", ln.Addr())
+	}
+	if addr.IP.IsLoopback() {
+		return nil, nil, fmt.Errorf("%w: listener bound to loopback address %s", ErrInvalidAddress, addr.IP)
+	}
+	if len(addr.IP) > 0 && !addr.IP.IsUnspecified() {
+		opts = append([]ServerOption{WithIPs(addr.IP)}, opts...)
+	}
+	s, err := Register(instance, service, "local.", addr.Port, text, nil, 0, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, &serviceListener{Listener: ln, server: s}, nil
+}
+
+// serviceListener shuts the server down when the listener is closed.
+type serviceListener struct {
+	net.Listener
+	server *Server
+	once   sync.Once
+}
+
+func (l *serviceListener) Close() error {
+	l.once.Do(l.server.Shutdown)
+	return l.Listener.Close()
+}
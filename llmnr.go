@@ -0,0 +1,203 @@
+package zeroconf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	// Multicast groups used by LLMNR (RFC 4795)
+	llmnrGroupIPv4 = net.IPv4(224, 0, 0, 252)
+	llmnrGroupIPv6 = net.ParseIP("ff02::1:3")
+)
+
+const (
+	llmnrPort = 5355
+	// Default TTL of LLMNR answers, see RFC 4795 section 2.8
+	llmnrTTL = 30
+)
+
+// llmnrResponder answers LLMNR queries for the server's host name.
+type llmnrResponder struct {
+	ipv4conn *ipv4.PacketConn
+	ipv6conn *ipv6.PacketConn
+}
+
+func joinLLMNR(interfaces []net.Interface, useIPv4, useIPv6 bool) (*llmnrResponder, error) {
+	r := &llmnrResponder{}
+	if useIPv4 {
+		r.ipv4conn = joinLLMNR4(interfaces)
+	}
+	if useIPv6 {
+		r.ipv6conn = joinLLMNR6(interfaces)
+	}
+	if r.ipv4conn == nil && r.ipv6conn == nil {
+		return nil, fmt.Errorf("llmnr: %w: failed to join any of these interfaces: %v", ErrNoInterface, interfaces)
+	}
+	return r, nil
+}
+
+// joinLLMNR4 joins the IPv4 LLMNR group on the interfaces. It returns nil if
+// no interface could be joined.
+func joinLLMNR4(interfaces []net.Interface) *ipv4.PacketConn {
+	udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: llmnrPort})
+	if err != nil {
+		return nil
+	}
+	pkConn := ipv4.NewPacketConn(udpConn)
+	pkConn.SetControlMessage(ipv4.FlagInterface, true)
+	var joined int
+	for _, iface := range interfaces {
+		if err := pkConn.JoinGroup(&iface, &net.UDPAddr{IP: llmnrGroupIPv4}); err == nil {
+			joined++
+		}
+	}
+	if joined == 0 {
+		pkConn.Close()
+		return nil
+	}
+	return pkConn
+}
+
+// joinLLMNR6 joins the IPv6 LLMNR group on the interfaces. It returns nil if
+// no interface could be joined.
+func joinLLMNR6(interfaces []net.Interface) *ipv6.PacketConn {
+	udpConn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6unspecified, Port: llmnrPort})
+	if err != nil {
+		return nil
+	}
+	pkConn := ipv6.NewPacketConn(udpConn)
+	pkConn.SetControlMessage(ipv6.FlagInterface, true)
+	var joined int
+	for _, iface := range interfaces {
+		if err := pkConn.JoinGroup(&iface, &net.UDPAddr{IP: llmnrGroupIPv6}); err == nil {
+			joined++
+		}
+	}
+	if joined == 0 {
+		pkConn.Close()
+		return nil
+	}
+	return pkConn
+}
+
+func (r *llmnrResponder) close() {
+	if r.ipv4conn != nil {
+		r.ipv4conn.Close()
+	}
+	if r.ipv6conn != nil {
+		r.ipv6conn.Close()
+	}
+}
+
+// startLLMNR starts answering LLMNR queries, if enabled.
+func (s *Server) startLLMNR() {
+	r, err := joinLLMNR(s.interfaces(), !s.opts.noIPv4, !s.opts.noIPv6)
+	if err != nil {
+		s.log().Errorf("failed to start LLMNR responder: %v", err)
+		return
+	}
+	s.llmnr = r
+	if r.ipv4conn != nil {
+		s.shutdownEnd.Add(1)
+		go s.recvLLMNR(func(b []byte) (int, int, net.Addr, error) {
+			n, cm, from, err := r.ipv4conn.ReadFrom(b)
+			if cm != nil {
+				return n, cm.IfIndex, from, err
+			}
+			return n, 0, from, err
+		}, func(b []byte, to net.Addr) error {
+			_, err := r.ipv4conn.WriteTo(b, nil, to)
+			return err
+		})
+	}
+	if r.ipv6conn != nil {
+		s.shutdownEnd.Add(1)
+		go s.recvLLMNR(func(b []byte) (int, int, net.Addr, error) {
+			n, cm, from, err := r.ipv6conn.ReadFrom(b)
+			if cm != nil {
+				return n, cm.IfIndex, from, err
+			}
+			return n, 0, from, err
+		}, func(b []byte, to net.Addr) error {
+			_, err := r.ipv6conn.WriteTo(b, nil, to)
+			return err
+		})
+	}
+}
+
+// recvLLMNR is a long running routine answering LLMNR queries. Failed reads
+// are retried with a backoff; a closed socket is reported via Err.
+func (s *Server) recvLLMNR(readFrom func([]byte) (int, int, net.Addr, error), writeTo func([]byte, net.Addr) error) {
+	defer s.shutdownEnd.Done()
+	bufp := getBuffer()
+	defer putBuffer(bufp)
+	buf := *bufp
+	var backoff time.Duration
+	for {
+		n, ifIndex, from, err := readFrom(buf)
+		if err != nil {
+			select {
+			case <-s.shouldShutdown:
+				return
+			default:
+			}
+			if isFatalReadError(err) {
+				s.fail(err)
+				return
+			}
+			s.log().Debugf("failed to read LLMNR query: %v", err)
+			backoff = nextReadBackoff(backoff)
+			if !s.sleep(backoff) {
+				return
+			}
+			continue
+		}
+		backoff = 0
+		var query dns.Msg
+		if err := query.Unpack(buf[:n]); err != nil {
+			continue
+		}
+		resp := s.handleLLMNR(&query, ifIndex)
+		if resp == nil {
+			continue
+		}
+		if out, err := resp.Pack(); err == nil {
+			writeTo(out, from)
+		}
+	}
+}
+
+// handleLLMNR builds the reply to an LLMNR query for the server's host name.
+// Queries for other names are not answered, as required by RFC 4795.
+func (s *Server) handleLLMNR(query *dns.Msg, ifIndex int) *dns.Msg {
+	if query.Response || query.Opcode != dns.OpcodeQuery || len(query.Question) != 1 {
+		return nil
+	}
+	q := query.Question[0]
+	hostLabel := strings.SplitN(trimDot(s.entry().HostName), ".", 2)[0]
+	if !strings.EqualFold(trimDot(q.Name), hostLabel) {
+		return nil
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.Compress = true
+	resp.RecursionAvailable = false
+	resp.Authoritative = false // the conflict bit in LLMNR
+	for _, rr := range s.appendAddrs(nil, llmnrTTL, ifIndex, false) {
+		hdr := rr.Header()
+		if q.Qtype != dns.TypeANY && q.Qtype != hdr.Rrtype {
+			continue
+		}
+		hdr.Name = q.Name
+		resp.Answer = append(resp.Answer, rr)
+	}
+	return resp
+}
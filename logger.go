@@ -0,0 +1,68 @@
+package zeroconf
+
+import "log"
+
+// Logger receives the log messages of servers and resolvers. Debug messages
+// report per-packet events, info messages notable state changes, and error
+// messages failures of the service operation.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// stdLogger writes info and error messages to the standard logger and drops
+// debug messages. It is used if no logger is configured.
+type stdLogger struct{}
+
+func (stdLogger) Debugf(format string, args ...interface{}) {}
+
+func (stdLogger) Infof(format string, args ...interface{}) {
+	log.Printf("[INFO] zeroconf: "+format, args...)
+}
+
+func (stdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf("[ERR] zeroconf: "+format, args...)
+}
+
+// nopLogger discards all messages.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+
+// DiscardLogger is a Logger which discards all messages.
+var DiscardLogger Logger = nopLogger{}
+
+// WithLogger sets the logger of the server. By default, info and error
+// messages go to the standard logger.
+func WithLogger(l Logger) ServerOption {
+	return func(o *serverOpts) {
+		o.logger = l
+	}
+}
+
+// LogTo sets the logger of the resolver. By default, info and error messages
+// go to the standard logger.
+func LogTo(l Logger) ClientOption {
+	return func(o *clientOpts) {
+		o.logger = l
+	}
+}
+
+// log returns the logger of the server.
+func (s *Server) log() Logger {
+	if s.opts.logger == nil {
+		return stdLogger{}
+	}
+	return s.opts.logger
+}
+
+// log returns the logger of the client.
+func (c *client) log() Logger {
+	if c.logger == nil {
+		return stdLogger{}
+	}
+	return c.logger
+}
@@ -0,0 +1,135 @@
+package zeroconf
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Time to wait for further address records after the first one was received,
+// so the addresses of both families sent in separate responses are collected.
+const hostLookupGrace = 200 * time.Millisecond
+
+// LookupHost resolves the addresses of a host, e.g. "mybox.local". Hosts in
+// the .local domain are queried via multicast DNS; the query is repeated with
+// the intervals of a continuous browse until an address is received. Other
+// hosts are resolved with conventional unicast DNS queries. The addresses are
+// restricted to and ordered by the address preference of the resolver. If the
+// context expires before any address is received, its error is returned. Like
+// Lookup, it consumes the resolver.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]net.IP, error) {
+	c := r.c
+	defer c.shutdown()
+	host = dns.Fqdn(host)
+	if !IsLocalHost(host) {
+		return c.lookupHostUnicast(ctx, host)
+	}
+
+	msgCh := make(chan *inbound, 32)
+	if c.ipv4conn != nil {
+		go c.recv(ctx, c.ipv4conn, msgCh)
+	}
+	if c.ipv6conn != nil {
+		go c.recv(ctx, c.ipv6conn, msgCh)
+	}
+	if err := c.queryAddrs(host); err != nil {
+		return nil, err
+	}
+
+	var v4, v6 []net.IP
+	wait := continuousQueryMin
+	requery := time.NewTimer(wait)
+	defer requery.Stop()
+	var grace <-chan time.Time
+	for {
+		select {
+		case in := <-msgCh:
+			for _, rr := range append(in.msg.Answer, in.msg.Extra...) {
+				if !strings.EqualFold(rr.Header().Name, host) || rr.Header().Ttl == 0 {
+					continue
+				}
+				switch rr := rr.(type) {
+				case *dns.A:
+					if c.addrPref.wantsIPv4() {
+						v4 = appendAddr(v4, rr.A)
+					}
+				case *dns.AAAA:
+					if c.addrPref.wantsIPv6() {
+						v6 = appendAddr(v6, rr.AAAA)
+					}
+				}
+			}
+			if grace == nil && len(v4)+len(v6) > 0 {
+				grace = time.After(hostLookupGrace)
+			}
+		case <-grace:
+			return c.orderAddrs(v4, v6), nil
+		case <-requery.C:
+			if err := c.queryAddrs(host); err != nil {
+				c.log().Debugf("Failed to query host %s: %v", host, err)
+			}
+			if wait *= 2; wait > continuousQueryMax {
+				wait = continuousQueryMax
+			}
+			requery.Reset(wait)
+		case <-ctx.Done():
+			if len(v4)+len(v6) > 0 {
+				return c.orderAddrs(v4, v6), nil
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// lookupHostUnicast resolves the addresses of a host with conventional
+// unicast DNS queries.
+func (c *client) lookupHostUnicast(ctx context.Context, host string) ([]net.IP, error) {
+	var v4, v6 []net.IP
+	var lastErr error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		if (qtype == dns.TypeA && !c.addrPref.wantsIPv4()) || (qtype == dns.TypeAAAA && !c.addrPref.wantsIPv6()) {
+			continue
+		}
+		resp, err := c.exchangeUnicast(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rr := range resp.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				v4 = appendAddr(v4, rr.A)
+			case *dns.AAAA:
+				v6 = appendAddr(v6, rr.AAAA)
+			}
+		}
+	}
+	if len(v4)+len(v6) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return c.orderAddrs(v4, v6), nil
+}
+
+// orderAddrs lists the addresses of the preferred family first.
+func (c *client) orderAddrs(v4, v6 []net.IP) []net.IP {
+	if c.addrPref == PreferIPv6 {
+		return append(v6, v4...)
+	}
+	return append(v4, v6...)
+}
+
+// appendAddr appends an address unless it is listed already.
+func appendAddr(addrs []net.IP, ip net.IP) []net.IP {
+	for _, addr := range addrs {
+		if addr.Equal(ip) {
+			return addrs
+		}
+	}
+	return append(addrs, ip)
+}
@@ -0,0 +1,183 @@
+// Package metrics exports the statistics of zeroconf servers and resolvers as
+// Prometheus collectors, so the health of mDNS can be graphed alongside the
+// other metrics of a service:
+//
+//	server, err := zeroconf.Register("GoZeroconf", "_workstation._tcp", "local.", 42424, nil, nil)
+//	...
+//	prometheus.MustRegister(metrics.NewServerCollector(server, prometheus.Labels{"service": "workstation"}))
+package metrics
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "mdns"
+
+// A question not answered within this time is no longer waited for, so the
+// questions for names of other responders do not pile up.
+const pendingQuestionTimeout = 5 * time.Second
+
+// question identifies a received question waiting for its answer.
+type question struct {
+	name  string
+	qtype uint16
+}
+
+// ServerCollector exports the counters of a Server, its name conflicts and
+// the latency of its responses.
+//
+// The latency is measured from receiving a question until an answer for it
+// is sent, including the delay of shared answers. To measure it, the collector
+// sets the OnQuery and OnResponseSent hooks of the server, replacing hooks
+// set before.
+type ServerCollector struct {
+	server *zeroconf.Server
+
+	queries     *prometheus.Desc
+	responses   *prometheus.Desc
+	parseErrors *prometheus.Desc
+	dropped     *prometheus.Desc
+	suppressed  *prometheus.Desc
+	bytesIn     *prometheus.Desc
+	bytesOut    *prometheus.Desc
+	conflicts   *prometheus.Desc
+	latency     prometheus.Histogram
+
+	lock      sync.Mutex
+	pending   map[question]time.Time
+	lastPurge time.Time
+}
+
+// NewServerCollector creates a collector for the server. The labels are
+// added to all metrics, which distinguishes several servers registered with
+// the same registry.
+func NewServerCollector(s *zeroconf.Server, labels prometheus.Labels) *ServerCollector {
+	desc := func(name, help string, variableLabels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "server", name), help, variableLabels, labels)
+	}
+	c := &ServerCollector{
+		server:      s,
+		queries:     desc("queries_received_total", "Number of mDNS queries received."),
+		responses:   desc("responses_sent_total", "Number of mDNS responses sent, by unicast or multicast.", "type"),
+		parseErrors: desc("parse_errors_total", "Number of received packets which could not be parsed."),
+		dropped:     desc("dropped_packets_total", "Number of received packets dropped because the worker queue was full."),
+		suppressed:  desc("suppressed_answers_total", "Number of answers not sent because they were multicast recently."),
+		bytesIn:     desc("received_bytes_total", "Number of bytes of mDNS packets received."),
+		bytesOut:    desc("sent_bytes_total", "Number of bytes of mDNS packets sent."),
+		conflicts:   desc("conflicts_total", "Number of name conflicts with other responders."),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   "server",
+			Name:        "response_latency_seconds",
+			Help:        "Time from receiving a question until its answer is sent.",
+			ConstLabels: labels,
+			Buckets:     []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+		}),
+		pending: make(map[question]time.Time),
+	}
+	s.OnQuery(c.questionReceived)
+	s.OnResponseSent(c.responseSent)
+	return c
+}
+
+// Describe implements prometheus.Collector.
+func (c *ServerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queries
+	ch <- c.responses
+	ch <- c.parseErrors
+	ch <- c.dropped
+	ch <- c.suppressed
+	ch <- c.bytesIn
+	ch <- c.bytesOut
+	ch <- c.conflicts
+	c.latency.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *ServerCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.server.Stats()
+	counter := func(desc *prometheus.Desc, v uint64, labelValues ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), labelValues...)
+	}
+	counter(c.queries, stats.QueriesReceived)
+	counter(c.responses, stats.UnicastResponses, "unicast")
+	counter(c.responses, stats.MulticastResponses, "multicast")
+	counter(c.parseErrors, stats.ParseErrors)
+	counter(c.dropped, stats.DroppedPackets)
+	counter(c.suppressed, stats.SuppressedAnswers)
+	counter(c.bytesIn, stats.BytesIn)
+	counter(c.bytesOut, stats.BytesOut)
+	counter(c.conflicts, stats.Conflicts)
+	c.latency.Collect(ch)
+}
+
+// questionReceived remembers when a question was received first. All
+// questions are answered.
+func (c *ServerCollector) questionReceived(q dns.Question, from net.Addr) bool {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if now.Sub(c.lastPurge) > time.Second {
+		for k, t := range c.pending {
+			if now.Sub(t) > pendingQuestionTimeout {
+				delete(c.pending, k)
+			}
+		}
+		c.lastPurge = now
+	}
+	k := question{strings.ToLower(q.Name), q.Qtype}
+	if _, ok := c.pending[k]; !ok {
+		c.pending[k] = now
+	}
+	return true
+}
+
+// responseSent observes the latency of the questions the response answers.
+func (c *ServerCollector) responseSent(resp *dns.Msg, to net.Addr) {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, rr := range resp.Answer {
+		name := strings.ToLower(rr.Header().Name)
+		for _, qtype := range []uint16{rr.Header().Rrtype, dns.TypeANY} {
+			k := question{name, qtype}
+			if t, ok := c.pending[k]; ok {
+				c.latency.Observe(now.Sub(t).Seconds())
+				delete(c.pending, k)
+			}
+		}
+	}
+}
+
+// ResolverCollector exports the number of service entries a Resolver holds.
+type ResolverCollector struct {
+	resolver  *zeroconf.Resolver
+	cacheSize *prometheus.Desc
+}
+
+// NewResolverCollector creates a collector for the resolver. The labels are
+// added to all metrics.
+func NewResolverCollector(r *zeroconf.Resolver, labels prometheus.Labels) *ResolverCollector {
+	return &ResolverCollector{
+		resolver: r,
+		cacheSize: prometheus.NewDesc(prometheus.BuildFQName(namespace, "resolver", "cache_entries"),
+			"Number of service entries held by the running lookups.", nil, labels),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *ResolverCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cacheSize
+}
+
+// Collect implements prometheus.Collector.
+func (c *ResolverCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.cacheSize, prometheus.GaugeValue, float64(c.resolver.CacheSize()))
+}
@@ -0,0 +1,64 @@
+package zeroconf
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// appendNSEC adds an NSEC record (RFC 6762 section 6.1) for every unique
+// name in the response, asserting that the record types present are all the
+// types that exist for that name. Shared records, like the PTR records of
+// the service type, are not asserted.
+func appendNSEC(resp *dns.Msg) {
+	var names []string
+	types := make(map[string][]uint16)
+	ttls := make(map[string]uint32)
+	for _, rr := range append(resp.Answer, resp.Extra...) {
+		hdr := rr.Header()
+		if hdr.Class&qClassCacheFlush == 0 || hdr.Rrtype == dns.TypeNSEC {
+			continue
+		}
+		key := strings.ToLower(hdr.Name)
+		if _, ok := types[key]; !ok {
+			names = append(names, hdr.Name)
+		}
+		if !containsType(types[key], hdr.Rrtype) {
+			types[key] = append(types[key], hdr.Rrtype)
+		}
+		if hdr.Ttl > ttls[key] {
+			ttls[key] = hdr.Ttl
+		}
+	}
+	for _, name := range names {
+		key := strings.ToLower(name)
+		resp.Extra = append(resp.Extra, newNSEC(name, ttls[key], types[key]))
+	}
+}
+
+// newNSEC returns an NSEC record asserting that only the given record types
+// exist for the name, in the restricted form of RFC 6762 section 6.1.
+func newNSEC(name string, ttl uint32, types []uint16) *dns.NSEC {
+	bitmap := append([]uint16(nil), types...)
+	sort.Slice(bitmap, func(i, j int) bool { return bitmap[i] < bitmap[j] })
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET | qClassCacheFlush,
+			Ttl:    ttl,
+		},
+		NextDomain: name,
+		TypeBitMap: bitmap,
+	}
+}
+
+func containsType(list []uint16, t uint16) bool {
+	for _, v := range list {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,376 @@
+package zeroconf
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// PcapPacket is an mDNS message read from a capture file.
+type PcapPacket struct {
+	Time time.Time
+	Src  *net.UDPAddr
+	Dst  *net.UDPAddr
+	Msg  *dns.Msg
+	// Payload is the message as captured; if empty, Msg is packed instead.
+	Payload []byte
+}
+
+// Link types of pcap files understood by ReadPcap.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLoop     = 108
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+// ReadPcap reads all mDNS messages (UDP from or to port 5353) from a capture
+// in the classic pcap file format, e.g. as written by tcpdump -w. The pcapng
+// format is not supported. Packets which cannot be decoded are skipped.
+func ReadPcap(r io.Reader) ([]PcapPacket, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	var nanos bool
+	switch binary.LittleEndian.Uint32(hdr[0:4]) {
+	case 0xa1b2c3d4:
+		order = binary.LittleEndian
+	case 0xa1b23c4d:
+		order, nanos = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		order = binary.BigEndian
+	case 0x4d3cb2a1:
+		order, nanos = binary.BigEndian, true
+	default:
+		return nil, errors.New("pcap: unsupported file format")
+	}
+	linkType := order.Uint32(hdr[20:24]) & 0x0fffffff
+
+	var packets []PcapPacket
+	var rec [16]byte
+	for {
+		if _, err := io.ReadFull(r, rec[:]); err == io.EOF {
+			return packets, nil
+		} else if err != nil {
+			return packets, err
+		}
+		sec, frac := order.Uint32(rec[0:4]), order.Uint32(rec[4:8])
+		data := make([]byte, order.Uint32(rec[8:12]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return packets, err
+		}
+		if !nanos {
+			frac *= 1000
+		}
+		p, ok := decodePcapFrame(linkType, data)
+		if !ok {
+			continue
+		}
+		p.Time = time.Unix(int64(sec), int64(frac))
+		packets = append(packets, p)
+	}
+}
+
+// decodePcapFrame extracts an mDNS message from a captured link layer frame.
+func decodePcapFrame(linkType uint32, data []byte) (PcapPacket, bool) {
+	var etherType uint16
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return PcapPacket{}, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[12:14]), data[14:]
+		for etherType == 0x8100 && len(data) >= 4 { // VLAN tag
+			etherType, data = binary.BigEndian.Uint16(data[2:4]), data[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return PcapPacket{}, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[14:16]), data[16:]
+	case linkTypeSLL2:
+		if len(data) < 20 {
+			return PcapPacket{}, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[0:2]), data[20:]
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return PcapPacket{}, false
+		}
+		data = data[4:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+	default:
+		return PcapPacket{}, false
+	}
+	if etherType != 0 && etherType != 0x0800 && etherType != 0x86dd {
+		return PcapPacket{}, false
+	}
+
+	var src, dst net.IP
+	var udp []byte
+	switch {
+	case len(data) >= 20 && data[0]>>4 == 4:
+		ihl := int(data[0]&0x0f) * 4
+		fragmented := binary.BigEndian.Uint16(data[6:8])&0x3fff != 0
+		if data[9] != 17 || fragmented || len(data) < ihl {
+			return PcapPacket{}, false
+		}
+		src, dst, udp = net.IP(data[12:16]), net.IP(data[16:20]), data[ihl:]
+	case len(data) >= 40 && data[0]>>4 == 6:
+		if data[6] != 17 {
+			return PcapPacket{}, false
+		}
+		src, dst, udp = net.IP(data[8:24]), net.IP(data[24:40]), data[40:]
+	default:
+		return PcapPacket{}, false
+	}
+	if len(udp) < 8 {
+		return PcapPacket{}, false
+	}
+	srcPort, dstPort := int(binary.BigEndian.Uint16(udp[0:2])), int(binary.BigEndian.Uint16(udp[2:4]))
+	if srcPort != 5353 && dstPort != 5353 {
+		return PcapPacket{}, false
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(udp[8:]); err != nil {
+		return PcapPacket{}, false
+	}
+	return PcapPacket{
+		Src:     &net.UDPAddr{IP: append(net.IP(nil), src...), Port: srcPort},
+		Dst:     &net.UDPAddr{IP: append(net.IP(nil), dst...), Port: dstPort},
+		Msg:     msg,
+		Payload: append([]byte(nil), udp[8:]...),
+	}, true
+}
+
+// replayTransport is a Transport reading captured packets. Reads fail with
+// io.EOF once all packets were read; writes are discarded.
+type replayTransport struct {
+	packets []PcapPacket
+}
+
+func (t *replayTransport) ReadFrom(b []byte) (n, ifIndex, ttl int, src net.Addr, err error) {
+	for len(t.packets) > 0 {
+		p := t.packets[0]
+		t.packets = t.packets[1:]
+		payload := p.Payload
+		if len(payload) == 0 {
+			if payload, err = p.Msg.Pack(); err != nil {
+				continue
+			}
+		}
+		return copy(b, payload), 0, 0, p.Src, nil
+	}
+	return 0, 0, 0, nil, io.EOF
+}
+
+func (t *replayTransport) WriteTo(b []byte, ifIndex int, dst net.Addr) (int, error) {
+	return len(b), nil
+}
+
+func (t *replayTransport) JoinGroup(iface *net.Interface, group net.Addr) error {
+	return nil
+}
+
+func (t *replayTransport) LeaveGroup(iface *net.Interface, group net.Addr) error {
+	return nil
+}
+
+func (t *replayTransport) Close() error {
+	t.packets = nil
+	return nil
+}
+
+// Replay passes the queries of captured packets to the server, as if they
+// were received on the wire. Responses are sent as usual.
+func (s *Server) Replay(packets []PcapPacket) error {
+	var queries []PcapPacket
+	for _, p := range packets {
+		if p.Msg == nil || !p.Msg.Response {
+			queries = append(queries, p)
+		}
+	}
+	t := &replayTransport{packets: queries}
+	bufp := getBuffer()
+	defer putBuffer(bufp)
+	buf := *bufp
+	var err error
+	for {
+		n, ifIndex, _, from, readErr := t.ReadFrom(buf)
+		if readErr != nil {
+			return err
+		}
+		if e := s.parsePacket(buf[:n], ifIndex, from); e != nil {
+			err = e
+		}
+	}
+}
+
+// ReplayBrowse passes the captured packets to the resolver's response
+// handling for a browse of the given service, as if they were received on the
+// wire. Resolved entries are sent to the entries channel, which is closed when
+// all packets are processed.
+func ReplayBrowse(packets []PcapPacket, service, domain string, entries chan<- *ServiceEntry) {
+	params := newParams("", service, domain, entries)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	params.cancel = cancel
+	msgCh := make(chan *inbound)
+	done := make(chan struct{})
+	c := &client{}
+	go func() {
+		c.processMsgs(ctx, params, msgCh)
+		close(done)
+	}()
+	c.recv(ctx, &replayTransport{packets: packets}, msgCh)
+	cancel()
+	<-done
+}
+
+// PcapWriter writes mDNS packets in the classic pcap file format, so traffic
+// can be captured on devices without tcpdump. It is safe for concurrent use.
+type PcapWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewPcapWriter writes the pcap file header to w and returns a writer for
+// packets. Packets are written as raw IP (link type 101) with synthesized IP
+// and UDP headers.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], 0xa1b23c4d) // nanosecond timestamps
+	binary.LittleEndian.PutUint16(hdr[4:6], 2)
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], 65535)
+	binary.LittleEndian.PutUint32(hdr[20:24], linkTypeRaw)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w}, nil
+}
+
+// WritePacket writes a UDP packet with the given payload.
+func (pw *PcapWriter) WritePacket(ts time.Time, src, dst *net.UDPAddr, payload []byte) error {
+	if pw == nil || src == nil || dst == nil {
+		return nil
+	}
+	frame := ipFrame(src, dst, payload)
+	if frame == nil {
+		return errors.New("pcap: mixed address families")
+	}
+	var rec [16]byte
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:8], uint32(ts.Nanosecond()))
+	binary.LittleEndian.PutUint32(rec[8:12], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(rec[12:16], uint32(len(frame)))
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if _, err := pw.w.Write(rec[:]); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(frame)
+	return err
+}
+
+// write records a packet. Unknown sources are recorded as the unspecified
+// address, unknown destinations as the mDNS multicast group.
+func (pw *PcapWriter) write(src net.Addr, dst net.Addr, payload []byte) {
+	if pw == nil {
+		return
+	}
+	from, _ := src.(*net.UDPAddr)
+	to, _ := dst.(*net.UDPAddr)
+	switch {
+	case from == nil && to == nil:
+		return
+	case from == nil:
+		from = &net.UDPAddr{IP: net.IPv4zero, Port: 5353}
+		if to.IP.To4() == nil {
+			from.IP = net.IPv6unspecified
+		}
+	case to == nil:
+		to = ipv4Addr
+		if from.IP.To4() == nil {
+			to = ipv6Addr
+		}
+	}
+	pw.WritePacket(time.Now(), from, to, payload)
+}
+
+// ipFrame builds an IPv4 or IPv6 packet carrying a UDP datagram.
+func ipFrame(src, dst *net.UDPAddr, payload []byte) []byte {
+	udpLen := 8 + len(payload)
+	udp := make([]byte, udpLen)
+	binary.BigEndian.PutUint16(udp[0:2], uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpLen))
+	copy(udp[8:], payload)
+
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+		ip := make([]byte, 20, 20+udpLen)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], uint16(20+udpLen))
+		ip[8] = 255
+		ip[9] = 17
+		copy(ip[12:16], src4)
+		copy(ip[16:20], dst4)
+		binary.BigEndian.PutUint16(ip[10:12], checksum(0, ip))
+
+		pseudo := append(append([]byte(nil), src4...), dst4...)
+		pseudo = append(pseudo, 0, 17, byte(udpLen>>8), byte(udpLen))
+		binary.BigEndian.PutUint16(udp[6:8], checksum(checksumSum(0, pseudo), udp))
+		return append(ip, udp...)
+	}
+	if src.IP.To4() != nil || dst.IP.To4() != nil {
+		return nil
+	}
+	ip := make([]byte, 40, 40+udpLen)
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(udpLen))
+	ip[6] = 17
+	ip[7] = 255
+	copy(ip[8:24], src.IP.To16())
+	copy(ip[24:40], dst.IP.To16())
+
+	pseudo := append(append([]byte(nil), ip[8:40]...), 0, 0, byte(udpLen>>8), byte(udpLen), 0, 0, 0, 17)
+	binary.BigEndian.PutUint16(udp[6:8], checksum(checksumSum(0, pseudo), udp))
+	return append(ip, udp...)
+}
+
+// checksumSum adds data to a running internet checksum sum.
+func checksumSum(sum uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// checksum completes the internet checksum (RFC 1071) over data.
+func checksum(sum uint32, data []byte) uint16 {
+	sum = checksumSum(sum, data)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	c := ^uint16(sum)
+	if c == 0 {
+		c = 0xffff
+	}
+	return c
+}
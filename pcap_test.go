@@ -0,0 +1,88 @@
+package zeroconf
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// capture writes the messages to a pcap file as sent from src to the mDNS
+// group and reads them back.
+func capture(t *testing.T, src *net.UDPAddr, msgs ...*dns.Msg) []PcapPacket {
+	var buf bytes.Buffer
+	pw, err := NewPcapWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range msgs {
+		payload, err := msg.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pw.WritePacket(time.Now(), src, ipv4Addr, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	packets, err := ReadPcap(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != len(msgs) {
+		t.Fatalf("read %d packets, want %d", len(packets), len(msgs))
+	}
+	for _, p := range packets {
+		if len(p.Payload) == 0 || !p.Src.IP.Equal(src.IP) || p.Src.Port != src.Port {
+			t.Fatalf("packet from %v with %d bytes of payload", p.Src, len(p.Payload))
+		}
+	}
+	return packets
+}
+
+func TestReplay(t *testing.T) {
+	s, transport := newIdleServer(t)
+
+	// A legacy unicast query is answered at once; the response of another
+	// responder in the capture is ignored.
+	query := new(dns.Msg)
+	query.SetQuestion("_bench._tcp.local.", dns.TypePTR)
+	other := new(dns.Msg)
+	other.SetQuestion("_other._tcp.local.", dns.TypePTR)
+	other.Response = true
+	packets := capture(t, &net.UDPAddr{IP: net.ParseIP("192.0.2.7"), Port: 40000}, other, query)
+
+	if err := s.Replay(packets); err != nil {
+		t.Fatal(err)
+	}
+	if !transport.awaitAnswer("bench._bench._tcp.local.", time.Second) {
+		t.Error("replayed query not answered")
+	}
+}
+
+func TestReplayBrowse(t *testing.T) {
+	s, _ := newIdleServer(t)
+	packets := capture(t, &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5353}, s.composeAnnouncement(0))
+
+	entries := make(chan *ServiceEntry, 4)
+	go ReplayBrowse(packets, "_bench._tcp", "local.", entries)
+	var found *ServiceEntry
+	for e := range entries {
+		if e.Instance == "bench" {
+			found = e
+		}
+	}
+	if found == nil {
+		t.Fatal("replayed announcement not resolved")
+	}
+	if found.Port != 8080 {
+		t.Errorf("port %d, want 8080", found.Port)
+	}
+	if v, ok := found.TXT("path"); !ok || v != "/" {
+		t.Errorf("TXT path=%q, want %q", v, "/")
+	}
+	if len(found.AddrIPv4) != 1 || !found.AddrIPv4[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("IPv4 addresses %v, want [192.0.2.1]", found.AddrIPv4)
+	}
+}
@@ -0,0 +1,135 @@
+package zeroconf
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// Size of receive buffers, large enough for any UDP packet
+	recvBufferSize = 65536
+	// Size of the DNS message header
+	msgHeaderSize = 12
+	// Sections of pooled messages with a larger capacity are dropped, so a
+	// single large packet does not hold on to its records.
+	maxPooledRecords = 64
+)
+
+var (
+	// Receive buffers. A receive loop holds its buffer for as long as it
+	// runs, but every lookup of a resolver starts new loops.
+	bufferPool = sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, recvBufferSize)
+			return &b
+		},
+	}
+	// Messages received packets are unpacked into
+	msgPool = sync.Pool{
+		New: func() interface{} {
+			return new(dns.Msg)
+		},
+	}
+)
+
+// getBuffer returns a receive buffer from the pool.
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+// putBuffer returns a receive buffer to the pool.
+func putBuffer(b *[]byte) {
+	bufferPool.Put(b)
+}
+
+// getMsg returns an empty message from the pool.
+func getMsg() *dns.Msg {
+	return msgPool.Get().(*dns.Msg)
+}
+
+// putMsg clears a message and returns it to the pool. Neither the message nor
+// its sections may be referenced afterwards; the records may. The capacity of
+// the sections is kept for the next unpackMsg.
+func putMsg(m *dns.Msg) {
+	question := m.Question[:0]
+	if cap(question) > maxPooledRecords {
+		question = nil
+	}
+	answer, ns, extra := pooledRecords(m.Answer), pooledRecords(m.Ns), pooledRecords(m.Extra)
+	*m = dns.Msg{}
+	m.Question, m.Answer, m.Ns, m.Extra = question, answer, ns, extra
+	msgPool.Put(m)
+}
+
+// pooledRecords clears a section so its records can be garbage collected and
+// returns it emptied, or nil if it is too large to be kept.
+func pooledRecords(records []dns.RR) []dns.RR {
+	if cap(records) > maxPooledRecords {
+		return nil
+	}
+	for i := range records {
+		records[i] = nil
+	}
+	return records[:0]
+}
+
+// unpackMsg unpacks a packet into a message from the pool like dns.Msg.Unpack,
+// but appends the records to the emptied sections, reusing their capacity.
+func unpackMsg(m *dns.Msg, packet []byte) error {
+	question, answer, ns, extra := m.Question[:0], m.Answer[:0], m.Ns[:0], m.Extra[:0]
+	// Unpacking just the header sets the header fields only
+	header := packet
+	if len(header) > msgHeaderSize {
+		header = header[:msgHeaderSize]
+	}
+	if err := m.Unpack(header); err != nil {
+		return err
+	}
+	m.Question, m.Answer, m.Ns, m.Extra = question, answer, ns, extra
+
+	// The counts are not trusted: unpacking stops at the end of the packet.
+	off := msgHeaderSize
+	for i := binary.BigEndian.Uint16(packet[4:]); i > 0 && off < len(packet); i-- {
+		var q dns.Question
+		var err error
+		if q.Name, off, err = dns.UnpackDomainName(packet, off); err != nil {
+			return err
+		}
+		if off+4 > len(packet) {
+			return dns.ErrBuf
+		}
+		q.Qtype = binary.BigEndian.Uint16(packet[off:])
+		q.Qclass = binary.BigEndian.Uint16(packet[off+2:])
+		off += 4
+		m.Question = append(m.Question, q)
+	}
+	var err error
+	if m.Answer, off, err = unpackRecords(m.Answer, packet, off, binary.BigEndian.Uint16(packet[6:])); err != nil {
+		return err
+	}
+	if m.Ns, off, err = unpackRecords(m.Ns, packet, off, binary.BigEndian.Uint16(packet[8:])); err != nil {
+		return err
+	}
+	if m.Extra, _, err = unpackRecords(m.Extra, packet, off, binary.BigEndian.Uint16(packet[10:])); err != nil {
+		return err
+	}
+	if opt := m.IsEdns0(); opt != nil {
+		m.Rcode |= opt.ExtendedRcode()
+	}
+	return nil
+}
+
+// unpackRecords appends up to count records from the packet at off to the
+// section.
+func unpackRecords(section []dns.RR, packet []byte, off int, count uint16) ([]dns.RR, int, error) {
+	for ; count > 0 && off < len(packet); count-- {
+		rr, next, err := dns.UnpackRR(packet, off)
+		if err != nil {
+			return section, next, err
+		}
+		section, off = append(section, rr), next
+	}
+	return section, off, nil
+}
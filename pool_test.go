@@ -0,0 +1,128 @@
+package zeroconf
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// newIdleServer returns a server which is not started, so it answers queries
+// without probing first. Its packets are sent to the returned transport.
+func newIdleServer(tb testing.TB) (*Server, *memTransport) {
+	entry := NewServiceEntry("bench", "_bench._tcp", "local.")
+	entry.Port = 8080
+	entry.Text = []string{"txtvers=1", "path=/"}
+	if err := completeEntry(entry); err != nil {
+		tb.Fatal(err)
+	}
+	transport := newMemTransport()
+	s, err := newServer(nil, 0, []ServerOption{
+		WithTransport(transport, nil),
+		WithIPs(net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")),
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s.setService(entry)
+	return s, transport
+}
+
+// BenchmarkQueryStorm measures receiving queries for the service as fast as
+// they arrive, from unpacking the packet to sending the response.
+func BenchmarkQueryStorm(b *testing.B) {
+	query := new(dns.Msg)
+	query.SetQuestion("_bench._tcp.local.", dns.TypePTR)
+	query.Id = 0
+	query.RecursionDesired = false
+	packet, err := query.Pack()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	sources := map[string]net.Addr{
+		// Shared answers, delayed and aggregated
+		"multicast": &net.UDPAddr{IP: net.ParseIP("192.0.2.7"), Port: 5353},
+		// Answered every time
+		"legacy": &net.UDPAddr{IP: net.ParseIP("192.0.2.7"), Port: 40000},
+	}
+	for name, from := range sources {
+		b.Run(name, func(b *testing.B) {
+			s, _ := newIdleServer(b)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := s.parsePacket(packet, 0, from); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkUnpack compares unpacking a response into a pooled message with
+// unpacking it into a new one.
+func BenchmarkUnpack(b *testing.B) {
+	s, _ := newIdleServer(b)
+	resp := s.composeAnnouncement(0)
+	packet, err := resp.Pack()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			msg := new(dns.Msg)
+			if err := msg.Unpack(packet); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			msg := getMsg()
+			if err := unpackMsg(msg, packet); err != nil {
+				b.Fatal(err)
+			}
+			putMsg(msg)
+		}
+	})
+}
+
+// TestUnpackMsg checks that a pooled message reused for several packets is
+// unpacked like a new one.
+func TestUnpackMsg(t *testing.T) {
+	s, _ := newIdleServer(t)
+	query := new(dns.Msg)
+	query.SetQuestion("_bench._tcp.local.", dns.TypePTR)
+	query.Answer = []dns.RR{&dns.PTR{
+		Hdr: dns.RR_Header{Name: "_bench._tcp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 4500},
+		Ptr: "other._bench._tcp.local.",
+	}}
+	query.SetEdns0(1440, false)
+
+	msg := getMsg()
+	defer putMsg(msg)
+	for _, m := range []*dns.Msg{s.composeAnnouncement(0), query, s.composeGoodbye(0)} {
+		packet, err := m.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := new(dns.Msg)
+		if err := want.Unpack(packet); err != nil {
+			t.Fatal(err)
+		}
+		if err := unpackMsg(msg, packet); err != nil {
+			t.Fatal(err)
+		}
+		if got := msg.String(); got != want.String() {
+			t.Errorf("unpacked\n%s\nwant\n%s", got, want)
+		}
+		putMsg(msg)
+	}
+	if err := unpackMsg(msg, []byte{0, 1, 0}); err == nil {
+		t.Error("short packet unpacked")
+	}
+}
@@ -0,0 +1,312 @@
+package zeroconf
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Outcomes of probing reported via the conflict channel.
+type probeConflict uint8
+
+const (
+	// Another responder answered for one of our unique names.
+	conflictAnswered probeConflict = iota + 1
+	// A simultaneous probe for the same name won the tiebreak
+	// (RFC6762 section 8.2).
+	conflictTiebreak
+)
+
+// claimedRecords returns the unique records the server claims on any
+// interface: the SRV and TXT records of the instance, or the address records
+// of a host-only server.
+func (s *Server) claimedRecords() []dns.RR {
+	var records []dns.RR
+	if s.hostOnly {
+		return s.appendAddrs(nil, s.ttl, 0, false)
+	}
+	seen := make(map[string]bool)
+	s.eachInterface(func(ifIndex int) {
+		q := new(dns.Msg)
+		s.composeProbe(q, ifIndex)
+		if s.isProbingHost() {
+			q.Ns = s.appendAddrs(q.Ns, s.ttl, ifIndex, false)
+		}
+		for _, rr := range q.Ns {
+			if key := rr.String(); !seen[key] {
+				seen[key] = true
+				records = append(records, rr)
+			}
+		}
+	})
+	return records
+}
+
+// isClaimedName reports whether the name is one of the unique names the
+// server claims.
+func (s *Server) isClaimedName(name string) bool {
+	if s.hostOnly {
+		return strings.EqualFold(name, s.entry().HostName)
+	}
+	claimed := s.isProbingHost() && strings.EqualFold(name, s.entry().HostName)
+	s.eachInterface(func(ifIndex int) {
+		claimed = claimed || strings.EqualFold(name, s.instanceName(ifIndex))
+	})
+	return claimed
+}
+
+// sentRecently reports whether we multicast the record within the
+// loopbackWindow on the interface, or on any interface if ifIndex is 0.
+func (s *Server) sentRecently(rr dns.RR, ifIndex int) bool {
+	now := time.Now()
+	if ifIndex != 0 {
+		return s.history.sentWithin(rr, ifIndex, loopbackWindow, now)
+	}
+	for _, intf := range s.interfaces() {
+		if s.history.sentWithin(rr, intf.Index, loopbackWindow, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// signalConflict reports a conflict to a running probe without blocking.
+func (s *Server) signalConflict(c probeConflict) {
+	select {
+	case s.conflict <- c:
+	default:
+	}
+}
+
+// isProbing reports whether the server is currently probing for its names.
+func (s *Server) isProbing() bool {
+	s.probeLock.Lock()
+	defer s.probeLock.Unlock()
+	return s.probing
+}
+
+// isProbingHost reports whether the server is probing for a new host name
+// in addition to the instance name.
+func (s *Server) isProbingHost() bool {
+	s.probeLock.Lock()
+	defer s.probeLock.Unlock()
+	return s.probingHost
+}
+
+func (s *Server) setProbingHost(probing bool) {
+	s.probeLock.Lock()
+	s.probingHost = probing
+	s.probeLock.Unlock()
+}
+
+// checkConflict inspects a response received on the interface for records of
+// our unique names with different data. Records we claim ourselves, e.g.
+// looped back from another interface, records we sent just before, e.g. the
+// repetitions of an announcement superseded by a newer change, and goodbye
+// records are no conflict.
+func (s *Server) checkConflict(resp *dns.Msg, ifIndex int) {
+	if s.entry() == nil {
+		return
+	}
+	var claimed []dns.RR
+	for _, rr := range append(resp.Answer, resp.Extra...) {
+		hdr := rr.Header()
+		if hdr.Ttl == 0 || !s.isClaimedName(hdr.Name) {
+			continue
+		}
+		switch hdr.Rrtype {
+		case dns.TypeSRV, dns.TypeTXT, dns.TypeA, dns.TypeAAAA:
+		default:
+			continue
+		}
+		if claimed == nil {
+			claimed = s.claimedRecords()
+		}
+		if !containsRecord(claimed, rr) && !s.sentRecently(rr, ifIndex) {
+			if s.isProbing() {
+				s.signalConflict(conflictAnswered)
+				return
+			}
+			atomic.AddUint64(&s.stats.conflicts, 1)
+			s.emit(Conflict, nil)
+			if !s.announced() {
+				return
+			}
+			if s.opts.onConflict != nil {
+				s.opts.onConflict(rr)
+			}
+			go s.defendNames()
+			return
+		}
+	}
+}
+
+// defendNames handles a conflict detected after the announcement: the server
+// goes back to probing (RFC6762 section 9). If no other responder answers the
+// probes, the records are announced again; otherwise the service is renamed.
+// After 15 conflicts within ten seconds, the server waits five seconds before
+// probing again.
+func (s *Server) defendNames() {
+	s.probeLock.Lock()
+	if s.probing || s.defending {
+		s.probeLock.Unlock()
+		return
+	}
+	s.defending = true
+	s.probeLock.Unlock()
+	defer func() {
+		s.probeLock.Lock()
+		s.defending = false
+		s.probeLock.Unlock()
+	}()
+
+	select {
+	case <-s.shouldShutdown:
+		return
+	case <-time.After(s.conflictDelay()):
+	}
+	if !s.probeNames() {
+		s.log().Errorf("failed to defend %s", s.entry().ServiceInstanceName())
+		return
+	}
+	for i := 0; i < multicastRepetitions; i++ {
+		if i > 0 {
+			select {
+			case <-s.shouldShutdown:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		s.announce()
+	}
+}
+
+// conflictDelay records a conflict and returns the time to wait before
+// probing again: after 15 conflicts within ten seconds, the server waits five
+// seconds (RFC6762 section 9).
+func (s *Server) conflictDelay() time.Duration {
+	s.probeLock.Lock()
+	defer s.probeLock.Unlock()
+	now := time.Now()
+	conflicts := s.conflicts[:0]
+	for _, t := range s.conflicts {
+		if now.Sub(t) < 10*time.Second {
+			conflicts = append(conflicts, t)
+		}
+	}
+	s.conflicts = append(conflicts, now)
+	if len(s.conflicts) > 15 {
+		return 5 * time.Second
+	}
+	return 0
+}
+
+// handleProbe handles a probe query of another host. While probing itself,
+// the server compares the proposed records with its own (RFC6762 section
+// 8.2); the lexicographically later set wins. It reports whether the query
+// must not be answered.
+func (s *Server) handleProbe(query *dns.Msg) bool {
+	if s.entry() == nil {
+		return true
+	}
+	for _, q := range query.Question {
+		if !s.isClaimedName(q.Name) {
+			continue
+		}
+		var theirs []dns.RR
+		for _, rr := range query.Ns {
+			if strings.EqualFold(rr.Header().Name, q.Name) {
+				theirs = append(theirs, rr)
+			}
+		}
+		var ours []dns.RR
+		for _, rr := range s.claimedRecords() {
+			if strings.EqualFold(rr.Header().Name, q.Name) {
+				ours = append(ours, rr)
+			}
+		}
+		cmp := compareRecordSets(ours, theirs)
+		if cmp == 0 {
+			// Our own probe, looped back
+			return true
+		}
+		if s.isProbing() {
+			if cmp < 0 {
+				s.signalConflict(conflictTiebreak)
+			}
+			return true
+		}
+	}
+	// Established records are defended by answering the probe.
+	return false
+}
+
+// compareRecordSets compares two record sets lexicographically as described
+// in RFC6762 section 8.2.
+func compareRecordSets(a, b []dns.RR) int {
+	a, b = sortedRecords(a), sortedRecords(b)
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareRecords(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// compareRecords compares two records by class (without the cache flush
+// bit), type and rdata.
+func compareRecords(a, b dns.RR) int {
+	ca, cb := a.Header().Class&^qClassCacheFlush, b.Header().Class&^qClassCacheFlush
+	switch {
+	case ca != cb:
+		if ca < cb {
+			return -1
+		}
+		return 1
+	case a.Header().Rrtype != b.Header().Rrtype:
+		if a.Header().Rrtype < b.Header().Rrtype {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(rdata(a), rdata(b))
+}
+
+func sortedRecords(records []dns.RR) []dns.RR {
+	sorted := append([]dns.RR(nil), records...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return compareRecords(sorted[i], sorted[j]) < 0
+	})
+	return sorted
+}
+
+// rdata returns the uncompressed wire format of the record data.
+func rdata(rr dns.RR) []byte {
+	buf := make([]byte, dns.Len(rr)+1)
+	off, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	return buf[off-int(rr.Header().Rdlength) : off]
+}
+
+// containsRecord reports whether the list has a record with the same name,
+// class, type and data as rr.
+func containsRecord(list []dns.RR, rr dns.RR) bool {
+	for _, other := range list {
+		if strings.EqualFold(other.Header().Name, rr.Header().Name) && compareRecords(other, rr) == 0 {
+			return true
+		}
+	}
+	return false
+}
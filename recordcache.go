@@ -0,0 +1,194 @@
+package zeroconf
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Fractions of its TTL after which a record is queried again if it was not
+// refreshed, see RFC 6762 section 5.2. A random variation of up to
+// refreshJitter is added to each of them.
+var refreshPoints = []float64{.80, .85, .90, .95}
+
+const refreshJitter = .02
+
+// ttlKey identifies a record by its name, type and data.
+type ttlKey struct {
+	name   string
+	rrtype uint16
+	data   string
+}
+
+// cachedRecord holds a received record and its lifetime.
+type cachedRecord struct {
+	rr       dns.RR
+	received time.Time
+	expires  time.Time
+	// Pending times to query the record again, in ascending order
+	refresh []time.Time
+}
+
+// recordCache tracks the TTLs of the records received by a lookup, so the
+// delivered entries are queried again before their records expire and
+// removed once they do. The fresh records of delivered entries are sent as
+// known answers with the queries of the lookup.
+type recordCache struct {
+	lock    sync.Mutex
+	records map[ttlKey]*cachedRecord
+	// Names of the delivered service instances, in lower case
+	delivered map[string]bool
+}
+
+func newRecordCache() *recordCache {
+	return &recordCache{
+		records:   make(map[ttlKey]*cachedRecord),
+		delivered: make(map[string]bool),
+	}
+}
+
+// ttlKeyOf returns the key of a record, or false for records not tracked.
+func ttlKeyOf(rr dns.RR) (ttlKey, bool) {
+	k := ttlKey{name: strings.ToLower(rr.Header().Name), rrtype: rr.Header().Rrtype}
+	switch rr := rr.(type) {
+	case *dns.PTR:
+		k.data = strings.ToLower(rr.Ptr)
+	case *dns.SRV:
+		k.data = strings.ToLower(rr.Target) + ":" + strconv.Itoa(int(rr.Port))
+	case *dns.TXT:
+		k.data = strings.Join(rr.Txt, "\x00")
+	case *dns.A:
+		k.data = rr.A.String()
+	case *dns.AAAA:
+		k.data = rr.AAAA.String()
+	default:
+		return k, false
+	}
+	return k, true
+}
+
+// add starts tracking a record received at the given time, or restarts the
+// lifetime of a tracked one. A record with a TTL of zero is dropped.
+func (c *recordCache) add(rr dns.RR, now time.Time) {
+	k, ok := ttlKeyOf(rr)
+	if !ok {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ttl := time.Duration(rr.Header().Ttl) * time.Second
+	if ttl == 0 {
+		delete(c.records, k)
+		return
+	}
+	r := &cachedRecord{rr: rr, received: now, expires: now.Add(ttl)}
+	for _, p := range refreshPoints {
+		r.refresh = append(r.refresh, now.Add(time.Duration((p+rand.Float64()*refreshJitter)*float64(ttl))))
+	}
+	c.records[k] = r
+}
+
+// has reports whether a record with the given name and type is tracked. An
+// empty data matches any record data.
+func (c *recordCache) has(name string, rrtype uint16, data string) bool {
+	name = strings.ToLower(name)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if data != "" {
+		_, ok := c.records[ttlKey{name, rrtype, strings.ToLower(data)}]
+		return ok
+	}
+	for k := range c.records {
+		if k.name == name && k.rrtype == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+// expire drops the records which expired by now and returns them, together
+// with the questions for the records due to be queried again.
+func (c *recordCache) expire(now time.Time) (expired []ttlKey, questions []dns.Question) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	asked := make(map[dns.Question]bool)
+	for k, r := range c.records {
+		if !now.Before(r.expires) {
+			delete(c.records, k)
+			expired = append(expired, k)
+			continue
+		}
+		var due bool
+		for len(r.refresh) > 0 && !now.Before(r.refresh[0]) {
+			r.refresh = r.refresh[1:]
+			due = true
+		}
+		q := dns.Question{Name: k.name, Qtype: k.rrtype, Qclass: dns.ClassINET}
+		if due && !asked[q] {
+			asked[q] = true
+			questions = append(questions, q)
+		}
+	}
+	return expired, questions
+}
+
+// setDelivered records that a service instance was delivered, so its records
+// are sent as known answers.
+func (c *recordCache) setDelivered(instance string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.delivered[strings.ToLower(instance)] = true
+}
+
+// forget drops the records of a service instance, including the pointers to
+// it.
+func (c *recordCache) forget(instance string) {
+	instance = strings.ToLower(instance)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.delivered, instance)
+	for k := range c.records {
+		if k.name == instance || (k.rrtype == dns.TypePTR && k.data == instance) {
+			delete(c.records, k)
+		}
+	}
+}
+
+// knownAnswers returns the records of delivered service instances which
+// answer the questions and are still fresh, with their remaining TTLs. Records
+// past half of their TTL are left out, as RFC 6762 section 7.1 requires.
+func (c *recordCache) knownAnswers(questions []dns.Question, now time.Time) []dns.RR {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var answers []dns.RR
+	for _, q := range questions {
+		name := strings.ToLower(q.Name)
+		for k, r := range c.records {
+			if k.name != name || k.rrtype != q.Qtype {
+				continue
+			}
+			instance := k.name
+			if k.rrtype == dns.TypePTR {
+				instance = k.data
+			}
+			if !c.delivered[instance] {
+				continue
+			}
+			left := r.expires.Sub(now)
+			if left < r.expires.Sub(r.received)/2 {
+				continue
+			}
+			rr := dns.Copy(r.rr)
+			rr.Header().Ttl = uint32(left / time.Second)
+			answers = append(answers, rr)
+		}
+	}
+	return answers
+}
@@ -0,0 +1,293 @@
+package zeroconf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Registrar hosts many service registrations and answers queries for all of
+// them from a single pair of multicast sockets. Each registration is
+// represented by a Server which shares the sockets of the registrar.
+type Registrar struct {
+	ipv4conn Transport
+	ipv6conn Transport
+	ifaces   []net.Interface
+	ttl      uint32
+	opts     []ServerOption
+	// base opened the sockets; its options apply to received packets
+	base *Server
+
+	lock       sync.Mutex
+	services   []*Server
+	isShutdown bool
+
+	shouldShutdown chan struct{}
+	shutdownEnd    sync.WaitGroup
+	workers        *workerPool
+}
+
+// NewRegistrar joins the multicast groups on the given interfaces, or on all
+// multicast interfaces if none are given. The TTL and options apply to every
+// service added.
+func NewRegistrar(ifaces []net.Interface, ttl uint32, opts ...ServerOption) (*Registrar, error) {
+	s := &Server{}
+	for _, o := range opts {
+		if o != nil {
+			o(&s.opts)
+		}
+	}
+	if s.opts.bindDevice != "" {
+		iface, err := net.InterfaceByName(s.opts.bindDevice)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %v", ErrUnknownInterface, s.opts.bindDevice, err)
+		}
+		ifaces = []net.Interface{*iface}
+	}
+	if len(ifaces) == 0 {
+		ifaces = listMulticastInterfaces()
+	}
+	if ttl == 0 {
+		ttl = 4500
+	}
+	r := &Registrar{
+		ifaces:         ifaces,
+		ttl:            ttl,
+		opts:           opts,
+		base:           s,
+		shouldShutdown: make(chan struct{}),
+	}
+	s.setInterfaces(ifaces)
+	if err := s.listen(); err != nil {
+		return nil, err
+	}
+	r.ipv4conn, r.ipv6conn = s.ipv4conn, s.ipv6conn
+
+	if s.opts.workers > 0 {
+		r.workers = startWorkers(s.opts.workers, s.opts.workQueue, r.shouldShutdown, &r.shutdownEnd, func(p packet) {
+			r.handlePacket(p.buf, p.ifIndex, p.from)
+		})
+	}
+	if r.ipv4conn != nil {
+		r.shutdownEnd.Add(1)
+		go r.recv(r.ipv4conn)
+	}
+	if r.ipv6conn != nil {
+		r.shutdownEnd.Add(1)
+		go r.recv(r.ipv6conn)
+	}
+	return r, nil
+}
+
+// AddService registers a service on the registrar's sockets. The entry needs
+// an instance name, a service type and a port; the domain defaults to
+// "local." and the host name to the system's host name. The options are
+// applied after the options of the registrar.
+func (r *Registrar) AddService(entry *ServiceEntry, opts ...ServerOption) (*Server, error) {
+	if err := completeEntry(entry); err != nil {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.isShutdown {
+		return nil, fmt.Errorf("Registrar: %w", ErrShutdown)
+	}
+	for _, other := range r.services {
+		if strings.EqualFold(other.entry().ServiceInstanceName(), entry.ServiceInstanceName()) {
+			return nil, fmt.Errorf("Service %s is already registered", entry.ServiceInstanceName())
+		}
+	}
+
+	var conf serverOpts
+	for _, o := range append(append([]ServerOption(nil), r.opts...), opts...) {
+		if o != nil {
+			o(&conf)
+		}
+	}
+	s := &Server{
+		ipv4conn:       r.ipv4conn,
+		ipv6conn:       r.ipv6conn,
+		shared:         true,
+		ttl:            r.ttl,
+		shouldShutdown: make(chan struct{}),
+		done:           make(chan struct{}),
+		ready:          make(chan struct{}),
+		conflict:       make(chan probeConflict, 1),
+		opts:           conf,
+	}
+	s.setInterfaces(r.ifaces)
+	s.setService(entry)
+	r.services = append(r.services, s)
+	s.start()
+
+	return s, nil
+}
+
+// RemoveService sends the goodbye packets of a service added to the
+// registrar and stops answering for it.
+func (r *Registrar) RemoveService(s *Server) error {
+	r.lock.Lock()
+	pos := -1
+	for i, other := range r.services {
+		if other == s {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		r.lock.Unlock()
+		return fmt.Errorf("Service is not registered")
+	}
+	r.services = append(r.services[:pos:pos], r.services[pos+1:]...)
+	r.lock.Unlock()
+
+	return s.shutdown()
+}
+
+// Services returns the servers of the services currently registered.
+func (r *Registrar) Services() []*Server {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]*Server(nil), r.services...)
+}
+
+// Shutdown removes all services and closes the sockets.
+func (r *Registrar) Shutdown() {
+	r.lock.Lock()
+	if r.isShutdown {
+		r.lock.Unlock()
+		return
+	}
+	r.isShutdown = true
+	services := r.services
+	r.services = nil
+	r.lock.Unlock()
+
+	for _, s := range services {
+		s.shutdown()
+	}
+	close(r.shouldShutdown)
+	if r.ipv4conn != nil {
+		r.ipv4conn.Close()
+	}
+	if r.ipv6conn != nil {
+		r.ipv6conn.Close()
+	}
+	r.shutdownEnd.Wait()
+}
+
+// Err returns a channel which receives the error of a socket which broke, so
+// the registrar no longer receives queries on it. Without WithSocketReopen,
+// the registrar should be shut down and created again. Errors are dropped if
+// they are not received.
+func (r *Registrar) Err() <-chan error {
+	return r.base.Err()
+}
+
+// recv reads packets from a socket and hands queries to all services.
+func (r *Registrar) recv(conn Transport) {
+	defer r.shutdownEnd.Done()
+	bufp := getBuffer()
+	defer putBuffer(bufp)
+	buf := *bufp
+	var backoff time.Duration
+	for {
+		select {
+		case <-r.shouldShutdown:
+			return
+		default:
+		}
+		n, ifIndex, ttl, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if isFatalReadError(err) && !r.reopen(conn, err) {
+				return
+			}
+			// Wait before reading again, so a socket which fails
+			// persistently does not make the loop spin.
+			backoff = nextReadBackoff(backoff)
+			select {
+			case <-r.shouldShutdown:
+				return
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		backoff = 0
+		services := r.Services()
+		for _, s := range services {
+			atomic.AddUint64(&s.stats.bytesIn, uint64(n))
+		}
+		r.capture(services, from, buf[:n])
+		if ifIndex == 0 {
+			ifIndex = r.base.interfaceIndexFor(from)
+		}
+		if r.base.checkSource() && !r.base.isTrustedSource(from, ifIndex, ttl) {
+			continue
+		}
+		if r.workers != nil {
+			if !r.workers.submit(buf[:n], ifIndex, from) {
+				for _, s := range services {
+					atomic.AddUint64(&s.stats.dropped, 1)
+				}
+			}
+			continue
+		}
+		r.handlePacket(buf[:n], ifIndex, from)
+	}
+}
+
+// handlePacket hands a received packet to all services.
+func (r *Registrar) handlePacket(buf []byte, ifIndex int, from net.Addr) {
+	services := r.Services()
+	msg := getMsg()
+	defer putMsg(msg)
+	if err := unpackMsg(msg, buf); err != nil {
+		for _, s := range services {
+			atomic.AddUint64(&s.stats.parseErrors, 1)
+		}
+		return
+	}
+	for _, s := range services {
+		s.handleQuery(msg, ifIndex, from)
+	}
+}
+
+// capture writes a received packet once to each capture writer of the
+// services; services may share a writer.
+func (r *Registrar) capture(services []*Server, from net.Addr, buf []byte) {
+next:
+	for i, s := range services {
+		w := s.opts.capture
+		if w == nil {
+			continue
+		}
+		for _, prev := range services[:i] {
+			if prev.opts.capture == w {
+				continue next
+			}
+		}
+		w.write(from, nil, buf)
+	}
+}
+
+// reopen opens a broken socket again if enabled with WithSocketReopen, and
+// reports the error via Err otherwise. It reports whether reading from the
+// socket may continue; a failed attempt is retried with the next read.
+func (r *Registrar) reopen(conn Transport, err error) bool {
+	select {
+	case <-r.shouldShutdown:
+		return false
+	default:
+	}
+	t, ok := conn.(*reopenTransport)
+	if !ok {
+		r.base.fail(err)
+		return false
+	}
+	return !errors.Is(t.reopen(), net.ErrClosed)
+}
@@ -0,0 +1,43 @@
+package zeroconf
+
+import (
+	"strings"
+	"sync"
+)
+
+// ownServices keeps track of the services registered by this process, so
+// resolvers can recognize them in browse results.
+var ownServices = struct {
+	sync.Mutex
+	servers map[*Server]struct{}
+}{servers: make(map[*Server]struct{})}
+
+func trackOwnService(s *Server) {
+	ownServices.Lock()
+	ownServices.servers[s] = struct{}{}
+	ownServices.Unlock()
+}
+
+func untrackOwnService(s *Server) {
+	ownServices.Lock()
+	delete(ownServices.servers, s)
+	ownServices.Unlock()
+}
+
+// isOwnService reports whether the entry is published by a Server of this
+// process, matched by service instance name and host name.
+func isOwnService(e *ServiceEntry) bool {
+	ownServices.Lock()
+	defer ownServices.Unlock()
+	for s := range ownServices.servers {
+		own := s.entry()
+		if own == nil {
+			continue
+		}
+		if strings.EqualFold(own.ServiceInstanceName(), e.ServiceInstanceName()) &&
+			strings.EqualFold(trimDot(own.HostName), trimDot(e.HostName)) {
+			return true
+		}
+	}
+	return false
+}
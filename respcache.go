@@ -0,0 +1,153 @@
+package zeroconf
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// Time after which cached answers are composed again, so changed
+	// interface addresses are picked up even if they are not monitored
+	responseCacheAge = time.Second
+	// Maximum number of entries of each cache before it is cleared
+	responseCacheSize = 256
+)
+
+// questionKey identifies the answers to a question on an interface.
+type questionKey struct {
+	name    string
+	qtype   uint16
+	ifIndex int
+}
+
+// cachedAnswers holds the records composed for a question.
+type cachedAnswers struct {
+	msg     *dns.Msg
+	browse  bool
+	created time.Time
+}
+
+// cachedPacket holds the wire format of a message. The records are kept so
+// their addresses, which make up the key, are not reused while it is cached.
+type cachedPacket struct {
+	buf     []byte
+	records [][]dns.RR
+	created time.Time
+}
+
+// responseCache holds the answers composed for recent questions and the
+// packed responses built from them, so repeated queries are neither composed
+// nor packed again. Cached records are shared between responses and must not
+// be modified. The cache is cleared whenever the published records change.
+type responseCache struct {
+	lock       sync.Mutex
+	generation uint64
+	answers    map[questionKey]cachedAnswers
+	packets    map[string]cachedPacket
+}
+
+// clear drops all cached answers and packets.
+func (c *responseCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generation++
+	c.answers = nil
+	c.packets = nil
+}
+
+// cachedAnswers returns the answers to a question, composing them if they
+// are not cached. It reports whether the question browses for services, see
+// composeAnswers.
+func (s *Server) cachedAnswers(q dns.Question, ifIndex int) (*dns.Msg, bool) {
+	c := &s.responses
+	key := questionKey{strings.ToLower(q.Name), q.Qtype, ifIndex}
+	now := time.Now()
+	c.lock.Lock()
+	cached, ok := c.answers[key]
+	generation := c.generation
+	c.lock.Unlock()
+	if ok && now.Sub(cached.created) < responseCacheAge {
+		return cached.msg, cached.browse
+	}
+
+	msg := new(dns.Msg)
+	browse := s.composeAnswers(q, msg, s.ttl, ifIndex, false)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// Answers composed while the records changed are not cached.
+	if c.generation == generation {
+		if c.answers == nil || len(c.answers) >= responseCacheSize {
+			c.answers = make(map[questionKey]cachedAnswers)
+		}
+		c.answers[key] = cachedAnswers{msg: msg, browse: browse, created: now}
+	}
+	return msg, browse
+}
+
+// pack packs a message, reusing the wire format of a message with the same
+// header and the same record instances packed recently. The returned buffer
+// must not be modified.
+func (s *Server) pack(msg *dns.Msg) ([]byte, error) {
+	c := &s.responses
+	key := packetKey(msg)
+	now := time.Now()
+	c.lock.Lock()
+	cached, ok := c.packets[key]
+	generation := c.generation
+	c.lock.Unlock()
+	if ok && now.Sub(cached.created) < responseCacheAge {
+		return cached.buf, nil
+	}
+
+	buf, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.generation == generation {
+		if c.packets == nil || len(c.packets) >= responseCacheSize {
+			c.packets = make(map[string]cachedPacket)
+		}
+		c.packets[key] = cachedPacket{
+			buf:     buf,
+			records: [][]dns.RR{msg.Answer, msg.Ns, msg.Extra},
+			created: now,
+		}
+	}
+	return buf, nil
+}
+
+// packetKey identifies a message by its header, its questions and the
+// addresses of its records.
+func packetKey(msg *dns.Msg) string {
+	var flags uint64
+	for i, f := range []bool{msg.Response, msg.Authoritative, msg.Truncated, msg.Compress} {
+		if f {
+			flags |= 1 << i
+		}
+	}
+	b := make([]byte, 0, 64)
+	b = strconv.AppendUint(b, uint64(msg.Id), 16)
+	b = append(b, ' ')
+	b = strconv.AppendUint(b, flags|uint64(msg.Opcode)<<8|uint64(msg.Rcode)<<16, 16)
+	for _, q := range msg.Question {
+		b = append(b, ' ')
+		b = append(b, q.String()...)
+	}
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		b = append(b, '|')
+		for _, rr := range section {
+			b = strconv.AppendUint(b, uint64(reflect.ValueOf(rr).Pointer()), 16)
+			b = append(b, ',')
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,12 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package zeroconf
+
+import "syscall"
+
+// reuseControl returns nil, as socket options are not supported on this
+// platform.
+func reuseControl(reusePort bool) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
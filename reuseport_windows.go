@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package zeroconf
+
+import "syscall"
+
+// reuseControl returns a socket control function which allows other mDNS
+// responders on the host, like the Bonjour service, to bind port 5353 as
+// well. Windows has no SO_REUSEPORT; SO_REUSEADDR covers it.
+func reuseControl(reusePort bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
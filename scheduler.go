@@ -0,0 +1,49 @@
+package zeroconf
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Range of the random delay of responses with shared records (RFC6762
+// section 6)
+const (
+	sharedResponseMinDelay = 20
+	sharedResponseMaxDelay = 120
+)
+
+// isSharedResponse reports whether a response answers with shared records
+// only, like the PTR records of browsing, which other responders may answer
+// too.
+func isSharedResponse(resp *dns.Msg) bool {
+	for _, rr := range resp.Answer {
+		if rr.Header().Class&qClassCacheFlush != 0 || rr.Header().Rrtype != dns.TypePTR {
+			return false
+		}
+	}
+	return len(resp.Answer) > 0
+}
+
+// scheduleResponse multicasts a response after a random delay of 20-120ms,
+// so the responses of several responders to the same query are spread out.
+// Answers another responder sends in the meantime are not sent again, and
+// responses still pending at shutdown are dropped.
+func (s *Server) scheduleResponse(resp *dns.Msg, ifIndex int) {
+	delay := sharedResponseMinDelay + rand.Intn(sharedResponseMaxDelay-sharedResponseMinDelay+1)
+	time.AfterFunc(time.Duration(delay)*time.Millisecond, func() {
+		select {
+		case <-s.shouldShutdown:
+			return
+		default:
+		}
+		s.suppressDuplicateAnswers(resp, ifIndex)
+		if len(resp.Answer) == 0 {
+			return
+		}
+		if err := s.multicastResponse(resp, ifIndex); err != nil {
+			s.log().Debugf("failed to send response: %v", err)
+		}
+	})
+}
@@ -1,18 +1,17 @@
 package zeroconf
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"math/rand"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"golang.org/x/net/ipv4"
-	"golang.org/x/net/ipv6"
-
 	"errors"
 
 	"github.com/miekg/dns"
@@ -21,176 +20,1260 @@ import (
 const (
 	// Number of Multicast responses sent for a query message (default: 1 < x < 9)
 	multicastRepetitions = 2
-	// Recommended TTL for records containing hostnames (SRV, A, AAAA)
+	// Number of probes sent before announcing, and the interval between them
+	// (RFC6762 section 8.1)
+	probeRepetitions = 3
+	probeInterval    = 250 * time.Millisecond
+	// Recommended TTL for records containing hostnames (SRV, A, AAAA), the
+	// default host TTL
 	transientRecordTTL = 120
+	// Number of consecutive read errors after which the multicast groups are
+	// joined again
+	rejoinAfterErrors = 16
+	// Bounds of the wait after a failed read, doubled with every error
+	readBackoffMin = 10 * time.Millisecond
+	readBackoffMax = time.Second
+	// Time after sending a record during which receiving it is taken as our
+	// own packet looped back rather than as a conflict
+	loopbackWindow = 5 * time.Second
 )
 
+type serverOpts struct {
+	onEvent        ServerEventHandler
+	reannounce     float64
+	reannounceIval time.Duration
+	monitorIval    time.Duration
+	rejoinInterval time.Duration
+	wakeInterval   time.Duration
+	lazyStart      time.Duration
+	suppressRecent bool
+	llmnr          bool
+	ips            []net.IP
+	externalPort   int
+	noTemporary    bool
+	noLoopback     bool
+	noSourceCheck  bool
+	noReusePort    bool
+	noIPv4         bool
+	noIPv6         bool
+	reopen         bool
+	workers        int
+	workQueue      int
+	capture        *PcapWriter
+	textDebounce   time.Duration
+
+	transport4, transport6 Transport
+	customTransport        bool
+	group                  multicastGroup
+	bindDevice             string
+	logger                 Logger
+
+	browseDomains   []string
+	registerDomains []string
+
+	variants map[string]ifaceVariant
+
+	onRename   func(old, new string)
+	onConflict func(rr dns.RR)
+	noRename   bool
+	subtypes   []string
+	reverse    bool
+	hostTTL    uint32
+}
+
+// ifaceVariant overrides the instance name and TXT record on an interface.
+type ifaceVariant struct {
+	instance string
+	text     []string
+}
+
+// ServerOption fills the option struct to configure a Server.
+type ServerOption func(*serverOpts)
+
+// WithEventHandler sets a handler that is notified about lifecycle events of
+// the server, e.g. when the service has been announced.
+func WithEventHandler(h ServerEventHandler) ServerOption {
+	return func(o *serverOpts) {
+		o.onEvent = h
+	}
+}
+
+// WithReannounce enables periodic re-announcement of the service records after
+// the initial announcements. The records are announced again every time the
+// given fraction (0 < fraction < 1) of the host record TTL has passed, e.g. 0.5
+// re-announces at half of the TTL.
+func WithReannounce(fraction float64) ServerOption {
+	return func(o *serverOpts) {
+		if fraction > 0 && fraction < 1 {
+			o.reannounce = fraction
+		}
+	}
+}
+
+// WithReannounceInterval enables periodic re-announcement of the service
+// records at a fixed interval of at least one second. Records already
+// multicast within the last second, e.g. in response to a query, are left out.
+func WithReannounceInterval(interval time.Duration) ServerOption {
+	return func(o *serverOpts) {
+		if interval > 0 {
+			if interval < time.Second {
+				interval = time.Second
+			}
+			o.reannounceIval = interval
+		}
+	}
+}
+
+// WithInterfaceMonitor makes the server check the network interfaces for
+// changes at the given interval. If no interfaces were given to Register,
+// interfaces appearing later, e.g. of a VPN, are used as well and vanished
+// ones are dropped. After address changes, the multicast groups are joined
+// again and the records are announced with the new addresses.
+func WithInterfaceMonitor(interval time.Duration) ServerOption {
+	return func(o *serverOpts) {
+		o.monitorIval = interval
+	}
+}
+
+// WithoutMulticastLoopback disables the loopback of multicast packets the
+// server sends, so it does not receive its own announcements. Note that
+// clients on the same host do not see the service then either. By default,
+// loopback is enabled regardless of the OS default.
+func WithoutMulticastLoopback() ServerOption {
+	return func(o *serverOpts) {
+		o.noLoopback = true
+	}
+}
+
+// WithoutSourceCheck disables the checks of received packets against off-link
+// spoofing: by default, packets are ignored unless their source address is on
+// the link they were received on, and packets from port 5353 are ignored
+// unless they were sent with an IP TTL of 255 (RFC6762 section 11).
+func WithoutSourceCheck() ServerOption {
+	return func(o *serverOpts) {
+		o.noSourceCheck = true
+	}
+}
+
+// WithoutReusePort binds the mDNS port without SO_REUSEPORT. By default, the
+// port is bound with SO_REUSEADDR and SO_REUSEPORT, so the server coexists with
+// avahi-daemon or mDNSResponder on the same host. Without SO_REUSEPORT,
+// binding fails on systems where another responder requires it.
+func WithoutReusePort() ServerOption {
+	return func(o *serverOpts) {
+		o.noReusePort = true
+	}
+}
+
+// WithSocketReopen makes the server open its sockets again when they break,
+// for example after the network stack was reset, instead of giving up
+// receiving on them. Reopening is retried with a backoff until it succeeds or
+// the server is shut down. It has no effect with WithTransport.
+func WithSocketReopen() ServerOption {
+	return func(o *serverOpts) {
+		o.reopen = true
+	}
+}
+
+// WithWorkerPool makes the server handle received queries on the given number
+// of goroutines instead of on the goroutine reading the socket, so a slow
+// send does not hold up reading. Up to queueSize packets wait for a worker;
+// further packets are dropped and counted in Stats. If queueSize is 0, it
+// defaults to 16 packets per worker.
+func WithWorkerPool(workers, queueSize int) ServerOption {
+	return func(o *serverOpts) {
+		o.workers, o.workQueue = workers, queueSize
+	}
+}
+
+// WithIPv4Disabled makes the server skip the IPv4 socket entirely, so it
+// neither receives nor sends IPv4 packets. The IPv4 addresses of the host are
+// still published.
+func WithIPv4Disabled() ServerOption {
+	return func(o *serverOpts) {
+		o.noIPv4 = true
+	}
+}
+
+// WithIPv6Disabled makes the server skip the IPv6 socket entirely, e.g. in
+// environments with broken IPv6 multicast. The IPv6 addresses of the host are
+// still published.
+func WithIPv6Disabled() ServerOption {
+	return func(o *serverOpts) {
+		o.noIPv6 = true
+	}
+}
+
+// WithMulticastGroup makes the server use other multicast groups and another
+// port than 224.0.0.251, ff02::fb and 5353, e.g. to run tests on an isolated
+// mDNS network. A nil address keeps the default group of its family. Clients
+// need the same setting via SelectMulticastGroup.
+func WithMulticastGroup(ipv4, ipv6 net.IP, port int) ServerOption {
+	return func(o *serverOpts) {
+		o.group = newMulticastGroup(ipv4, ipv6, port)
+	}
+}
+
+// WithBindInterface restricts the server to the named interface, e.g. "eth0":
+// the multicast groups are joined on it only, and the sockets are bound to it
+// on Linux and macOS. It overrides the interfaces passed to Register.
+func WithBindInterface(name string) ServerOption {
+	return func(o *serverOpts) {
+		o.bindDevice = name
+	}
+}
+
+// WithRejoinInterval makes the server leave and re-join its multicast groups
+// at the given interval. Joins are also refreshed automatically after
+// persistent receive errors; this option additionally covers memberships that
+// are lost without any error, e.g. after suspend/resume.
+func WithRejoinInterval(interval time.Duration) ServerOption {
+	return func(o *serverOpts) {
+		o.rejoinInterval = interval
+	}
+}
+
+// WithWakeDetection makes the server check for system sleep at the given
+// interval. Sleep is detected by the wall clock moving further than expected
+// between two checks. After wake-up, the multicast groups are joined again and
+// the service is probed and announced again, as peers have likely expired our
+// records in the meantime. A Woke event is reported to the event handler.
+func WithWakeDetection(interval time.Duration) ServerOption {
+	return func(o *serverOpts) {
+		o.wakeInterval = interval
+	}
+}
+
+// WithLazyStart lets registration succeed even if no interface with a usable
+// address exists yet, e.g. before DHCP completed. The server then starts in a
+// pending state, checks for interfaces at the given interval and completes
+// the registration once they appeared.
+func WithLazyStart(pollInterval time.Duration) ServerOption {
+	return func(o *serverOpts) {
+		o.lazyStart = pollInterval
+	}
+}
+
+// WithRecentAnswerSuppression makes the server skip answers to multicast
+// questions for records it multicast on the same interface within the last
+// quarter of their TTL, which cuts redundant traffic on chatty networks.
+func WithRecentAnswerSuppression() ServerOption {
+	return func(o *serverOpts) {
+		o.suppressRecent = true
+	}
+}
+
+// WithLLMNR additionally answers LLMNR (RFC 4795) queries for the single-label
+// host name of the service, so hosts without mDNS can resolve it by name.
+func WithLLMNR() ServerOption {
+	return func(o *serverOpts) {
+		o.llmnr = true
+	}
+}
+
+// WithIPs sets the addresses published for the host of the service instead of
+// the addresses of the server's interfaces. This is mostly useful for
+// RegisterProxy.
+func WithIPs(ips ...net.IP) ServerOption {
+	return func(o *serverOpts) {
+		o.ips = ips
+	}
+}
+
+// WithoutTemporaryAddrs excludes RFC 4941 temporary IPv6 addresses from the
+// published address records, unless an interface has no stable IPv6 address.
+// By default, they are only listed after the stable addresses, so the records
+// don't rotate with the privacy addresses. Temporary addresses are detected
+// on Linux only.
+func WithoutTemporaryAddrs() ServerOption {
+	return func(o *serverOpts) {
+		o.noTemporary = true
+	}
+}
+
+// WithExternalAddress advertises the given port and addresses instead of the
+// registered port and the interface addresses. Use it for services in
+// containers or behind port mappings, where the local socket is not reachable
+// from the network as is.
+func WithExternalAddress(port int, ips ...net.IP) ServerOption {
+	return func(o *serverOpts) {
+		o.externalPort = port
+		if len(ips) > 0 {
+			o.ips = ips
+		}
+	}
+}
+
+// WithCapture writes all mDNS packets sent and received by the server to the
+// given pcap writer.
+func WithCapture(pw *PcapWriter) ServerOption {
+	return func(o *serverOpts) {
+		o.capture = pw
+	}
+}
+
+// WithTextDebounce coalesces rapid SetText calls into a single announcement,
+// which is sent once no further update happened for the given quiet period.
+// Queries are always answered with the latest TXT record.
+func WithTextDebounce(quiet time.Duration) ServerOption {
+	return func(o *serverOpts) {
+		o.textDebounce = quiet
+	}
+}
+
+// WithInterfaceVariant advertises the service with a different instance name
+// and TXT record on the named interface, e.g. "NAS (IoT)" on the IoT VLAN.
+// An empty instance keeps the registered name, a nil text the registered TXT
+// record. The variants are probed, announced and unregistered per interface as
+// part of the same registration.
+func WithInterfaceVariant(iface, instance string, text []string) ServerOption {
+	return func(o *serverOpts) {
+		if o.variants == nil {
+			o.variants = make(map[string]ifaceVariant)
+		}
+		o.variants[iface] = ifaceVariant{instance: instance, text: text}
+	}
+}
+
+// WithSubtypes registers the service under the given subtypes (e.g.
+// "_printer") in addition to its base type, see RFC6763 section 7.1.
+func WithSubtypes(subtypes ...string) ServerOption {
+	return func(o *serverOpts) {
+		o.subtypes = subtypes
+	}
+}
+
+// WithReverseRecords publishes the reverse PTR records (in-addr.arpa and
+// ip6.arpa) of the host's addresses, mapping them to the host name, so
+// reverse lookups of the addresses resolve.
+func WithReverseRecords() ServerOption {
+	return func(o *serverOpts) {
+		o.reverse = true
+	}
+}
+
+// WithHostTTL sets the TTL of the records tied to the host (SRV, A, AAAA,
+// reverse PTR), which is 120 seconds by default, or the TTL given to Register
+// if that is shorter. The TTL given to Register applies to the PTR and TXT
+// records; the host TTL may be longer or shorter. A short host TTL lets peers
+// notice a failover quickly.
+func WithHostTTL(ttl uint32) ServerOption {
+	return func(o *serverOpts) {
+		o.hostTTL = ttl
+	}
+}
+
+// WithRenameHandler sets a function which is called when the service was
+// renamed after a name conflict, with the old and the new instance name.
+func WithRenameHandler(h func(old, new string)) ServerOption {
+	return func(o *serverOpts) {
+		o.onRename = h
+	}
+}
+
+// WithConflictHandler sets a function which is called when another responder
+// claims one of the unique names of the announced service with a different
+// record. The server probes the names again then, and either defends them or
+// renames the service.
+func WithConflictHandler(h func(rr dns.RR)) ServerOption {
+	return func(o *serverOpts) {
+		o.onConflict = h
+	}
+}
+
+// WithoutRename disables the automatic renaming on name conflicts. The
+// service is not announced then if its name is already taken.
+func WithoutRename() ServerOption {
+	return func(o *serverOpts) {
+		o.noRename = true
+	}
+}
+
 // Register a service by given arguments. This call will take the system's hostname
 // and lookup IP by that hostname.
-func Register(instance, service, domain string, port int, text []string, ifaces []net.Interface, ttl uint32) (*Server, error) {
+func Register(instance, service, domain string, port int, text []string, ifaces []net.Interface, ttl uint32, opts ...ServerOption) (*Server, error) {
 	entry := NewServiceEntry(instance, service, domain)
 	entry.Port = port
 	entry.Text = text
 
+	if err := completeEntry(entry); err != nil {
+		return nil, err
+	}
+
+	s, err := newServer(ifaces, ttl, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.setService(entry)
+	s.start()
+
+	return s, nil
+}
+
+// completeEntry validates a service entry to register and fills in the
+// default domain and the system's host name.
+func completeEntry(entry *ServiceEntry) error {
 	if entry.Instance == "" {
-		return nil, fmt.Errorf("Missing service instance name")
+		return ErrMissingInstance
 	}
 	if entry.Service == "" {
-		return nil, fmt.Errorf("Missing service name")
+		return ErrMissingService
 	}
 	if entry.Domain == "" {
 		entry.Domain = "local."
 	}
 	if entry.Port == 0 {
-		return nil, fmt.Errorf("Missing port")
+		return ErrMissingPort
 	}
 
 	var err error
 	if entry.HostName == "" {
 		entry.HostName, err = os.Hostname()
 		if err != nil {
-			return nil, fmt.Errorf("Could not determine host")
+			return fmt.Errorf("%w: %v", ErrMissingHost, err)
 		}
 	}
 
 	if !strings.HasSuffix(trimDot(entry.HostName), trimDot(entry.Domain)) {
 		entry.HostName = fmt.Sprintf("%s.%s.", trimDot(entry.HostName), trimDot(entry.Domain))
 	}
+	return nil
+}
 
-	if len(ifaces) == 0 {
-		ifaces = listMulticastInterfaces()
-	}
-
-	s, err := newServer(ifaces, ttl)
+// RegisterContext registers a service like Register, tying its lifetime to
+// the context: once the context is done, the server is shut down, sending the
+// goodbye packets. Use Done to wait for the teardown to complete.
+func RegisterContext(ctx context.Context, instance, service, domain string, port int, text []string, ifaces []net.Interface, ttl uint32, opts ...ServerOption) (*Server, error) {
+	s, err := Register(instance, service, domain, port, text, ifaces, ttl, opts...)
 	if err != nil {
 		return nil, err
 	}
-
-	s.service = entry
-	go s.mainloop()
-	go s.probe()
-
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Shutdown()
+		case <-s.shouldShutdown:
+		}
+	}()
 	return s, nil
 }
 
 // RegisterProxy registers a service proxy. This call will skip the hostname/IP lookup and
 // will use the provided values.
-func RegisterProxy(instance, service, domain string, port int, host string, text []string, ifaces []net.Interface, ttl uint32) (*Server, error) {
+func RegisterProxy(instance, service, domain string, port int, host string, text []string, ifaces []net.Interface, ttl uint32, opts ...ServerOption) (*Server, error) {
 	entry := NewServiceEntry(instance, service, domain)
 	entry.Port = port
 	entry.Text = text
 	entry.HostName = host
 
 	if entry.Instance == "" {
-		return nil, fmt.Errorf("Missing service instance name")
+		return nil, ErrMissingInstance
 	}
 	if entry.Service == "" {
-		return nil, fmt.Errorf("Missing service name")
+		return nil, ErrMissingService
 	}
 	if entry.HostName == "" {
-		return nil, fmt.Errorf("Missing host name")
+		return nil, ErrMissingHost
 	}
 	if entry.Domain == "" {
 		entry.Domain = "local"
 	}
 	if entry.Port == 0 {
-		return nil, fmt.Errorf("Missing port")
+		return nil, ErrMissingPort
 	}
 
 	if !strings.HasSuffix(trimDot(entry.HostName), trimDot(entry.Domain)) {
 		entry.HostName = fmt.Sprintf("%s.%s.", trimDot(entry.HostName), trimDot(entry.Domain))
 	}
 
-	if len(ifaces) == 0 {
-		ifaces = listMulticastInterfaces()
+	s, err := newServer(ifaces, ttl, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.setService(entry)
+	s.start()
+
+	return s, nil
+}
+
+const (
+	qClassCacheFlush uint16 = 1 << 15
+	// The same bit in the class of a question asks for a unicast response
+	qClassUnicastResponse = qClassCacheFlush
+)
+
+// Server structure encapsulates both IPv4/IPv6 UDP connections
+type Server struct {
+	// Traffic counters, updated atomically
+	stats serverStats
+
+	service  atomic.Value // *ServiceEntry
+	ipv4conn Transport
+	ipv6conn Transport
+	ifaces   atomic.Value // []net.Interface
+
+	// autoIfaces is set if the interfaces were not given explicitly; guarded
+	// by shutdownLock once the server is started
+	autoIfaces bool
+	// pending is set while a lazily started server waits for interfaces;
+	// guarded by shutdownLock
+	pending bool
+	// hostOnly is set if only the host name is published, without service
+	hostOnly bool
+	// shared is set if the sockets are owned and read by a Registrar
+	shared bool
+
+	shouldShutdown chan struct{}
+	shutdownLock   sync.Mutex
+	shutdownEnd    sync.WaitGroup
+	isShutdown     bool
+	done           chan struct{}
+	ttl            uint32
+
+	ready     chan struct{}
+	readyOnce sync.Once
+	readyErr  error
+
+	probeLock sync.Mutex
+	probing   bool
+	// probingHost is set while probing for a new host name
+	probingHost bool
+	defending   bool
+	conflicts   []time.Time
+	conflict    chan probeConflict
+	// Queries with the TC bit set, waiting for more known answers, by querier
+	truncated     map[string]*truncatedQuery
+	truncatedLock sync.Mutex
+
+	opts           serverOpts
+	llmnr          *llmnrResponder
+	workers        *workerPool
+	history        recordHistory
+	temporary      temporaryAddrCache
+	seen           recordHistory
+	reannounceOnce sync.Once
+	rejoinLock     sync.Mutex
+	errOnce        sync.Once
+	errs           chan error
+	lost           map[int]bool
+	lostLock       sync.Mutex
+
+	// Observer hooks
+	hooksLock  sync.RWMutex
+	onQuery    func(q dns.Question, from net.Addr) bool
+	onResponse func(resp *dns.Msg, to net.Addr)
+
+	serviceLock          sync.Mutex
+	responses            responseCache
+	txt                  atomic.Value // []string
+	textLock             sync.Mutex
+	textTimer            *time.Timer
+	lastTextAnnouncement time.Time
+}
+
+// Constructs server structure
+func newServer(ifaces []net.Interface, ttl uint32, opts []ServerOption) (*Server, error) {
+	var conf serverOpts
+	for _, o := range opts {
+		if o != nil {
+			o(&conf)
+		}
+	}
+
+	if ttl == 0 {
+		ttl = 4500
+	}
+	s := &Server{
+		autoIfaces:     len(ifaces) == 0,
+		ttl:            ttl,
+		shouldShutdown: make(chan struct{}),
+		done:           make(chan struct{}),
+		ready:          make(chan struct{}),
+		conflict:       make(chan probeConflict, 1),
+		opts:           conf,
+	}
+	s.setInterfaces(ifaces)
+	if conf.bindDevice != "" {
+		iface, err := net.InterfaceByName(conf.bindDevice)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %v", ErrUnknownInterface, conf.bindDevice, err)
+		}
+		s.setInterfaces([]net.Interface{*iface})
+		s.autoIfaces = false
+	}
+	if s.autoIfaces {
+		s.setInterfaces(listMulticastInterfaces())
+	}
+
+	if conf.lazyStart > 0 && !hasUsableAddrs(s.interfaces()) {
+		s.pending = true
+		return s, nil
+	}
+	if err := s.listen(); err != nil {
+		if conf.lazyStart == 0 {
+			return nil, err
+		}
+		s.pending = true
 	}
 
-	s, err := newServer(ifaces, ttl)
+	return s, nil
+}
+
+// listen opens the multicast connections on the server's interfaces.
+func (s *Server) listen() error {
+	if s.opts.customTransport {
+		if s.opts.transport4 == nil && s.opts.transport6 == nil {
+			return fmt.Errorf("No transport")
+		}
+		s.ipv4conn, s.ipv6conn = s.opts.transport4, s.opts.transport6
+		return nil
+	}
+
+	var (
+		ipv4conn, ipv6conn Transport
+		err4, err6         error
+	)
+	if !s.opts.noIPv4 {
+		ipv4conn, err4 = s.openIPv4()
+		if err4 != nil {
+			s.log().Infof("no suitable IPv4 interface: %v", err4)
+		}
+	}
+	if !s.opts.noIPv6 {
+		ipv6conn, err6 = s.openIPv6()
+		if err6 != nil {
+			s.log().Infof("no suitable IPv6 interface: %v", err6)
+		}
+	}
+	if ipv4conn == nil && ipv6conn == nil {
+		// No supported interface left. A socket that could not be bound
+		// is reported as such rather than as a missing interface.
+		for _, err := range []error{err4, err6} {
+			if errors.Is(err, ErrSocketBind) {
+				return err
+			}
+		}
+		return ErrNoInterface
+	}
+	s.ipv4conn = s.reopenable(ipv4conn, s.openIPv4)
+	s.ipv6conn = s.reopenable(ipv6conn, s.openIPv6)
+	return nil
+}
+
+// openIPv4 opens the IPv4 socket and joins the multicast group on the
+// server's interfaces.
+func (s *Server) openIPv4() (Transport, error) {
+	conn, err := joinUdp4Multicast(s.interfaces(), s.opts.group, s.socketOpts())
 	if err != nil {
 		return nil, err
 	}
+	if s.opts.noLoopback {
+		conn.SetMulticastLoopback(false)
+	}
+	return ipv4Transport{conn}, nil
+}
+
+// openIPv6 opens the IPv6 socket and joins the multicast group on the
+// server's interfaces.
+func (s *Server) openIPv6() (Transport, error) {
+	conn, err := joinUdp6Multicast(s.interfaces(), s.opts.group, s.socketOpts())
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.noLoopback {
+		conn.SetMulticastLoopback(false)
+	}
+	return ipv6Transport{conn}, nil
+}
+
+// reopenable wraps the transport of a socket so it can be opened again, if
+// enabled with WithSocketReopen.
+func (s *Server) reopenable(conn Transport, open func() (Transport, error)) Transport {
+	if conn == nil || !s.opts.reopen {
+		return conn
+	}
+	return &reopenTransport{conn: conn, open: open}
+}
+
+// hasUsableAddrs reports whether any of the interfaces has an address to
+// publish.
+func hasUsableAddrs(ifaces []net.Interface) bool {
+	for i := range ifaces {
+		v4, v6 := addrsForInterface(&ifaces[i])
+		if len(v4) > 0 || len(v6) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// setService sets the published service and applies the configured addresses.
+func (s *Server) setService(entry *ServiceEntry) {
+	for _, ip := range s.opts.ips {
+		if ip.To4() != nil {
+			entry.AddrIPv4 = append(entry.AddrIPv4, ip)
+		} else {
+			entry.AddrIPv6 = append(entry.AddrIPv6, ip)
+		}
+	}
+	if s.opts.externalPort > 0 {
+		entry.Port = s.opts.externalPort
+	}
+	entry.Subtypes = append(entry.Subtypes, s.opts.subtypes...)
+	s.txt.Store(append([]string(nil), entry.Text...))
+	s.service.Store(entry)
+	s.responses.clear()
+}
+
+// entry returns the service entry currently published. The entry must not be
+// modified: it is replaced as a whole by updateEntry. Functions composing
+// records load it once, so the records they build are consistent with each
+// other even if the entry is replaced concurrently.
+func (s *Server) entry() *ServiceEntry {
+	e, _ := s.service.Load().(*ServiceEntry)
+	return e
+}
+
+// updateEntry applies the change to a copy of the service entry and publishes
+// the copy. It returns the new entry.
+func (s *Server) updateEntry(change func(e *ServiceEntry)) *ServiceEntry {
+	s.serviceLock.Lock()
+	defer s.serviceLock.Unlock()
+	e := *s.entry()
+	change(&e)
+	s.service.Store(&e)
+	s.responses.clear()
+	return &e
+}
+
+// text returns the TXT record strings currently served.
+func (s *Server) text() []string {
+	text, _ := s.txt.Load().([]string)
+	return text
+}
+
+// interfaces returns the interfaces the server answers on. The list is
+// replaced as a whole when interfaces are added or removed, so it may be read
+// concurrently but must not be modified.
+func (s *Server) interfaces() []net.Interface {
+	ifaces, _ := s.ifaces.Load().([]net.Interface)
+	return ifaces
+}
+
+// setInterfaces replaces the interface list. Once the server is started, the
+// caller must hold rejoinLock.
+func (s *Server) setInterfaces(ifaces []net.Interface) {
+	s.ifaces.Store(ifaces)
+}
+
+// entryFor returns the service entry and TXT record advertised on the
+// interface with the given index, applying its variant if any.
+func (s *Server) entryFor(ifIndex int) (*ServiceEntry, []string) {
+	entry := s.entry()
+	if len(s.opts.variants) == 0 || ifIndex == 0 {
+		return entry, s.text()
+	}
+	for _, intf := range s.interfaces() {
+		if intf.Index != ifIndex {
+			continue
+		}
+		v, ok := s.opts.variants[intf.Name]
+		if !ok {
+			break
+		}
+		e := *entry
+		if v.instance != "" {
+			e.setInstance(e.renamed(v.instance))
+		}
+		if v.text != nil {
+			return &e, v.text
+		}
+		return &e, s.text()
+	}
+	return entry, s.text()
+}
+
+// instanceName returns the service instance name advertised on the
+// interface with the given index.
+func (s *Server) instanceName(ifIndex int) string {
+	e, _ := s.entryFor(ifIndex)
+	return e.ServiceInstanceName()
+}
+
+// eachInterface calls fn once with interface index 0 if all interfaces
+// advertise the same records, or once per interface otherwise.
+func (s *Server) eachInterface(fn func(ifIndex int)) {
+	if len(s.opts.variants) == 0 {
+		fn(0)
+		return
+	}
+	for _, intf := range s.interfaces() {
+		fn(intf.Index)
+	}
+}
+
+func (s *Server) Service() *ServiceEntry {
+	return s.entry()
+}
+
+// Subtypes returns the complete names of the subtypes the service is
+// registered with (e.g. _printer._sub._http._tcp.local.). Service type
+// enumeration only reports the base type.
+func (s *Server) Subtypes() []string {
+	return s.entry().SubtypeNames()
+}
+
+// Records returns the records the server currently advertises, as sent in
+// its announcements, with the current names and TTLs. Records that differ
+// per interface, like address records, are included for every interface.
+func (s *Server) Records() []dns.RR {
+	var records []dns.RR
+	seen := make(map[string]bool)
+	for _, intf := range s.interfaces() {
+		for _, rr := range s.InterfaceRecords(intf.Index) {
+			if key := rr.String(); !seen[key] {
+				seen[key] = true
+				records = append(records, rr)
+			}
+		}
+	}
+	return records
+}
+
+// InterfaceRecords returns the records the server currently advertises on the
+// interface with the given index.
+func (s *Server) InterfaceRecords(ifIndex int) []dns.RR {
+	resp := s.composeAnnouncement(ifIndex)
+	return append(resp.Answer, resp.Extra...)
+}
+
+func (s *Server) Probe() {
+	s.probe()
+}
+
+// Ready returns a channel that is closed once probing and the initial
+// announcements have completed. After that, Service reports the name the
+// service is discoverable as. The channel is closed as well if the service
+// cannot be announced, see ReadyErr.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// ReadyErr returns why the service was not announced once the Ready channel
+// is closed: ErrNameConflict if the name is taken and renaming is disabled,
+// or ErrShutdown if the server was shut down first. It returns nil before
+// and after a successful announcement.
+func (s *Server) ReadyErr() error {
+	select {
+	case <-s.ready:
+		return s.readyErr
+	default:
+		return nil
+	}
+}
+
+// setReady closes the Ready channel, reporting err by ReadyErr.
+func (s *Server) setReady(err error) {
+	s.readyOnce.Do(func() {
+		s.readyErr = err
+		close(s.ready)
+	})
+}
+
+// announced reports whether the service was announced successfully.
+func (s *Server) announced() bool {
+	select {
+	case <-s.ready:
+		return s.readyErr == nil
+	default:
+		return false
+	}
+}
+
+// start reports the joined interfaces and begins serving and probing.
+func (s *Server) start() {
+	trackOwnService(s)
+	s.shutdownLock.Lock()
+	defer s.shutdownLock.Unlock()
+	if s.pending {
+		s.shutdownEnd.Add(1)
+		go s.awaitInterfaces()
+		return
+	}
+	s.serve()
+}
+
+// serve reports the joined interfaces and begins serving and probing. The
+// caller must hold shutdownLock, so the routines are not started after the
+// server was shut down.
+func (s *Server) serve() {
+	s.emit(Starting, nil)
+	ifaces := s.interfaces()
+	for i := range ifaces {
+		s.emit(InterfaceJoined, &ifaces[i])
+	}
+	if s.opts.rejoinInterval > 0 {
+		s.shutdownEnd.Add(1)
+		go s.rejoinLoop()
+	}
+	if s.opts.wakeInterval > 0 {
+		s.shutdownEnd.Add(1)
+		go s.wakeLoop()
+	}
+	if s.opts.monitorIval > 0 {
+		s.shutdownEnd.Add(1)
+		go s.monitorLoop()
+	}
+	if s.opts.llmnr {
+		s.startLLMNR()
+	}
+	if !s.shared {
+		s.mainloop()
+	}
+	s.shutdownEnd.Add(1)
+	go func() {
+		defer s.shutdownEnd.Done()
+		s.probe()
+	}()
+}
+
+// awaitInterfaces polls for usable interfaces of a pending server and
+// completes the registration as soon as they appeared.
+func (s *Server) awaitInterfaces() {
+	defer s.shutdownEnd.Done()
+
+	ticker := time.NewTicker(s.opts.lazyStart)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shouldShutdown:
+			return
+		case <-ticker.C:
+		}
+		if s.tryServe() {
+			return
+		}
+	}
+}
+
+// tryServe opens the sockets of a pending server and starts it if usable
+// interfaces appeared. It reports whether waiting for interfaces is over.
+func (s *Server) tryServe() bool {
+	s.shutdownLock.Lock()
+	defer s.shutdownLock.Unlock()
+	if s.isShutdown {
+		return true
+	}
+	ifaces := s.interfaces()
+	if s.autoIfaces {
+		ifaces = listMulticastInterfaces()
+	} else {
+		ifaces = refreshInterfaces(ifaces)
+	}
+	if !hasUsableAddrs(ifaces) {
+		return false
+	}
+	s.setInterfaces(ifaces)
+	if err := s.listen(); err != nil {
+		return false
+	}
+	s.pending = false
+	s.serve()
+	return true
+}
+
+// mainloop starts the routines receiving packets. They are added to
+// shutdownEnd before they start, so a shutdown right after the registration
+// waits for them.
+func (s *Server) mainloop() {
+	if s.opts.workers > 0 {
+		s.workers = startWorkers(s.opts.workers, s.opts.workQueue, s.shouldShutdown, &s.shutdownEnd, func(p packet) {
+			s.parsePacket(p.buf, p.ifIndex, p.from)
+		})
+	}
+	if s.ipv4conn != nil {
+		s.shutdownEnd.Add(1)
+		go s.recv(s.ipv4conn)
+	}
+	if s.ipv6conn != nil {
+		s.shutdownEnd.Add(1)
+		go s.recv(s.ipv6conn)
+	}
+}
+
+// rejoin refreshes the multicast group memberships on all interfaces. If
+// announce is set, the service records are announced again afterwards, as
+// peers may have expired them while we could not receive their queries.
+func (s *Server) rejoin(announce bool) {
+	s.rejoinLock.Lock()
+	defer s.rejoinLock.Unlock()
+	select {
+	case <-s.shouldShutdown:
+		return
+	default:
+	}
+
+	if s.ipv4conn != nil {
+		rejoinMulticast(s.ipv4conn, s.group().ipv4.IP, s.interfaces())
+	}
+	if s.ipv6conn != nil {
+		rejoinMulticast(s.ipv6conn, s.group().ipv6.IP, s.interfaces())
+	}
+	if announce && s.track() {
+		go func() {
+			defer s.shutdownEnd.Done()
+			for i := 0; i < multicastRepetitions; i++ {
+				if i > 0 && !s.sleep(time.Second) {
+					return
+				}
+				s.announce()
+			}
+		}()
+	}
+}
+
+// rejoinLoop periodically refreshes the multicast group memberships, which
+// recovers from memberships silently lost after suspend or an interface bounce.
+func (s *Server) rejoinLoop() {
+	defer s.shutdownEnd.Done()
+
+	ticker := time.NewTicker(s.opts.rejoinInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shouldShutdown:
+			return
+		case <-ticker.C:
+			s.rejoin(false)
+		}
+	}
+}
 
-	s.service = entry
-	go s.mainloop()
-	go s.probe()
+// wakeLoop detects system sleep by comparing the elapsed wall clock time with
+// the expected check interval, and probes and announces again after wake-up.
+func (s *Server) wakeLoop() {
+	defer s.shutdownEnd.Done()
 
-	return s, nil
+	interval := s.opts.wakeInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// Round(0) strips the monotonic reading, which may not advance while the
+	// system is suspended.
+	last := time.Now().Round(0)
+	for {
+		select {
+		case <-s.shouldShutdown:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now().Round(0)
+		slept := now.Sub(last) > 2*interval+time.Second
+		last = now
+		if !slept {
+			continue
+		}
+		s.emit(Woke, nil)
+		s.rejoin(false)
+		if s.track() {
+			go func() {
+				defer s.shutdownEnd.Done()
+				s.probe()
+			}()
+		}
+	}
 }
 
-const (
-	qClassCacheFlush uint16 = 1 << 15
-)
+// Shutdown sends the goodbye packets and closes the server. It blocks until
+// the goodbye packets have been sent and the sockets are closed.
+func (s *Server) Shutdown() {
+	s.shutdown()
+}
 
-// Server structure encapsulates both IPv4/IPv6 UDP connections
-type Server struct {
-	service  *ServiceEntry
-	ipv4conn *ipv4.PacketConn
-	ipv6conn *ipv6.PacketConn
-	ifaces   []net.Interface
+// Done returns a channel that is closed once the server is shut down: the
+// goodbye packets have been sent and all sockets and routines are closed.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
 
-	shouldShutdown chan struct{}
-	shutdownLock   sync.Mutex
-	shutdownEnd    sync.WaitGroup
-	isShutdown     bool
-	ttl            uint32
+// Err returns a channel which receives the error of a socket which broke, so
+// the server no longer receives queries on it. Without WithSocketReopen, the
+// server should be shut down and registered again. Errors are dropped if they
+// are not received.
+func (s *Server) Err() <-chan error {
+	return s.errChan()
 }
 
-// Constructs server structure
-func newServer(ifaces []net.Interface, ttl uint32) (*Server, error) {
-	ipv4conn, err4 := joinUdp4Multicast(ifaces)
-	if err4 != nil {
-		log.Printf("[zeroconf] no suitable IPv4 interface: %s", err4.Error())
-	}
-	ipv6conn, err6 := joinUdp6Multicast(ifaces)
-	if err6 != nil {
-		log.Printf("[zeroconf] no suitable IPv6 interface: %s", err6.Error())
-	}
-	if err4 != nil && err6 != nil {
-		// No supported interface left.
-		return nil, fmt.Errorf("No supported interface")
-	}
+func (s *Server) errChan() chan error {
+	s.errOnce.Do(func() { s.errs = make(chan error, 2) })
+	return s.errs
+}
 
-	if ttl == 0 {
-		ttl = 4500
+// fail reports a fatal error of a socket.
+func (s *Server) fail(err error) {
+	s.log().Errorf("failed to receive: %v", err)
+	select {
+	case s.errChan() <- err:
+	default:
 	}
-	s := &Server{
-		ipv4conn:       ipv4conn,
-		ipv6conn:       ipv6conn,
-		ifaces:         ifaces,
-		ttl:            ttl,
-		shouldShutdown: make(chan struct{}),
+}
+
+// SetText updates and announces the TXT records.
+//
+// It is safe to call SetText while queries are answered: the new record is
+// built aside and swapped in atomically before it is announced.
+func (s *Server) SetText(text []string) {
+	text = append([]string(nil), text...)
+	s.updateEntry(func(e *ServiceEntry) {
+		e.Text = text
+		s.txt.Store(text)
+	})
+	if s.opts.textDebounce > 0 {
+		s.scheduleTextAnnouncement()
+		return
 	}
+	s.announceText()
+}
 
-	return s, nil
+// announceText announces the current TXT record.
+func (s *Server) announceText() {
+	s.textLock.Lock()
+	s.lastTextAnnouncement = time.Now()
+	s.textLock.Unlock()
+
+	s.announceChanged(func(ifIndex int) []dns.RR {
+		e, text := s.entryFor(ifIndex)
+		return []dns.RR{
+			&dns.TXT{
+				Hdr: dns.RR_Header{
+					Name:   e.ServiceInstanceName(),
+					Rrtype: dns.TypeTXT,
+					Class:  dns.ClassINET,
+					Ttl:    s.ttl,
+				},
+				Txt: text,
+			},
+		}
+	})
 }
 
-func (s *Server) Service() *ServiceEntry {
-	return s.service
+// scheduleTextAnnouncement defers the TXT announcement until no further
+// update happened for the debounce period. As a record must not be multicast
+// more than once per second, the announcement is never sent earlier than one
+// second after the previous one.
+func (s *Server) scheduleTextAnnouncement() {
+	s.textLock.Lock()
+	defer s.textLock.Unlock()
+
+	delay := s.opts.textDebounce
+	if next := time.Until(s.lastTextAnnouncement.Add(time.Second)); next > delay {
+		delay = next
+	}
+	if s.textTimer == nil {
+		s.textTimer = time.AfterFunc(delay, s.announceText)
+	} else {
+		s.textTimer.Reset(delay)
+	}
 }
 
-func (s *Server) Probe() {
-	s.probe()
+// SetPort updates and announces the SRV record
+func (s *Server) SetPort(port int) {
+	s.updateEntry(func(e *ServiceEntry) { e.Port = port })
+	s.announceChanged(func(ifIndex int) []dns.RR {
+		return []dns.RR{s.srvRecord(ifIndex)}
+	})
 }
 
-// Start listeners and waits for the shutdown signal from exit channel
-func (s *Server) mainloop() {
-	if s.ipv4conn != nil {
-		go s.recv4(s.ipv4conn)
+// SetHostName changes the host name the service points to. The address
+// records of the previous host name are withdrawn, the names are probed again
+// and the SRV and address records of the new host name are announced.
+func (s *Server) SetHostName(hostName string) {
+	old := s.entry()
+	if !strings.HasSuffix(trimDot(hostName), trimDot(old.Domain)) {
+		hostName = fmt.Sprintf("%s.%s.", trimDot(hostName), trimDot(old.Domain))
 	}
-	if s.ipv6conn != nil {
-		go s.recv6(s.ipv6conn)
+	if strings.EqualFold(hostName, old.HostName) {
+		return
 	}
+
+	s.announceChanged(func(ifIndex int) []dns.RR {
+		return s.appendAddrs(nil, 0, ifIndex, false)
+	})
+	s.updateEntry(func(e *ServiceEntry) { e.HostName = hostName })
+	go s.reprobe()
 }
 
-// Shutdown closes all udp connections and unregisters the service
-func (s *Server) Shutdown() {
-	s.shutdown()
+// SetIPs replaces the addresses published for the host and announces the new
+// address records. With the cache flush bit set, records of addresses that
+// are no longer published expire from the caches. If no address of a family
+// is left, goodbye records are sent for the old ones, as no new record of
+// that type flushes them. Without any address, the addresses of the
+// interfaces are published again.
+func (s *Server) SetIPs(ips []net.IP) {
+	old := make(map[int][]dns.RR)
+	s.eachInterface(func(ifIndex int) {
+		old[ifIndex] = s.appendAddrs(nil, 0, ifIndex, false)
+	})
+	s.updateEntry(func(e *ServiceEntry) {
+		e.AddrIPv4, e.AddrIPv6 = nil, nil
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				e.AddrIPv4 = append(e.AddrIPv4, ip)
+			} else {
+				e.AddrIPv6 = append(e.AddrIPv6, ip)
+			}
+		}
+	})
+	s.announceChanged(func(ifIndex int) []dns.RR {
+		records := s.appendAddrs(nil, s.ttl, ifIndex, false)
+		published := make(map[uint16]bool)
+		for _, rr := range records {
+			published[rr.Header().Rrtype] = true
+		}
+		for _, rr := range old[ifIndex] {
+			if !published[rr.Header().Rrtype] {
+				records = append(records, rr)
+			}
+		}
+		return records
+	})
 }
 
-// SetText updates and announces the TXT records
-func (s *Server) SetText(text []string) {
-	s.service.Text = text
-	s.announceText()
+// reprobe probes the names again, including the address records of the new
+// host name, after the host name changed and announces the SRV and address
+// records once the names are confirmed.
+func (s *Server) reprobe() {
+	s.setProbingHost(true)
+	ok := s.probeNames()
+	s.setProbingHost(false)
+	if !ok {
+		return
+	}
+	s.announceChanged(func(ifIndex int) []dns.RR {
+		var records []dns.RR
+		if !s.hostOnly {
+			records = append(records, s.srvRecord(ifIndex))
+		}
+		return s.appendAddrs(records, s.ttl, ifIndex, false)
+	})
+}
+
+// srvRecord builds the SRV record of the service on the given interface.
+func (s *Server) srvRecord(ifIndex int) *dns.SRV {
+	e, _ := s.entryFor(ifIndex)
+	return &dns.SRV{
+		Hdr: dns.RR_Header{
+			Name:   e.ServiceInstanceName(),
+			Rrtype: dns.TypeSRV,
+			Class:  dns.ClassINET,
+			Ttl:    s.hostTTL(s.ttl),
+		},
+		Priority: 0,
+		Weight:   0,
+		Port:     uint16(e.Port),
+		Target:   e.HostName,
+	}
 }
 
 // TTL sets the TTL for DNS replies
@@ -201,146 +1284,390 @@ func (s *Server) TTL(ttl uint32) {
 // Shutdown server will close currently open connections & channel
 func (s *Server) shutdown() error {
 	s.shutdownLock.Lock()
-	defer s.shutdownLock.Unlock()
 	if s.isShutdown {
-		return errors.New("Server is already shutdown")
+		s.shutdownLock.Unlock()
+		<-s.done
+		return fmt.Errorf("Server: %w", ErrShutdown)
 	}
+	s.isShutdown = true
 
+	s.emit(ShuttingDown, nil)
+	untrackOwnService(s)
+	s.textLock.Lock()
+	if s.textTimer != nil {
+		s.textTimer.Stop()
+	}
+	s.textLock.Unlock()
 	err := s.unregister()
 	if err != nil {
-		return err
+		s.log().Errorf("failed to send goodbye: %v", err)
 	}
 
 	close(s.shouldShutdown)
+	s.setReady(ErrShutdown)
 
-	if s.ipv4conn != nil {
+	if s.ipv4conn != nil && !s.shared {
 		s.ipv4conn.Close()
 	}
-	if s.ipv6conn != nil {
+	if s.ipv6conn != nil && !s.shared {
 		s.ipv6conn.Close()
 	}
+	if s.llmnr != nil {
+		s.llmnr.close()
+	}
+	// Routines check isShutdown under the lock before they start, so none is
+	// added while waiting.
+	s.shutdownLock.Unlock()
 
 	// Wait for connection and routines to be closed
 	s.shutdownEnd.Wait()
-	s.isShutdown = true
+	close(s.done)
+	s.emit(Stopped, nil)
 
-	return nil
+	return err
 }
 
 // recv is a long running routine to receive packets from an interface
-func (s *Server) recv4(c *ipv4.PacketConn) {
+func (s *Server) recv(c Transport) {
+	defer s.shutdownEnd.Done()
 	if c == nil {
 		return
 	}
-	buf := make([]byte, 65536)
-	s.shutdownEnd.Add(1)
-	defer s.shutdownEnd.Done()
+	bufp := getBuffer()
+	defer putBuffer(bufp)
+	buf := *bufp
+	var (
+		readErrors int
+		backoff    time.Duration
+	)
 	for {
 		select {
 		case <-s.shouldShutdown:
 			return
 		default:
-			var ifIndex int
-			n, cm, from, err := c.ReadFrom(buf)
+			n, ifIndex, ttl, from, err := c.ReadFrom(buf)
 			if err != nil {
-				// log.Printf("[ERR] zeroconf: failed to read v4: %v", err)
+				if isFatalReadError(err) {
+					if !s.reopenSocket(c, err) {
+						return
+					}
+					continue
+				}
+				s.log().Debugf("failed to read: %v", err)
+				readErrors++
+				if readErrors >= rejoinAfterErrors {
+					readErrors = 0
+					s.rejoin(true)
+				}
+				// Wait before reading again, so a socket which fails
+				// persistently does not make the loop spin.
+				backoff = nextReadBackoff(backoff)
+				if !s.sleep(backoff) {
+					return
+				}
 				continue
 			}
-			if cm != nil {
-				ifIndex = cm.IfIndex
+			readErrors, backoff = 0, 0
+			atomic.AddUint64(&s.stats.bytesIn, uint64(n))
+			s.opts.capture.write(from, nil, buf[:n])
+			if ifIndex == 0 {
+				ifIndex = s.interfaceIndexFor(from)
+			}
+			if s.checkSource() && !s.isTrustedSource(from, ifIndex, ttl) {
+				continue
+			}
+			if s.workers != nil {
+				if !s.workers.submit(buf[:n], ifIndex, from) {
+					atomic.AddUint64(&s.stats.dropped, 1)
+				}
+				continue
 			}
 			if err := s.parsePacket(buf[:n], ifIndex, from); err != nil {
-				//log.Printf("[ERR] zeroconf: failed to handle query v4: %v", err)
+				//log.Printf("[ERR] zeroconf: failed to handle query: %v", err)
 			}
 		}
 	}
 }
 
-// recv is a long running routine to receive packets from an interface
-func (s *Server) recv6(c *ipv6.PacketConn) {
-	if c == nil {
-		return
+// reopenSocket opens a broken socket again if enabled with WithSocketReopen,
+// retrying with a backoff, and reports the error otherwise. It reports whether
+// reading from the transport may continue.
+func (s *Server) reopenSocket(c Transport, err error) bool {
+	select {
+	case <-s.shouldShutdown:
+		// Closed by shutdown
+		return false
+	default:
 	}
-	buf := make([]byte, 65536)
-	s.shutdownEnd.Add(1)
-	defer s.shutdownEnd.Done()
+	t, ok := c.(*reopenTransport)
+	if !ok {
+		s.fail(err)
+		return false
+	}
+	s.log().Infof("reopening socket: %v", err)
+	var backoff time.Duration
 	for {
-		select {
-		case <-s.shouldShutdown:
-			return
-		default:
-			var ifIndex int
-			n, cm, from, err := c.ReadFrom(buf)
-			if err != nil {
-				// log.Printf("[ERR] zeroconf: failed to read v6: %v", err)
-				continue
-			}
-			if cm != nil {
-				ifIndex = cm.IfIndex
-			}
-			if err := s.parsePacket(buf[:n], ifIndex, from); err != nil {
-				//log.Printf("[ERR] zeroconf: failed to handle query v6: %v", err)
-			}
+		err := t.reopen()
+		if err == nil {
+			// Peers may have expired our records while the socket was down
+			s.rejoin(true)
+			return true
 		}
+		if errors.Is(err, net.ErrClosed) {
+			return false
+		}
+		s.log().Debugf("failed to reopen socket: %v", err)
+		backoff = nextReadBackoff(backoff)
+		if !s.sleep(backoff) {
+			return false
+		}
+	}
+}
+
+// sleep waits for the given duration. It reports false if the server is shut
+// down in the meantime.
+func (s *Server) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-s.shouldShutdown:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// track adds a routine about to be started to shutdownEnd, unless the server
+// is shut down. It reports whether the routine may be started; the routine
+// must call shutdownEnd.Done when it returns. The caller must not hold
+// shutdownLock.
+func (s *Server) track() bool {
+	s.shutdownLock.Lock()
+	defer s.shutdownLock.Unlock()
+	if s.isShutdown {
+		return false
 	}
+	s.shutdownEnd.Add(1)
+	return true
 }
 
 // parsePacket is used to parse an incoming packet
 func (s *Server) parsePacket(packet []byte, ifIndex int, from net.Addr) error {
-	var msg dns.Msg
-	if err := msg.Unpack(packet); err != nil {
-		// log.Printf("[ERR] zeroconf: Failed to unpack packet: %v", err)
+	msg := getMsg()
+	defer putMsg(msg)
+	if err := unpackMsg(msg, packet); err != nil {
+		atomic.AddUint64(&s.stats.parseErrors, 1)
 		return err
 	}
-	return s.handleQuery(&msg, ifIndex, from)
+	return s.handleQuery(msg, ifIndex, from)
 }
 
 // handleQuery is used to handle an incoming query
 func (s *Server) handleQuery(query *dns.Msg, ifIndex int, from net.Addr) error {
-	// Ignore questions with authoritative section for now
-	if len(query.Ns) > 0 {
+	if query.Response {
+		s.checkConflict(query, ifIndex)
+		s.seen.markSent(query.Answer, ifIndex, time.Now())
+		return nil
+	}
+	query, complete := s.collectKnownAnswers(query, ifIndex, from)
+	if !complete {
+		return nil
+	}
+	atomic.AddUint64(&s.stats.queries, 1)
+	// Probes are answered only for names we already own
+	if len(query.Ns) > 0 && s.handleProbe(query) {
 		return nil
 	}
 
-	// Handle each question
-	isLegacyUnicast := from.(*net.UDPAddr).Port != 5353
-	var err error
+	// Queries from a source port other than 5353 are legacy unicast queries
+	// (RFC6762 section 6.7). Sources of custom transports which are not UDP
+	// addresses have no port and are treated as mDNS queriers.
+	if addr, ok := from.(*net.UDPAddr); ok && addr.Port != s.group().ipv4.Port {
+		return s.handleLegacyQuery(query, ifIndex, from)
+	}
+
+	// The answers to all questions are aggregated into one unicast response
+	// for the questions requesting one, and one multicast response for the
+	// others.
+	unicast, multicast := newResponse(query), newResponse(query)
+	// The records are merged into the aggregated responses, so the response
+	// to a single question is reused for the next one.
+	resp := newResponse(query)
 	for _, q := range query.Question {
-		resp := dns.Msg{}
-		resp.SetReply(query)
-		resp.Compress = true
-		resp.RecursionDesired = false
-		resp.Authoritative = true
-		resp.Answer = []dns.RR{}
-		resp.Extra = []dns.RR{}
-		if err = s.handleQuestion(q, &resp, query, ifIndex, isLegacyUnicast); err != nil {
-			log.Printf("[ERR] zeroconf: failed to handle question %v: %v", q, err)
+		if !s.acceptQuestion(q, from) {
+			continue
+		}
+		resp.Answer, resp.Ns, resp.Extra = resp.Answer[:0], resp.Ns[:0], resp.Extra[:0]
+		if err := s.handleQuestion(q, resp, query, ifIndex, false); err != nil {
+			s.log().Debugf("failed to handle question %v: %v", q, err)
 			continue
 		}
 		// Check if there is an answer
 		if len(resp.Answer) == 0 {
 			continue
 		}
-		if !isLegacyUnicast {
-			resp.Question = nil // RFC6762 section 6 "responses MUST NOT contain any questions"
+		if isUnicastQuestion(q) {
+			mergeResponse(unicast, resp)
+		} else {
+			mergeResponse(multicast, resp)
 		}
+	}
 
-		if isUnicastQuestion(q) || isLegacyUnicast {
-			// Send unicast
-			if e := s.unicastResponse(&resp, ifIndex, from); e != nil {
-				err = e
-			}
-		} else {
-			// Send mulicast
-			if e := s.multicastResponse(&resp, ifIndex); e != nil {
-				err = e
-			}
+	var err error
+	if len(unicast.Answer) > 0 {
+		// Send unicast
+		if e := s.unicastResponse(unicast, ifIndex, from); e != nil {
+			err = e
+		}
+		// Records which were not multicast within a quarter of their TTL are
+		// multicast as well, so the caches of other hosts stay warm (RFC6762
+		// section 5.4).
+		if e := s.multicastStaleAnswers(unicast, ifIndex); e != nil {
+			err = e
+		}
+	}
+	if len(multicast.Answer) > 0 {
+		// A record is multicast at most once per second on an interface
+		// (RFC6762 section 6), or four times per second to defend it against
+		// a probe.
+		minInterval := time.Second
+		if len(query.Ns) > 0 {
+			minInterval = probeInterval
+		}
+		s.suppressRecentAnswers(multicast, ifIndex, minInterval)
+		if len(query.Ns) == 0 {
+			s.suppressDuplicateAnswers(multicast, ifIndex)
+		}
+		if len(multicast.Answer) == 0 {
+			return err
+		}
+		// Send mulicast. Answers with shared records are delayed, unique ones
+		// are sent immediately.
+		if isSharedResponse(multicast) {
+			s.scheduleResponse(multicast, ifIndex)
+		} else if e := s.multicastResponse(multicast, ifIndex); e != nil {
+			err = e
 		}
 	}
 
 	return err
 }
 
+// newResponse creates an empty authoritative response to the query. As
+// required by RFC6762 section 6, the response contains no questions.
+func newResponse(query *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.Question = nil
+	resp.Compress = true
+	resp.RecursionDesired = false
+	resp.Authoritative = true
+	resp.Answer = []dns.RR{}
+	resp.Extra = []dns.RR{}
+	return resp
+}
+
+// mergeResponse adds the records of resp to the aggregated response, leaving
+// out records it already contains. Additional records which became answers
+// are removed from the additional section.
+func mergeResponse(aggregated, resp *dns.Msg) {
+	// The sections are short, so they are searched rather than indexed.
+	for _, rr := range resp.Answer {
+		if !containsSame(aggregated.Answer, rr) {
+			aggregated.Answer = append(aggregated.Answer, rr)
+		}
+	}
+
+	var extras []dns.RR
+	for _, rr := range append(aggregated.Extra, resp.Extra...) {
+		if !containsSame(aggregated.Answer, rr) && !containsSame(extras, rr) {
+			extras = append(extras, rr)
+		}
+	}
+	aggregated.Extra = extras
+}
+
+// containsSame reports whether the records contain one equal to rr, ignoring
+// the TTL and the cache flush bit.
+func containsSame(records []dns.RR, rr dns.RR) bool {
+	for _, other := range records {
+		if sameRecord(other, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameRecord reports whether two records have the same name, type, class and
+// rdata, ignoring the TTL and the cache flush bit.
+func sameRecord(a, b dns.RR) bool {
+	ha, hb := a.Header(), b.Header()
+	if ha.Rrtype != hb.Rrtype || ha.Class&^qClassCacheFlush != hb.Class&^qClassCacheFlush {
+		return false
+	}
+	if ha.Class != hb.Class {
+		// IsDuplicate compares the class including the cache flush bit
+		return recordKey(a) == recordKey(b)
+	}
+	return dns.IsDuplicate(a, b)
+}
+
+// multicastStaleAnswers multicasts the answers of a unicast response which
+// were not multicast on the interface within the last quarter of their TTL.
+func (s *Server) multicastStaleAnswers(resp *dns.Msg, ifIndex int) error {
+	now := time.Now()
+	var stale []dns.RR
+	for _, rr := range resp.Answer {
+		quarter := time.Duration(rr.Header().Ttl) * time.Second / 4
+		if !s.history.sentWithin(rr, ifIndex, quarter, now) {
+			stale = append(stale, rr)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	mresp := resp.Copy()
+	mresp.Id = 0
+	mresp.Answer = stale
+	return s.multicastResponse(mresp, ifIndex)
+}
+
+// suppressDuplicateAnswers removes answers which another responder multicast
+// on the interface within the last second with a TTL not less than ours
+// (RFC6762 section 7.4).
+func (s *Server) suppressDuplicateAnswers(resp *dns.Msg, ifIndex int) {
+	now := time.Now()
+	answers := resp.Answer[:0]
+	for _, rr := range resp.Answer {
+		if !s.seen.seenWithin(rr, ifIndex, time.Second, rr.Header().Ttl, now) {
+			answers = append(answers, rr)
+		}
+	}
+	atomic.AddUint64(&s.stats.suppressed, uint64(len(resp.Answer)-len(answers)))
+	resp.Answer = answers
+}
+
+// suppressRecentAnswers removes answers which were multicast on the interface
+// within the given minimum interval. With WithRecentAnswerSuppression, answers
+// multicast within the last quarter of their TTL (RFC6762 section 5.4) are
+// removed as well, as the caches of other hosts on the link can be expected
+// to still hold them.
+func (s *Server) suppressRecentAnswers(resp *dns.Msg, ifIndex int, minInterval time.Duration) {
+	now := time.Now()
+	answers := resp.Answer[:0]
+	for _, rr := range resp.Answer {
+		window := minInterval
+		if quarter := time.Duration(rr.Header().Ttl) * time.Second / 4; s.opts.suppressRecent && quarter > window {
+			window = quarter
+		}
+		if !s.history.sentWithin(rr, ifIndex, window, now) {
+			answers = append(answers, rr)
+		}
+	}
+	atomic.AddUint64(&s.stats.suppressed, uint64(len(resp.Answer)-len(answers)))
+	resp.Answer = answers
+}
+
 // RFC6762 7.1. Known-Answer Suppression
 func isKnownAnswer(resp *dns.Msg, query *dns.Msg) bool {
 	if len(resp.Answer) == 0 || len(query.Answer) == 0 {
@@ -358,7 +1685,7 @@ func isKnownAnswer(resp *dns.Msg, query *dns.Msg) bool {
 			continue
 		}
 		ptr := known.(*dns.PTR)
-		if ptr.Ptr == answer.Ptr && hdr.Ttl >= answer.Hdr.Ttl/2 {
+		if strings.EqualFold(ptr.Ptr, answer.Ptr) && hdr.Ttl >= answer.Hdr.Ttl/2 {
 			// log.Printf("skipping known answer: %v", ptr)
 			return true
 		}
@@ -369,77 +1696,215 @@ func isKnownAnswer(resp *dns.Msg, query *dns.Msg) bool {
 
 // handleQuestion is used to handle an incoming question
 func (s *Server) handleQuestion(q dns.Question, resp *dns.Msg, query *dns.Msg, ifIndex int, isLegacyUnicast bool) error {
-	if s.service == nil {
+	if s.entry() == nil {
 		return nil
 	}
-	ttl := s.ttl
+
+	var (
+		answers *dns.Msg
+		browse  bool
+	)
 	if isLegacyUnicast {
-		ttl = 10
+		// Legacy unicast responses are modified in place, so their records
+		// are not shared with the response cache.
+		answers = new(dns.Msg)
+		browse = s.composeAnswers(q, answers, legacyUnicastTTL, ifIndex, true)
+	} else {
+		answers, browse = s.cachedAnswers(q, ifIndex)
+	}
+	if !browse || !isKnownAnswer(answers, query) {
+		resp.Answer = append(resp.Answer, answers.Answer...)
 	}
+	resp.Ns = append(resp.Ns, answers.Ns...)
+	resp.Extra = append(resp.Extra, answers.Extra...)
+	return nil
+}
 
-	switch q.Name {
-	case s.service.ServiceTypeName(): // _services._dns-sd._udp.local.
-		s.serviceTypeName(resp, ttl)
-		if isKnownAnswer(resp, query) {
-			resp.Answer = nil
-		}
+// composeAnswers composes the answers to a question. It reports whether the
+// question browses for services, so known answers are to be suppressed
+// (RFC6762 section 7.1).
+func (s *Server) composeAnswers(q dns.Question, resp *dns.Msg, ttl uint32, ifIndex int, isLegacyUnicast bool) bool {
+	if s.handleDomainQuestion(q, resp, ttl) {
+		return false
+	}
 
-	case s.service.ServiceName(): // _type._tcp.local.
-		s.composeBrowsingAnswers(resp, ttl, ifIndex)
-		if isKnownAnswer(resp, query) {
-			resp.Answer = nil
-		}
+	if s.hostOnly {
+		s.handleHostQuestion(q, resp, ttl, ifIndex)
+		return false
+	}
 
-	case s.service.ServiceInstanceName(): // svc._type._tcp.local.
-		s.composeLookupAnswers(resp, ttl, ifIndex, false, isLegacyUnicast, false)
+	// DNS names are case-insensitive (RFC6762 section 16). The question
+	// itself is echoed unchanged in legacy unicast responses. Only the
+	// record types asked for are answered (RFC6762 section 6).
+	e := s.entry()
+	isPTR := typeMatches(q.Qtype, dns.TypePTR)
+	switch {
+	case !isPTR && (strings.EqualFold(q.Name, e.ServiceTypeName()) ||
+		strings.EqualFold(q.Name, e.ServiceName()) || s.subtypeName(q.Name) != ""):
+		// Only PTR records exist for these names.
 
-	case s.service.HostName: // host.local.
-		resp.Answer = s.appendAddrs(resp.Answer, ttl, ifIndex, false)
+	case strings.EqualFold(q.Name, e.ServiceTypeName()): // _services._dns-sd._udp.local.
+		s.serviceTypeName(resp, e, ttl)
+		return true
+
+	case strings.EqualFold(q.Name, e.ServiceName()): // _type._tcp.local.
+		s.composeBrowsingAnswers(resp, e.ServiceName(), ttl, ifIndex)
+		return true
+
+	case s.subtypeName(q.Name) != "": // _printer._sub._type._tcp.local.
+		s.composeBrowsingAnswers(resp, s.subtypeName(q.Name), ttl, ifIndex)
+		return true
+
+	case strings.EqualFold(q.Name, s.instanceName(ifIndex)): // svc._type._tcp.local.
+		s.composeInstanceAnswers(resp, q.Qtype, ttl, ifIndex, isLegacyUnicast)
+
+	case strings.EqualFold(q.Name, e.HostName): // host.local.
+		s.composeAddrAnswers(resp, q.Qtype, ttl, ifIndex)
+
+	case s.opts.reverse && typeMatches(q.Qtype, dns.TypePTR): // 1.0.168.192.in-addr.arpa.
+		resp.Answer = s.appendReverse(resp.Answer, q.Name, ttl, ifIndex, false)
 	}
 
-	return nil
+	return false
+}
+
+// subtypeName returns the subtype name of the service (RFC6763 section 7.1)
+// matching the given name, or an empty string.
+func (s *Server) subtypeName(name string) string {
+	for _, subtype := range s.entry().SubtypeNames() {
+		if strings.EqualFold(name, subtype) {
+			return subtype
+		}
+	}
+	return ""
 }
 
-func (s *Server) composeBrowsingAnswers(resp *dns.Msg, ttl uint32, ifIndex int) {
+// composeBrowsingAnswers answers a browse for the service type, or one of its
+// subtypes, given by name.
+func (s *Server) composeBrowsingAnswers(resp *dns.Msg, name string, ttl uint32, ifIndex int) {
+	e, text := s.entryFor(ifIndex)
 	ptr := &dns.PTR{
 		Hdr: dns.RR_Header{
-			Name:   s.service.ServiceName(),
+			Name:   name,
 			Rrtype: dns.TypePTR,
 			Class:  dns.ClassINET,
 			Ttl:    ttl,
 		},
-		Ptr: s.service.ServiceInstanceName(),
+		Ptr: e.ServiceInstanceName(),
 	}
 	resp.Answer = append(resp.Answer, ptr)
 
-	txt := &dns.TXT{
-		Hdr: dns.RR_Header{
-			Name:   s.service.ServiceInstanceName(),
-			Rrtype: dns.TypeTXT,
-			Class:  dns.ClassINET,
-			Ttl:    ttl,
-		},
-		Txt: s.service.Text,
+	txt := &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   e.ServiceInstanceName(),
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Txt: text,
+	}
+	srvTtl := s.hostTTL(ttl)
+	srv := &dns.SRV{
+		Hdr: dns.RR_Header{
+			Name:   e.ServiceInstanceName(),
+			Rrtype: dns.TypeSRV,
+			Class:  dns.ClassINET,
+			Ttl:    srvTtl,
+		},
+		Priority: 0,
+		Weight:   0,
+		Port:     uint16(e.Port),
+		Target:   e.HostName,
+	}
+	resp.Extra = append(resp.Extra, srv, txt)
+
+	resp.Extra = s.appendEntryAddrs(resp.Extra, e, ttl, ifIndex, false)
+}
+
+// typeMatches reports whether a question of type qtype asks for records of
+// type rrtype.
+func typeMatches(qtype, rrtype uint16) bool {
+	return qtype == rrtype || qtype == dns.TypeANY
+}
+
+// composeInstanceAnswers answers a question for the service instance name
+// with the SRV and/or TXT record asked for. The addresses of the target host
+// are added as additional records to an SRV answer (RFC6763 section 12.2).
+// Questions for other types are answered with an NSEC record.
+func (s *Server) composeInstanceAnswers(resp *dns.Msg, qtype uint16, ttl uint32, ifIndex int, isLegacyUnicast bool) {
+	var cacheFlushBit uint16
+	if !isLegacyUnicast {
+		cacheFlushBit = qClassCacheFlush
+	}
+	e, text := s.entryFor(ifIndex)
+	if typeMatches(qtype, dns.TypeSRV) {
+		srvTtl := s.hostTTL(ttl)
+		resp.Answer = append(resp.Answer, &dns.SRV{
+			Hdr: dns.RR_Header{
+				Name:   e.ServiceInstanceName(),
+				Rrtype: dns.TypeSRV,
+				Class:  dns.ClassINET | cacheFlushBit,
+				Ttl:    srvTtl,
+			},
+			Priority: 0,
+			Weight:   0,
+			Port:     uint16(e.Port),
+			Target:   e.HostName,
+		})
+		resp.Extra = s.appendEntryAddrs(resp.Extra, e, ttl, ifIndex, false)
+	}
+	if typeMatches(qtype, dns.TypeTXT) {
+		resp.Answer = append(resp.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   e.ServiceInstanceName(),
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET | cacheFlushBit,
+				Ttl:    ttl,
+			},
+			Txt: text,
+		})
+	}
+	if !typeMatches(qtype, dns.TypeSRV) && !typeMatches(qtype, dns.TypeTXT) {
+		// Only SRV and TXT records exist for the name (RFC6762 section 6.1)
+		ttl = s.hostTTL(ttl)
+		resp.Answer = append(resp.Answer, newNSEC(e.ServiceInstanceName(), ttl, []uint16{dns.TypeTXT, dns.TypeSRV}))
+	}
+}
+
+// composeAddrAnswers answers a question for the host name with the address
+// records asked for. Address records of the other family are added as
+// additional records; if the host has none, an NSEC record asserts that
+// (RFC6762 section 6.2). A question for a type the host has no record of is
+// answered with an NSEC record only (RFC6762 section 6.1).
+func (s *Server) composeAddrAnswers(resp *dns.Msg, qtype uint16, ttl uint32, ifIndex int) {
+	addrs := s.appendAddrs(nil, ttl, ifIndex, false)
+	if len(addrs) == 0 {
+		return
 	}
-	srvTtl := ttl
-	if srvTtl > transientRecordTTL {
-		srvTtl = transientRecordTTL
+	var types []uint16
+	var answered bool
+	for _, rr := range addrs {
+		rrtype := rr.Header().Rrtype
+		if !containsType(types, rrtype) {
+			types = append(types, rrtype)
+		}
+		if typeMatches(qtype, rrtype) {
+			resp.Answer = append(resp.Answer, rr)
+			answered = true
+		} else {
+			resp.Extra = append(resp.Extra, rr)
+		}
 	}
-	srv := &dns.SRV{
-		Hdr: dns.RR_Header{
-			Name:   s.service.ServiceInstanceName(),
-			Rrtype: dns.TypeSRV,
-			Class:  dns.ClassINET,
-			Ttl:    srvTtl,
-		},
-		Priority: 0,
-		Weight:   0,
-		Port:     uint16(s.service.Port),
-		Target:   s.service.HostName,
+	if qtype == dns.TypeANY || len(types) == 2 {
+		return
+	}
+	ttl = s.hostTTL(ttl)
+	nsec := newNSEC(s.entry().HostName, ttl, types)
+	if answered {
+		resp.Extra = append(resp.Extra, nsec)
+	} else {
+		resp.Answer = append(resp.Answer, nsec)
 	}
-	resp.Extra = append(resp.Extra, srv, txt)
-
-	resp.Extra = s.appendAddrs(resp.Extra, ttl, ifIndex, false)
 }
 
 func (s *Server) composeLookupAnswers(resp *dns.Msg, ttl uint32, ifIndex int, flushCache bool, isLegacyUnicast bool, isProbe bool) {
@@ -448,63 +1913,75 @@ func (s *Server) composeLookupAnswers(resp *dns.Msg, ttl uint32, ifIndex int, fl
 	//    Section of a response message is the Multicast DNS cache-flush bit
 	//    and is discussed in more detail below in Section 10.2, "Announcements
 	//    to Flush Outdated Cache Entries".
+	e, text := s.entryFor(ifIndex)
 	var cacheFlushBit uint16
 	if !isLegacyUnicast {
 		cacheFlushBit = qClassCacheFlush
 	}
 	ptr := &dns.PTR{
 		Hdr: dns.RR_Header{
-			Name:   s.service.ServiceName(),
+			Name:   e.ServiceName(),
 			Rrtype: dns.TypePTR,
 			Class:  dns.ClassINET,
 			Ttl:    ttl,
 		},
-		Ptr: s.service.ServiceInstanceName(),
-	}
-	srvTtl := ttl
-	if srvTtl > transientRecordTTL {
-		srvTtl = transientRecordTTL
+		Ptr: e.ServiceInstanceName(),
 	}
+	srvTtl := s.hostTTL(ttl)
 	srv := &dns.SRV{
 		Hdr: dns.RR_Header{
-			Name:   s.service.ServiceInstanceName(),
+			Name:   e.ServiceInstanceName(),
 			Rrtype: dns.TypeSRV,
 			Class:  dns.ClassINET | cacheFlushBit,
 			Ttl:    srvTtl,
 		},
 		Priority: 0,
 		Weight:   0,
-		Port:     uint16(s.service.Port),
-		Target:   s.service.HostName,
+		Port:     uint16(e.Port),
+		Target:   e.HostName,
 	}
 	txt := &dns.TXT{
 		Hdr: dns.RR_Header{
-			Name:   s.service.ServiceInstanceName(),
+			Name:   e.ServiceInstanceName(),
 			Rrtype: dns.TypeTXT,
 			Class:  dns.ClassINET | cacheFlushBit,
 			Ttl:    ttl,
 		},
-		Txt: s.service.Text,
+		Txt: text,
 	}
 	dnssd := &dns.PTR{
 		Hdr: dns.RR_Header{
-			Name:   s.service.ServiceTypeName(),
+			Name:   e.ServiceTypeName(),
 			Rrtype: dns.TypePTR,
 			Class:  dns.ClassINET,
 			Ttl:    ttl,
 		},
-		Ptr: s.service.ServiceName(),
+		Ptr: e.ServiceName(),
 	}
 
 	if isProbe {
 		resp.Answer = append(resp.Answer, srv, txt, ptr, dnssd)
+		if s.opts.reverse {
+			resp.Answer = s.appendReverse(resp.Answer, "", ttl, ifIndex, flushCache)
+		}
+		for _, subtype := range e.SubtypeNames() {
+			resp.Answer = append(resp.Answer, &dns.PTR{
+				Hdr: dns.RR_Header{
+					Name:   subtype,
+					Rrtype: dns.TypePTR,
+					Class:  dns.ClassINET,
+					Ttl:    ttl,
+				},
+				Ptr: e.ServiceInstanceName(),
+			})
+		}
 	} else {
 		resp.Answer = append(resp.Answer, srv)
 	}
-	resp.Extra = s.appendAddrs(resp.Extra, ttl, ifIndex, flushCache)
+	resp.Extra = s.appendEntryAddrs(resp.Extra, e, ttl, ifIndex, flushCache)
 }
 
-func (s *Server) serviceTypeName(resp *dns.Msg, ttl uint32) {
+func (s *Server) serviceTypeName(resp *dns.Msg, e *ServiceEntry, ttl uint32) {
 	// From RFC6762
 	// 9.  Service Type Enumeration
 	//
@@ -515,126 +1992,547 @@ func (s *Server) serviceTypeName(resp *dns.Msg, ttl uint32) {
 	//    "_http._tcp.<Domain>".
 	dnssd := &dns.PTR{
 		Hdr: dns.RR_Header{
-			Name:   s.service.ServiceTypeName(),
+			Name:   e.ServiceTypeName(),
 			Rrtype: dns.TypePTR,
 			Class:  dns.ClassINET,
 			Ttl:    ttl,
 		},
-		Ptr: s.service.ServiceName(),
+		Ptr: e.ServiceName(),
 	}
 	resp.Answer = append(resp.Answer, dnssd)
 }
 
 // Perform probing & announcement
-//TODO: implement a proper probing & conflict resolution
 func (s *Server) probe() {
-	q := new(dns.Msg)
-	q.SetQuestion(s.service.ServiceInstanceName(), dns.TypePTR)
+	s.emit(Probing, nil)
+	if !s.probeNames() {
+		select {
+		case <-s.shouldShutdown:
+			s.setReady(ErrShutdown)
+		default:
+			s.setReady(ErrNameConflict)
+		}
+		return
+	}
+
+	// From RFC6762
+	//    The Multicast DNS responder MUST send at least two unsolicited
+	//    responses, one second apart. To provide increased robustness against
+	//    packet loss, a responder MAY send up to eight unsolicited responses,
+	//    provided that the interval between unsolicited responses increases by
+	//    at least a factor of two with every response sent.
+	timeout := 1 * time.Second
+	for i := 0; i < multicastRepetitions; i++ {
+		s.announce()
+		if !s.sleep(timeout) {
+			s.setReady(ErrShutdown)
+			return
+		}
+		timeout *= 2
+	}
+	s.emit(Announced, nil)
+	s.setReady(nil)
+
+	if s.opts.reannounce > 0 || s.opts.reannounceIval > 0 {
+		s.reannounceOnce.Do(func() {
+			if s.track() {
+				go s.reannounceLoop()
+			}
+		})
+	}
+}
+
+// probeNames sends three probes 250ms apart after a random delay of up to
+// 250ms (RFC6762 section 8.1) and reports whether the names are free to use.
+// After losing a simultaneous probe tiebreak, probing starts over one second
+// later. If another responder answers for the names, the conflict is
+// resolved by resolveConflict.
+func (s *Server) probeNames() bool {
+	probes := make(map[int]*dns.Msg)
+	s.eachInterface(func(ifIndex int) {
+		q := new(dns.Msg)
+		e := s.entry()
+		if s.hostOnly {
+			q.SetQuestion(e.HostName, dns.TypeANY)
+			q.RecursionDesired = false
+			q.Ns = s.appendEntryAddrs(nil, e, s.ttl, 0, false)
+		} else {
+			s.composeProbe(q, ifIndex)
+			if s.isProbingHost() {
+				q.Question = append(q.Question, dns.Question{Name: e.HostName, Qtype: dns.TypeANY, Qclass: dns.ClassINET})
+				q.Ns = s.appendEntryAddrs(q.Ns, e, s.ttl, ifIndex, false)
+			}
+		}
+		probes[ifIndex] = q
+	})
+
+	randomizer := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	s.probeLock.Lock()
+	s.probing = true
+	s.probeLock.Unlock()
+	// Drop conflicts reported for a previous name
+	select {
+	case <-s.conflict:
+	default:
+	}
+	defer func() {
+		s.probeLock.Lock()
+		s.probing = false
+		s.probeLock.Unlock()
+	}()
+
+	delay := time.Duration(randomizer.Intn(250)) * time.Millisecond
+	for i := 0; i < probeRepetitions; {
+		select {
+		case <-s.shouldShutdown:
+			return false
+		case c := <-s.conflict:
+			if c == conflictAnswered {
+				return s.resolveConflict()
+			}
+			// Lost the tiebreak: defer and probe again
+			delay, i = time.Second, 0
+			continue
+		case <-time.After(delay):
+		}
+		for ifIndex, q := range probes {
+			if err := s.multicastResponse(q, ifIndex); err != nil {
+				s.log().Errorf("failed to send probe: %v", err)
+			}
+		}
+		delay = probeInterval
+		i++
+	}
+	// Wait for answers to the last probe
+	select {
+	case <-s.shouldShutdown:
+		return false
+	case c := <-s.conflict:
+		if c == conflictAnswered {
+			return s.resolveConflict()
+		}
+		// Lost the tiebreak: defer and probe again
+		select {
+		case <-s.shouldShutdown:
+			return false
+		case <-time.After(time.Second):
+		}
+		return s.probeNames()
+	case <-time.After(probeInterval):
+	}
+	return true
+}
+
+// resolveConflict reports a name conflict found while probing. Unless
+// disabled, the service is renamed like "Name (2)", "Name (3)" and so on, and
+// probing starts over with the new name, subject to the rate limit of
+// conflictDelay. It reports whether the service may be announced.
+func (s *Server) resolveConflict() bool {
+	s.log().Infof("name conflict for %s", s.entry().ServiceInstanceName())
+	atomic.AddUint64(&s.stats.conflicts, 1)
+	s.emit(Conflict, nil)
+	// Host names are not renamed
+	if s.hostOnly || s.opts.noRename || s.isProbingHost() {
+		return false
+	}
+
+	old := s.entry().Instance
+	e := s.updateEntry(func(e *ServiceEntry) {
+		if e.renames == 0 {
+			e.baseInstance = e.Instance
+		}
+		e.renames++
+		e.setInstance(e.renamed(e.baseInstance))
+	})
+	if s.opts.onRename != nil {
+		s.opts.onRename(old, e.Instance)
+	}
+	select {
+	case <-s.shouldShutdown:
+		return false
+	case <-time.After(s.conflictDelay()):
+	}
+	return s.probeNames()
+}
+
+// renamed returns the instance name with the suffix of the current rename.
+func (e *ServiceEntry) renamed(instance string) string {
+	if e.renames == 0 {
+		return instance
+	}
+	return fmt.Sprintf("%s (%d)", instance, e.renames+1)
+}
+
+// composeProbe builds the probe query for the service instance name.
+func (s *Server) composeProbe(q *dns.Msg, ifIndex int) {
+	e, text := s.entryFor(ifIndex)
+	q.SetQuestion(e.ServiceInstanceName(), dns.TypeANY)
 	q.RecursionDesired = false
 
 	srv := &dns.SRV{
 		Hdr: dns.RR_Header{
-			Name:   s.service.ServiceInstanceName(),
+			Name:   e.ServiceInstanceName(),
 			Rrtype: dns.TypeSRV,
 			Class:  dns.ClassINET,
-			Ttl:    transientRecordTTL,
+			Ttl:    s.hostTTL(s.ttl),
 		},
 		Priority: 0,
 		Weight:   0,
-		Port:     uint16(s.service.Port),
-		Target:   s.service.HostName,
+		Port:     uint16(e.Port),
+		Target:   e.HostName,
 	}
 	txt := &dns.TXT{
 		Hdr: dns.RR_Header{
-			Name:   s.service.ServiceInstanceName(),
+			Name:   e.ServiceInstanceName(),
 			Rrtype: dns.TypeTXT,
 			Class:  dns.ClassINET,
 			Ttl:    s.ttl,
 		},
-		Txt: s.service.Text,
+		Txt: text,
 	}
 	q.Ns = []dns.RR{srv, txt}
+}
 
-	randomizer := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	for i := 0; i < multicastRepetitions; i++ {
-		if err := s.multicastResponse(q, 0); err != nil {
-			log.Println("[ERR] zeroconf: failed to send probe:", err.Error())
+// announce multicasts the service records with cache flush enabled on every
+// interface.
+func (s *Server) announce() {
+	for _, intf := range s.interfaces() {
+		resp := s.composeAnnouncement(intf.Index)
+		if err := s.multicastResponse(resp, intf.Index); err != nil {
+			s.log().Errorf("failed to send announcement: %v", err)
 		}
-		time.Sleep(time.Duration(randomizer.Intn(250)) * time.Millisecond)
 	}
+}
 
-	// From RFC6762
-	//    The Multicast DNS responder MUST send at least two unsolicited
-	//    responses, one second apart. To provide increased robustness against
-	//    packet loss, a responder MAY send up to eight unsolicited responses,
-	//    provided that the interval between unsolicited responses increases by
-	//    at least a factor of two with every response sent.
-	timeout := 1 * time.Second
-	for i := 0; i < multicastRepetitions; i++ {
-		for _, intf := range s.ifaces {
-			resp := new(dns.Msg)
-			resp.MsgHdr.Response = true
-			resp.Compress = true
-			resp.RecursionDesired = false
-			resp.Authoritative = true
-			resp.Answer = []dns.RR{}
-			resp.Extra = []dns.RR{}
-			s.composeLookupAnswers(resp, s.ttl, intf.Index, true, false, true)
-			if err := s.multicastResponse(resp, intf.Index); err != nil {
-				log.Println("[ERR] zeroconf: failed to send announcement:", err.Error())
+// composeAnnouncement builds the unsolicited response announcing all
+// records of the server on the given interface.
+func (s *Server) composeAnnouncement(ifIndex int) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.MsgHdr.Response = true
+	resp.Compress = true
+	resp.RecursionDesired = false
+	resp.Authoritative = true
+	resp.Answer = []dns.RR{}
+	resp.Extra = []dns.RR{}
+	if s.hostOnly {
+		s.composeHostAnswers(resp, s.ttl, ifIndex, true)
+	} else {
+		s.composeLookupAnswers(resp, s.ttl, ifIndex, true, false, true)
+	}
+	appendNSEC(resp)
+	return resp
+}
+
+// reannounceLoop periodically announces the service records again, so caches
+// which missed the initial announcements converge before the records expire.
+func (s *Server) reannounceLoop() {
+	defer s.shutdownEnd.Done()
+
+	// A record must not be multicast more than once per second (RFC6762
+	// section 6).
+	window := func(rr dns.RR) time.Duration {
+		return time.Second
+	}
+	interval := s.opts.reannounceIval
+	if interval == 0 {
+		// Host records have the shortest lifetime, so they set the pace. Other
+		// records are announced again once the fraction of their own TTL has
+		// passed, allowing for a second of timer slack.
+		interval = time.Duration(float64(s.hostTTL(s.ttl)) * s.opts.reannounce * float64(time.Second))
+		if interval < time.Second {
+			interval = time.Second
+		}
+		window = func(rr dns.RR) time.Duration {
+			d := time.Duration(float64(rr.Header().Ttl)*s.opts.reannounce*float64(time.Second)) - time.Second
+			if d < time.Second {
+				d = time.Second
 			}
+			return d
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shouldShutdown:
+			return
+		case <-ticker.C:
+			s.reannounce(window)
 		}
-		time.Sleep(timeout)
-		timeout *= 2
 	}
 }
 
-// announceText sends a Text announcement with cache flush enabled
-func (s *Server) announceText() {
-	resp := new(dns.Msg)
-	resp.MsgHdr.Response = true
+// reannounce multicasts the announcement on every interface again, leaving
+// out the records which were multicast on the interface within their window.
+func (s *Server) reannounce(window func(rr dns.RR) time.Duration) {
+	now := time.Now()
+	for _, intf := range s.interfaces() {
+		resp := s.composeAnnouncement(intf.Index)
+		answers := resp.Answer[:0]
+		for _, rr := range resp.Answer {
+			if !s.history.sentWithin(rr, intf.Index, window(rr), now) {
+				answers = append(answers, rr)
+			}
+		}
+		resp.Answer = answers
+		if len(resp.Answer) == 0 {
+			continue
+		}
+		if err := s.multicastResponse(resp, intf.Index); err != nil {
+			s.log().Errorf("failed to send announcement: %v", err)
+		}
+	}
+}
 
-	txt := &dns.TXT{
-		Hdr: dns.RR_Header{
-			Name:   s.service.ServiceInstanceName(),
-			Rrtype: dns.TypeTXT,
-			Class:  dns.ClassINET | qClassCacheFlush,
-			Ttl:    s.ttl,
-		},
-		Txt: s.service.Text,
+// announceChanged multicasts updated records with cache flush enabled. The
+// records are composed per interface if interface variants are configured. As
+// required by RFC6762 section 8.4, the announcement is repeated at least
+// twice, one second apart; the repetitions are sent in the background.
+func (s *Server) announceChanged(compose func(ifIndex int) []dns.RR) {
+	msgs := make(map[int]*dns.Msg)
+	s.eachInterface(func(ifIndex int) {
+		records := compose(ifIndex)
+		if len(records) == 0 {
+			return
+		}
+		for _, rr := range records {
+			// PTR records are shared and must not carry the cache flush bit.
+			if rr.Header().Rrtype != dns.TypePTR {
+				rr.Header().Class |= qClassCacheFlush
+			}
+		}
+		resp := new(dns.Msg)
+		resp.MsgHdr.Response = true
+		resp.Authoritative = true
+		resp.Compress = true
+		resp.Answer = records
+		msgs[ifIndex] = resp
+	})
+	if len(msgs) == 0 {
+		return
+	}
+	send := func() {
+		for ifIndex, resp := range msgs {
+			if err := s.multicastResponse(resp, ifIndex); err != nil {
+				s.log().Errorf("failed to send announcement: %v", err)
+			}
+		}
 	}
 
-	resp.Answer = []dns.RR{txt}
-	s.multicastResponse(resp, 0)
+	send()
+	if !s.track() {
+		return
+	}
+	go func() {
+		defer s.shutdownEnd.Done()
+		for i := 1; i < multicastRepetitions; i++ {
+			if !s.sleep(time.Second) {
+				return
+			}
+			send()
+		}
+	}()
 }
 
+// unregister sends the goodbye packets. Like announcements, they are sent
+// twice, one second apart, in case the first packet is lost. It returns once
+// the last goodbye has been sent.
 func (s *Server) unregister() error {
+	var err error
+	for i := 0; i < multicastRepetitions; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		s.eachInterface(func(ifIndex int) {
+			if e := s.multicastResponse(s.composeGoodbye(ifIndex), ifIndex); e != nil {
+				err = e
+			}
+		})
+	}
+	return err
+}
+
+// composeGoodbye builds the response withdrawing all records of the server
+// on the given interface, or on all interfaces for index 0.
+func (s *Server) composeGoodbye(ifIndex int) *dns.Msg {
 	resp := new(dns.Msg)
 	resp.MsgHdr.Response = true
 	resp.Answer = []dns.RR{}
 	resp.Extra = []dns.RR{}
-	s.composeLookupAnswers(resp, 0, 0, true, false, true)
-	return s.multicastResponse(resp, 0)
+	if s.hostOnly {
+		s.composeHostAnswers(resp, 0, ifIndex, true)
+	} else {
+		s.composeLookupAnswers(resp, 0, ifIndex, true, false, true)
+	}
+	return resp
 }
 
-func (s *Server) appendAddrs(list []dns.RR, ttl uint32, ifIndex int, flushCache bool) []dns.RR {
-	var v4, v6 []net.IP
+// addrs returns the addresses published for the entry's host on the given
+// interface, or on all interfaces if ifIndex is 0.
+func (s *Server) addrs(e *ServiceEntry, ifIndex int) (v4, v6 []net.IP) {
 	iface, _ := net.InterfaceByIndex(ifIndex)
-	if iface != nil {
+	if len(e.AddrIPv4) > 0 || len(e.AddrIPv6) > 0 {
+		// Explicitly configured addresses, e.g. of a proxied host
+		v4, v6 = e.AddrIPv4, e.AddrIPv6
+	} else if iface != nil {
 		v4, v6 = addrsForInterface(iface)
+		v6 = s.preferStable(iface.Index, v6)
 	} else {
-		for _, iface := range s.ifaces {
+		ifaces := s.interfaces()
+		for _, iface := range ifaces {
 			i4, i6 := addrsForInterface(&iface)
+			if len(ifaces) > 1 {
+				// Link-local addresses are meaningless on other links
+				i6 = withoutLinkLocal(i6)
+			}
 			v4 = append(v4, i4...)
-			v6 = append(v6, i6...)
+			v6 = append(v6, s.preferStable(iface.Index, i6)...)
+		}
+	}
+	return v4, v6
+}
+
+// checkSource reports whether received packets are checked with
+// isTrustedSource. Packets of custom transports may come from any network, so
+// they are not checked.
+func (s *Server) checkSource() bool {
+	return !s.opts.noSourceCheck && !s.opts.customTransport
+}
+
+// isTrustedSource checks a received packet against RFC6762 section 11: the
+// source address must be link-local or on the subnet of the receiving
+// interface, and packets from port 5353 must arrive with an IP TTL of 255.
+// Legacy unicast queries may be sent with any TTL. A TTL of 0 means the
+// platform did not report it.
+func (s *Server) isTrustedSource(from net.Addr, ifIndex, ttl int) bool {
+	addr, ok := from.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	if ttl != 0 && ttl != multicastTTL && addr.Port == s.group().ipv4.Port {
+		return false
+	}
+	if addr.IP.IsLinkLocalUnicast() || addr.IP.IsLoopback() {
+		return true
+	}
+	iface, err := net.InterfaceByIndex(ifIndex)
+	if err != nil {
+		return false
+	}
+	addrs, _ := iface.Addrs()
+	for _, address := range addrs {
+		if ipnet, ok := address.(*net.IPNet); ok && ipnet.Contains(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutLinkLocal returns the addresses which are not link-local.
+func withoutLinkLocal(ips []net.IP) []net.IP {
+	var global []net.IP
+	for _, ip := range ips {
+		if !ip.IsLinkLocalUnicast() {
+			global = append(global, ip)
+		}
+	}
+	return global
+}
+
+// socketOpts returns the configuration of the multicast sockets.
+func (s *Server) socketOpts() socketOpts {
+	return socketOpts{
+		reusePort: !s.opts.noReusePort,
+		device:    s.opts.bindDevice,
+	}
+}
+
+// group returns the multicast group the server uses.
+func (s *Server) group() multicastGroup {
+	return s.opts.group.orDefault()
+}
+
+// interfaceIndexFor determines the interface a packet was received on from
+// its source address, on platforms which do not report the interface in
+// control messages. Answers then only carry the addresses valid on that link.
+// It returns 0 if no interface matches.
+func (s *Server) interfaceIndexFor(from net.Addr) int {
+	addr, ok := from.(*net.UDPAddr)
+	if !ok {
+		return 0
+	}
+	if addr.Zone != "" {
+		if index, err := strconv.Atoi(addr.Zone); err == nil {
+			return index
+		}
+		if iface, err := net.InterfaceByName(addr.Zone); err == nil {
+			return iface.Index
+		}
+	}
+	for _, iface := range s.interfaces() {
+		addrs, _ := iface.Addrs()
+		for _, address := range addrs {
+			if ipnet, ok := address.(*net.IPNet); ok && ipnet.Contains(addr.IP) {
+				return iface.Index
+			}
+		}
+	}
+	return 0
+}
+
+// preferStable orders the IPv6 addresses of an interface so that stable
+// addresses come before temporary ones, or drops the temporary addresses if
+// configured. Without any stable address, temporary ones are kept.
+func (s *Server) preferStable(ifIndex int, ips []net.IP) []net.IP {
+	if len(ips) == 0 {
+		return ips
+	}
+	temporary := s.temporary.get(ifIndex)
+	if len(temporary) == 0 {
+		return ips
+	}
+	var stable, temp []net.IP
+	for _, ip := range ips {
+		if temporary[ip.String()] {
+			temp = append(temp, ip)
+		} else {
+			stable = append(stable, ip)
 		}
 	}
-	if ttl > transientRecordTTL {
-		// force low timeout for A/AAAA responses, as network interface
-		// up state and IPs are dynamic.
-		ttl = transientRecordTTL
+	if s.opts.noTemporary && len(stable) > 0 {
+		return stable
+	}
+	return append(stable, temp...)
+}
+
+// hostTTL returns the TTL of records tied to the host. A host TTL set with
+// WithHostTTL replaces the service TTL; shorter TTLs, e.g. of goodbye records
+// or legacy unicast responses, are kept.
+func (s *Server) hostTTL(ttl uint32) uint32 {
+	limit := s.opts.hostTTL
+	if limit == 0 {
+		limit = transientRecordTTL
+	} else if ttl == s.ttl {
+		return limit
+	}
+	if ttl > limit {
+		return limit
 	}
+	return ttl
+}
+
+// appendAddrs adds the address records of the published host name.
+func (s *Server) appendAddrs(list []dns.RR, ttl uint32, ifIndex int, flushCache bool) []dns.RR {
+	return s.appendEntryAddrs(list, s.entry(), ttl, ifIndex, flushCache)
+}
+
+// appendEntryAddrs adds the address records of the entry's host name.
+func (s *Server) appendEntryAddrs(list []dns.RR, e *ServiceEntry, ttl uint32, ifIndex int, flushCache bool) []dns.RR {
+	v4, v6 := s.addrs(e, ifIndex)
+	// force low timeout for A/AAAA responses, as network interface
+	// up state and IPs are dynamic.
+	ttl = s.hostTTL(ttl)
 	var cacheFlushBit uint16
 	if flushCache {
 		cacheFlushBit = qClassCacheFlush
@@ -642,7 +2540,7 @@ func (s *Server) appendAddrs(list []dns.RR, ttl uint32, ifIndex int, flushCache
 	for _, ipv4 := range v4 {
 		a := &dns.A{
 			Hdr: dns.RR_Header{
-				Name:   s.service.HostName,
+				Name:   e.HostName,
 				Rrtype: dns.TypeA,
 				Class:  dns.ClassINET | cacheFlushBit,
 				Ttl:    ttl,
@@ -654,7 +2552,7 @@ func (s *Server) appendAddrs(list []dns.RR, ttl uint32, ifIndex int, flushCache
 	for _, ipv6 := range v6 {
 		aaaa := &dns.AAAA{
 			Hdr: dns.RR_Header{
-				Name:   s.service.HostName,
+				Name:   e.HostName,
 				Rrtype: dns.TypeAAAA,
 				Class:  dns.ClassINET | cacheFlushBit,
 				Ttl:    ttl,
@@ -666,6 +2564,9 @@ func (s *Server) appendAddrs(list []dns.RR, ttl uint32, ifIndex int, flushCache
 	return list
 }
 
+// addrsForInterface returns the addresses of an interface to publish. IPv6
+// link-local addresses are listed after the global ones; they are the only
+// IPv6 addresses on many links and valid on this interface only.
 func addrsForInterface(iface *net.Interface) ([]net.IP, []net.IP) {
 	var v4, v6, v6local []net.IP
 	addrs, _ := iface.Addrs()
@@ -683,82 +2584,124 @@ func addrsForInterface(iface *net.Interface) ([]net.IP, []net.IP) {
 			}
 		}
 	}
-	if len(v6) == 0 {
-		v6 = v6local
-	}
-	return v4, v6
+	return v4, append(v6, v6local...)
 }
 
 // unicastResponse is used to send a unicast response packet
 func (s *Server) unicastResponse(resp *dns.Msg, ifIndex int, from net.Addr) error {
-	buf, err := resp.Pack()
-	if err != nil {
+	addr, ok := from.(*net.UDPAddr)
+	if !ok {
+		// The source of a custom transport cannot be told apart by address
+		// family, so the response is multicast instead.
+		return s.multicastResponse(resp, ifIndex)
+	}
+	// Names are compressed in all outgoing messages (RFC6762 section 18.14)
+	resp.Compress = true
+	if resp.Len() > maxMessageSize {
+		parts, err := splitResponse(resp, maxMessageSize)
+		if err != nil {
+			return err
+		}
+		for _, part := range parts {
+			if e := s.unicastResponse(part, ifIndex, from); e != nil {
+				err = e
+			}
+		}
 		return err
 	}
-	addr := from.(*net.UDPAddr)
-	if addr.IP.To4() != nil {
-		if ifIndex != 0 {
-			var wcm ipv4.ControlMessage
-			wcm.IfIndex = ifIndex
-			_, err = s.ipv4conn.WriteTo(buf, &wcm, addr)
-		} else {
-			_, err = s.ipv4conn.WriteTo(buf, nil, addr)
-		}
+	buf, err := s.pack(resp)
+	if err != nil {
 		return err
-	} else {
-		if ifIndex != 0 {
-			var wcm ipv6.ControlMessage
-			wcm.IfIndex = ifIndex
-			_, err = s.ipv6conn.WriteTo(buf, &wcm, addr)
-		} else {
-			_, err = s.ipv6conn.WriteTo(buf, nil, addr)
+	}
+	if addr.Zone == "" && ifIndex != 0 && addr.IP.IsLinkLocalUnicast() && addr.IP.To4() == nil {
+		// Link-local destinations are scoped to the receiving interface
+		if iface, err := net.InterfaceByIndex(ifIndex); err == nil {
+			addr = &net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: iface.Name}
 		}
-		return err
 	}
+	s.opts.capture.write(nil, addr, buf)
+	conn := s.ipv6conn
+	if addr.IP.To4() != nil {
+		conn = s.ipv4conn
+	}
+	if conn == nil {
+		return fmt.Errorf("No transport for %s", addr)
+	}
+	if _, err = conn.WriteTo(buf, ifIndex, addr); err == nil {
+		atomic.AddUint64(&s.stats.unicast, 1)
+		atomic.AddUint64(&s.stats.bytesOut, uint64(len(buf)))
+		s.responseSent(resp, addr)
+	}
+	return err
 }
 
 // multicastResponse us used to send a multicast response packet
 func (s *Server) multicastResponse(msg *dns.Msg, ifIndex int) error {
-	buf, err := msg.Pack()
+	// Names are compressed in all outgoing messages (RFC6762 section 18.14)
+	msg.Compress = true
+	if msg.Response && msg.Len() > maxMessageSize {
+		parts, err := splitResponse(msg, maxMessageSize)
+		if err != nil {
+			return err
+		}
+		for _, part := range parts {
+			if e := s.multicastResponse(part, ifIndex); e != nil {
+				err = e
+			}
+		}
+		return err
+	}
+	buf, err := s.pack(msg)
 	if err != nil {
 		return err
 	}
-	if s.ipv4conn != nil {
-		var wcm ipv4.ControlMessage
+	if msg.Response {
+		now := time.Now()
 		if ifIndex != 0 {
-			wcm.IfIndex = ifIndex
-			_, err = s.ipv4conn.WriteTo(buf, &wcm, ipv4Addr)
+			s.history.markSent(msg.Answer, ifIndex, now)
 		} else {
-			for _, intf := range s.ifaces {
-				wcm.IfIndex = intf.Index
-				_, err = s.ipv4conn.WriteTo(buf, &wcm, ipv4Addr)
+			for _, intf := range s.interfaces() {
+				s.history.markSent(msg.Answer, intf.Index, now)
 			}
 		}
-		if err != nil {
-			// log.Printf("[ERR] multicastResponse failed v4: %v", err)
+	}
+	if s.ipv4conn != nil {
+		s.multicastPacket(s.ipv4conn, buf, s.group().ipv4, ifIndex)
+		if msg.Response {
+			atomic.AddUint64(&s.stats.multicast, 1)
+			s.responseSent(msg, s.group().ipv4)
 		}
 	}
-
 	if s.ipv6conn != nil {
-		err = nil
-		var wcm ipv6.ControlMessage
-		if ifIndex != 0 {
-			wcm.IfIndex = ifIndex
-			_, err = s.ipv6conn.WriteTo(buf, &wcm, ipv6Addr)
-		} else {
-			for _, intf := range s.ifaces {
-				wcm.IfIndex = intf.Index
-				_, err = s.ipv6conn.WriteTo(buf, &wcm, ipv6Addr)
-			}
-		}
-		if err != nil {
-			// log.Printf("[ERR] multicastResponse failed v6: %v", err)
+		s.multicastPacket(s.ipv6conn, buf, s.group().ipv6, ifIndex)
+		if msg.Response {
+			atomic.AddUint64(&s.stats.multicast, 1)
+			s.responseSent(msg, s.group().ipv6)
 		}
 	}
-
 	return nil
 }
 
+// multicastPacket sends a packet to the multicast group on the interface with
+// the given index, or on every interface if ifIndex is 0.
+func (s *Server) multicastPacket(conn Transport, buf []byte, group *net.UDPAddr, ifIndex int) {
+	if ifIndex != 0 {
+		if _, err := conn.WriteTo(buf, ifIndex, group); err == nil {
+			atomic.AddUint64(&s.stats.bytesOut, uint64(len(buf)))
+		}
+		s.opts.capture.write(nil, group, buf)
+		return
+	}
+	for _, intf := range s.interfaces() {
+		if _, err := conn.WriteTo(buf, intf.Index, group); err != nil {
+			s.checkInterface(intf)
+		} else {
+			atomic.AddUint64(&s.stats.bytesOut, uint64(len(buf)))
+		}
+		s.opts.capture.write(nil, group, buf)
+	}
+}
+
 func isUnicastQuestion(q dns.Question) bool {
 	// From RFC6762
 	// 18.12.  Repurposing of Top Bit of qclass in Question Section
@@ -766,5 +2709,5 @@ func isUnicastQuestion(q dns.Question) bool {
 	//    In the Question Section of a Multicast DNS query, the top bit of the
 	//    qclass field is used to indicate that unicast responses are preferred
 	//    for this particular question.  (See Section 5.4.)
-	return q.Qclass&qClassCacheFlush != 0
+	return q.Qclass&qClassUnicastResponse != 0
 }
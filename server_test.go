@@ -0,0 +1,190 @@
+package zeroconf
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// BenchmarkCompression packs a typical browse response, with the PTR answer
+// and the SRV, TXT and address records as additional records, with and
+// without name compression. The size on the wire is reported as bytes/msg.
+func BenchmarkCompression(b *testing.B) {
+	s, _ := newIdleServer(b)
+	query := new(dns.Msg)
+	query.SetQuestion(s.entry().ServiceTypeName(), dns.TypePTR)
+	resp := newResponse(query)
+	s.composeBrowsingAnswers(resp, s.entry().ServiceTypeName(), s.ttl, 0)
+
+	for _, compress := range []bool{false, true} {
+		name := "uncompressed"
+		if compress {
+			name = "compressed"
+		}
+		b.Run(name, func(b *testing.B) {
+			msg := resp.Copy()
+			msg.Compress = compress
+			var size int
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf, err := msg.Pack()
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(buf)
+			}
+			b.ReportMetric(float64(size), "bytes/msg")
+		})
+	}
+}
+
+func TestReadyShutdown(t *testing.T) {
+	s, err := Register("test", "_test._tcp", "local.", 8080, nil, nil, 0,
+		WithTransport(newMemTransport(), nil), WithIPs(net.ParseIP("192.0.2.1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Shutdown()
+	select {
+	case <-s.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("Ready not closed after shutdown")
+	}
+	if err := s.ReadyErr(); !errors.Is(err, ErrShutdown) {
+		t.Errorf("ReadyErr() = %v, want %v", err, ErrShutdown)
+	}
+}
+
+func TestReadyNameConflict(t *testing.T) {
+	transport := newMemTransport()
+	s, err := Register("test", "_test._tcp", "local.", 8080, nil, nil, 0,
+		WithTransport(transport, nil), WithIPs(net.ParseIP("192.0.2.1")), WithoutRename())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	// Another responder answers the first probe with a different port
+	select {
+	case <-transport.sent:
+	case <-time.After(time.Second):
+		t.Fatal("no probe sent")
+	}
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Answer = []dns.RR{&dns.SRV{
+		Hdr:    dns.RR_Header{Name: "test._test._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 120},
+		Port:   9090,
+		Target: "other.local.",
+	}}
+	if err := transport.deliver(resp, memAddr("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-s.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ready not closed after a name conflict")
+	}
+	if err := s.ReadyErr(); !errors.Is(err, ErrNameConflict) {
+		t.Errorf("ReadyErr() = %v, want %v", err, ErrNameConflict)
+	}
+}
+
+func TestHostTTL(t *testing.T) {
+	tests := []struct {
+		name       string
+		hostTTL    uint32
+		serviceTTL uint32
+		ttl        uint32
+		want       uint32
+	}{
+		{"default", 0, 4500, 4500, 120},
+		{"default short service TTL", 0, 60, 60, 60},
+		{"shorter", 30, 4500, 4500, 30},
+		{"longer", 7200, 4500, 4500, 7200},
+		{"goodbye", 7200, 4500, 0, 0},
+		{"legacy unicast", 7200, 4500, legacyUnicastTTL, legacyUnicastTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{ttl: tt.serviceTTL}
+			s.opts.hostTTL = tt.hostTTL
+			if got := s.hostTTL(tt.ttl); got != tt.want {
+				t.Errorf("hostTTL(%d) = %d, want %d", tt.ttl, got, tt.want)
+			}
+		})
+	}
+}
+
+// registerOn registers the service "test" on the transport and shuts it down
+// at the end of the test.
+func registerOn(t *testing.T, transport *memTransport, port int, opts ...ServerOption) *Server {
+	opts = append([]ServerOption{WithTransport(transport, nil), WithIPs(net.ParseIP("192.0.2.1"))}, opts...)
+	s, err := Register("test", "_test._tcp", "local.", port, nil, nil, 0, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+// awaitReady waits until probing and the announcement finished and returns
+// the outcome.
+func awaitReady(t *testing.T, s *Server) error {
+	select {
+	case <-s.Ready():
+	case <-time.After(15 * time.Second):
+		t.Fatal("Ready not closed")
+	}
+	return s.ReadyErr()
+}
+
+func TestProbeConflictWithResponder(t *testing.T) {
+	var network memNetwork
+	owner := registerOn(t, network.join("owner"), 8080)
+	if err := awaitReady(t, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner answers the probe for its name with its SRV record
+	s := registerOn(t, network.join("newcomer"), 9090, WithoutRename())
+	if err := awaitReady(t, s); !errors.Is(err, ErrNameConflict) {
+		t.Errorf("ReadyErr() = %v, want %v", err, ErrNameConflict)
+	}
+}
+
+func TestRenameAfterConflict(t *testing.T) {
+	var network memNetwork
+	owner := registerOn(t, network.join("owner"), 8080)
+	if err := awaitReady(t, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := make(chan string, 1)
+	transport := network.join("newcomer")
+	s := registerOn(t, transport, 9090, WithRenameHandler(func(old, new string) {
+		renamed <- new
+	}))
+	if err := awaitReady(t, s); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-renamed:
+		if name != "test (2)" {
+			t.Errorf("renamed to %q, want %q", name, "test (2)")
+		}
+	default:
+		t.Fatal("service not renamed")
+	}
+	if got := owner.Service().Instance; got != "test" {
+		t.Errorf("owner instance %q, want %q", got, "test")
+	}
+	// Names are escaped when unpacked
+	if instance := `test\ \(2\)._test._tcp.local.`; !transport.awaitAnswer(instance, time.Second) {
+		t.Errorf("%s not announced", instance)
+	}
+}
@@ -1,9 +1,13 @@
 package zeroconf
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"net/netip"
+	"strings"
 	"sync"
+	"time"
 )
 
 // ServiceRecord contains the basic description of a service, which contains instance name, service type & domain
@@ -11,6 +15,8 @@ type ServiceRecord struct {
 	Instance string `json:"name"`   // Instance name (e.g. "My web page")
 	Service  string `json:"type"`   // Service name (e.g. _http._tcp.)
 	Domain   string `json:"domain"` // If blank, assumes "local"
+	// Subtypes of the service (e.g. _printer), see RFC 6763 section 7.1
+	Subtypes []string `json:"subtypes,omitempty"`
 
 	// private variable populated on ServiceRecord creation
 	serviceName         string
@@ -30,17 +36,40 @@ func (s *ServiceRecord) ServiceInstanceName() string {
 	return s.serviceInstanceName
 }
 
+// setInstance changes the instance name and the cached instance name.
+func (s *ServiceRecord) setInstance(instance string) {
+	s.Instance = instance
+	s.serviceInstanceName = fmt.Sprintf("%s.%s", trimDot(instance), s.ServiceName())
+}
+
 // ServiceTypeName returns the complete identifier for a DNS-SD query.
 func (s *ServiceRecord) ServiceTypeName() string {
 	return s.serviceTypeName
 }
 
-// NewServiceRecord constructs a ServiceRecord.
+// SubtypeNames returns the complete names of the service's subtypes
+// (e.g. _printer._sub._foobar._tcp.local.).
+func (s *ServiceRecord) SubtypeNames() []string {
+	names := make([]string, 0, len(s.Subtypes))
+	for _, subtype := range s.Subtypes {
+		names = append(names, fmt.Sprintf("%s._sub.%s", trimDot(subtype), s.ServiceName()))
+	}
+	return names
+}
+
+// NewServiceRecord constructs a ServiceRecord. A subtyped service name
+// (e.g. _printer._sub._http._tcp) is split into its base type and subtype.
 func NewServiceRecord(instance, service, domain string) *ServiceRecord {
+	var subtypes []string
+	if i := strings.Index(service, "._sub."); i >= 0 {
+		subtypes = append(subtypes, service[:i])
+		service = service[i+len("._sub."):]
+	}
 	s := &ServiceRecord{
 		Instance:    instance,
 		Service:     service,
 		Domain:      domain,
+		Subtypes:    subtypes,
 		serviceName: fmt.Sprintf("%s.%s.", trimDot(service), trimDot(domain)),
 	}
 
@@ -64,30 +93,117 @@ type LookupParams struct {
 	ServiceRecord
 	Entries chan<- *ServiceEntry // Entries Channel
 
+	// events receives the changes of entries instead of Entries, see
+	// Resolver.BrowseEvents.
+	events      chan<- DiscoveryEvent
+	records     *recordCache
+	maxResults  int
+	partial     bool
+	unicast     bool
+	cancel      context.CancelFunc
 	stopProbing chan struct{}
 	once        sync.Once
 }
 
+// LookupOption configures a browse or lookup.
+type LookupOption func(*LookupParams)
+
+// WithMaxResults completes a browse or lookup once n distinct service
+// instances have been resolved. The entries channel is closed afterwards.
+func WithMaxResults(n int) LookupOption {
+	return func(l *LookupParams) {
+		l.maxResults = n
+	}
+}
+
+// WithPartialResults delivers the entries which are not completely resolved
+// when the context expires, before the entries channel is closed. Their
+// Missing field tells which data is lacking.
+func WithPartialResults() LookupOption {
+	return func(l *LookupParams) {
+		l.partial = true
+	}
+}
+
+// WithUnicastResponse sets the unicast-response bit in the questions of the
+// first query of a browse or lookup (RFC 6762 section 5.4). Responders answer
+// them directly to the querier, which cuts the multicast traffic on large
+// networks. The replies are received on the socket the query was sent from.
+// Later queries ask for multicast responses, so answers still arrive if the
+// unicast reply was taken by another socket sharing the mDNS port.
+func WithUnicastResponse() LookupOption {
+	return func(l *LookupParams) {
+		l.unicast = true
+	}
+}
+
+// apply applies the given options.
+func (l *LookupParams) apply(opts []LookupOption) {
+	for _, o := range opts {
+		if o != nil {
+			o(l)
+		}
+	}
+}
+
 // NewLookupParams constructs a LookupParams.
 func NewLookupParams(instance, service, domain string, entries chan<- *ServiceEntry) *LookupParams {
 	return &LookupParams{
 		ServiceRecord: *NewServiceRecord(instance, service, domain),
 		Entries:       entries,
 
+		records:     newRecordCache(),
 		stopProbing: make(chan struct{}),
 	}
 }
 
+// browseName returns the name queried for the PTR records of a browse: the
+// name of the subtype if the service type was given with one (e.g.
+// _printer._sub._http._tcp.local.), the service name otherwise.
+func (l *LookupParams) browseName() string {
+	if len(l.Subtypes) > 0 {
+		return l.SubtypeNames()[0]
+	}
+	return l.ServiceName()
+}
+
 // Notify subscriber that no more entries will arrive. Mostly caused
 // by an expired context.
 func (l *LookupParams) done() {
+	if l.events != nil {
+		close(l.events)
+		return
+	}
 	close(l.Entries)
 }
 
+// deliver hands an entry to the subscriber. Without an events channel, added
+// and updated entries are sent on the entries channel and removals are not
+// reported.
+func (l *LookupParams) deliver(t DiscoveryEventType, e *ServiceEntry, from net.Addr) {
+	if l.events != nil {
+		l.events <- newDiscoveryEvent(t, e, from)
+	} else if t != EntryRemoved {
+		l.Entries <- e
+	}
+}
+
 func (l *LookupParams) disableProbing() {
 	l.once.Do(func() { close(l.stopProbing) })
 }
 
+// Missing flags the data a partially resolved entry lacks.
+type Missing uint8
+
+// Data a partial entry may lack, see WithPartialResults.
+const (
+	// MissingSRV is set if no SRV record was received, so the host name and
+	// port are unknown.
+	MissingSRV Missing = 1 << iota
+	// MissingAddrs is set if no address of the host was received.
+	MissingAddrs
+)
+
 // ServiceEntry represents a browse/lookup result for client API.
 // It is also used to configure service registration (server API), which is
 // used to answer multicast queries.
@@ -99,6 +215,19 @@ type ServiceEntry struct {
 	TTL      uint32   `json:"ttl"`      // TTL of the service record
 	AddrIPv4 []net.IP `json:"-"`        // Host machine IPv4 address
 	AddrIPv6 []net.IP `json:"-"`        // Host machine IPv6 address
+	Missing  Missing  `json:"missing"`  // Data lacking in partial results
+
+	// private variables populated by the resolver
+	priority uint16
+	weight   uint16
+	ifIndex  int
+	received time.Time
+	addrPref AddrPreference
+
+	// private variables of a registered service: the instance name as
+	// registered, and the number of renames after conflicts
+	baseInstance string
+	renames      int
 }
 
 // NewServiceEntry constructs a ServiceEntry.
@@ -107,3 +236,84 @@ func NewServiceEntry(instance, service, domain string) *ServiceEntry {
 		ServiceRecord: *NewServiceRecord(instance, service, domain),
 	}
 }
+
+// ServiceEntryV2 is a browse/lookup result using netip.Addr for addresses.
+// IPv6 link-local addresses carry the zone of the interface they were
+// received on. It can be converted from and to a ServiceEntry.
+type ServiceEntryV2 struct {
+	ServiceRecord
+	HostName string       `json:"hostname"` // Host machine DNS name
+	Port     uint16       `json:"port"`     // Service Port
+	Priority uint16       `json:"priority"` // SRV priority
+	Weight   uint16       `json:"weight"`   // SRV weight
+	Text     []string     `json:"text"`     // Service info served as a TXT record
+	TTL      uint32       `json:"ttl"`      // TTL of the service record
+	Expiry   time.Time    `json:"expiry"`   // Expiry of the service record, zero if unknown
+	Addrs    []netip.Addr `json:"addrs"`    // Host machine IPv4 and IPv6 addresses
+}
+
+// V2 converts the entry into a ServiceEntryV2.
+func (e *ServiceEntry) V2() *ServiceEntryV2 {
+	v2 := &ServiceEntryV2{
+		ServiceRecord: e.ServiceRecord,
+		HostName:      e.HostName,
+		Port:          uint16(e.Port),
+		Priority:      e.priority,
+		Weight:        e.weight,
+		Text:          e.Text,
+		TTL:           e.TTL,
+	}
+	if !e.received.IsZero() {
+		v2.Expiry = e.received.Add(time.Duration(e.TTL) * time.Second)
+	}
+	var zone string
+	if iface, err := net.InterfaceByIndex(e.ifIndex); err == nil {
+		zone = iface.Name
+	}
+	var v4, v6 []netip.Addr
+	for _, ip := range e.AddrIPv4 {
+		if addr, ok := netip.AddrFromSlice(ip.To4()); ok {
+			v4 = append(v4, addr)
+		}
+	}
+	for _, ip := range e.AddrIPv6 {
+		addr, ok := netip.AddrFromSlice(ip.To16())
+		if !ok {
+			continue
+		}
+		if zone != "" && (addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast()) {
+			addr = addr.WithZone(zone)
+		}
+		v6 = append(v6, addr)
+	}
+	if e.addrPref == PreferIPv6 {
+		v2.Addrs = append(v6, v4...)
+	} else {
+		v2.Addrs = append(v4, v6...)
+	}
+	return v2
+}
+
+// Legacy converts the entry into a ServiceEntry. Zones of addresses are lost.
+func (e *ServiceEntryV2) Legacy() *ServiceEntry {
+	entry := &ServiceEntry{
+		ServiceRecord: e.ServiceRecord,
+		HostName:      e.HostName,
+		Port:          int(e.Port),
+		Text:          e.Text,
+		TTL:           e.TTL,
+		priority:      e.Priority,
+		weight:        e.Weight,
+	}
+	for _, addr := range e.Addrs {
+		if addr.Is4() || addr.Is4In6() {
+			entry.AddrIPv4 = append(entry.AddrIPv4, net.IP(addr.Unmap().AsSlice()))
+		} else {
+			entry.AddrIPv6 = append(entry.AddrIPv6, net.IP(addr.AsSlice()))
+		}
+	}
+	if !e.Expiry.IsZero() {
+		entry.received = e.Expiry.Add(-time.Duration(e.TTL) * time.Second)
+	}
+	return entry
+}
@@ -0,0 +1,29 @@
+package zeroconf
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleSignals blocks until one of the given signals arrives, then shuts
+// down the server, which sends the goodbye packets before it returns. This
+// prevents stale entries in the caches of peers when the process is stopped.
+// Without signals, os.Interrupt and SIGTERM are handled. The received signal
+// is returned, so the caller can exit accordingly once HandleSignals returned:
+//
+//	server, err := zeroconf.Register(...)
+//	...
+//	zeroconf.HandleSignals(server)
+func HandleSignals(s *Server, sigs ...os.Signal) os.Signal {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	defer signal.Stop(ch)
+
+	sig := <-ch
+	s.Shutdown()
+	return sig
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+package zeroconf
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// mDNS wildcard addresses the sockets are bound to
+var (
+	listenIPv4 = net.ParseIP("224.0.0.0")
+	listenIPv6 = net.ParseIP("ff02::")
+)
+
+// writeTo4 sends a packet via the interface with the given index, or via the
+// default interface if ifIndex is 0.
+func writeTo4(conn *ipv4.PacketConn, b []byte, ifIndex int, dst net.Addr) (int, error) {
+	var cm *ipv4.ControlMessage
+	if ifIndex != 0 {
+		cm = &ipv4.ControlMessage{IfIndex: ifIndex}
+	}
+	return conn.WriteTo(b, cm, dst)
+}
+
+// writeTo6 sends a packet via the interface with the given index, or via the
+// default interface if ifIndex is 0.
+func writeTo6(conn *ipv6.PacketConn, b []byte, ifIndex int, dst net.Addr) (int, error) {
+	var cm *ipv6.ControlMessage
+	if ifIndex != 0 {
+		cm = &ipv6.ControlMessage{IfIndex: ifIndex}
+	}
+	return conn.WriteTo(b, cm, dst)
+}
+
+// releaseSocket releases the resources kept for a closed socket.
+func releaseSocket(conn interface{}) {}
@@ -0,0 +1,68 @@
+//go:build windows
+// +build windows
+
+package zeroconf
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Windows does not allow binding a socket to a multicast address, so the
+// sockets are bound to the unspecified address instead.
+var (
+	listenIPv4 = net.IPv4zero
+	listenIPv6 = net.IPv6unspecified
+)
+
+// Windows ignores control messages when writing, so the outgoing interface
+// is selected by setting the multicast interface of the socket. A lock per
+// socket keeps the interface selection and the write together.
+var multicastIfLocks sync.Map // socket -> *sync.Mutex
+
+// multicastIfLock returns the lock of the socket.
+func multicastIfLock(conn interface{}) *sync.Mutex {
+	lock, _ := multicastIfLocks.LoadOrStore(conn, new(sync.Mutex))
+	return lock.(*sync.Mutex)
+}
+
+// releaseSocket forgets the lock of a closed socket.
+func releaseSocket(conn interface{}) {
+	multicastIfLocks.Delete(conn)
+}
+
+// multicastInterface returns the interface with the given index, or nil to
+// select the default interface if ifIndex is 0 or unknown.
+func multicastInterface(ifIndex int) *net.Interface {
+	if ifIndex == 0 {
+		return nil
+	}
+	iface, err := net.InterfaceByIndex(ifIndex)
+	if err != nil {
+		return nil
+	}
+	return iface
+}
+
+// writeTo4 sends a packet via the interface with the given index, or via the
+// default interface if ifIndex is 0.
+func writeTo4(conn *ipv4.PacketConn, b []byte, ifIndex int, dst net.Addr) (int, error) {
+	lock := multicastIfLock(conn)
+	lock.Lock()
+	defer lock.Unlock()
+	conn.SetMulticastInterface(multicastInterface(ifIndex))
+	return conn.WriteTo(b, nil, dst)
+}
+
+// writeTo6 sends a packet via the interface with the given index, or via the
+// default interface if ifIndex is 0.
+func writeTo6(conn *ipv6.PacketConn, b []byte, ifIndex int, dst net.Addr) (int, error) {
+	lock := multicastIfLock(conn)
+	lock.Lock()
+	defer lock.Unlock()
+	conn.SetMulticastInterface(multicastInterface(ifIndex))
+	return conn.WriteTo(b, nil, dst)
+}
@@ -0,0 +1,100 @@
+//go:build windows
+// +build windows
+
+package zeroconf
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestListenAddrs(t *testing.T) {
+	if !listenIPv4.IsUnspecified() || !listenIPv6.IsUnspecified() {
+		t.Errorf("sockets bound to %v and %v, want the unspecified addresses", listenIPv4, listenIPv6)
+	}
+}
+
+func TestMulticastIfLockPerSocket(t *testing.T) {
+	a, b := new(ipv4.PacketConn), new(ipv4.PacketConn)
+	defer releaseSocket(a)
+	defer releaseSocket(b)
+	if multicastIfLock(a) != multicastIfLock(a) {
+		t.Error("lock of a socket changed")
+	}
+	if multicastIfLock(a) == multicastIfLock(b) {
+		t.Error("sockets share a lock")
+	}
+
+	// A locked socket does not block writes on another one
+	lock := multicastIfLock(a)
+	lock.Lock()
+	defer lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		l := multicastIfLock(b)
+		l.Lock()
+		l.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("lock of another socket blocked")
+	}
+}
+
+func TestReleaseSocket(t *testing.T) {
+	conn := new(ipv4.PacketConn)
+	multicastIfLock(conn)
+	releaseSocket(conn)
+	if _, ok := multicastIfLocks.Load(conn); ok {
+		t.Error("lock kept after release")
+	}
+}
+
+func TestMulticastInterface(t *testing.T) {
+	if iface := multicastInterface(0); iface != nil {
+		t.Errorf("interface %s selected for index 0, want the default", iface.Name)
+	}
+	if iface := multicastInterface(-1); iface != nil {
+		t.Errorf("interface %s selected for an unknown index, want the default", iface.Name)
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil || len(ifaces) == 0 {
+		t.Skip("no interfaces")
+	}
+	if iface := multicastInterface(ifaces[0].Index); iface == nil || iface.Index != ifaces[0].Index {
+		t.Errorf("interface %d not selected", ifaces[0].Index)
+	}
+}
+
+func TestWriteTo4(t *testing.T) {
+	recv, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close()
+	udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := ipv4.NewPacketConn(udpConn)
+	transport := ipv4Transport{conn}
+	defer transport.Close()
+
+	if _, err := transport.WriteTo([]byte("ping"), 0, recv.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	recv.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	n, _, err := recv.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Errorf("received %q, want %q", buf[:n], "ping")
+	}
+}
@@ -0,0 +1,87 @@
+package zeroconf
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// Maximum size of a Multicast DNS message: packets including the IP and UDP
+// headers must not exceed 9000 bytes (RFC6762 section 17). The IPv6 and UDP
+// headers take 48 bytes.
+const maxMessageSize = 9000 - 48
+
+// Maximum size of a query carrying known answers. Queries are kept within a
+// single Ethernet frame, since their size is not negotiated.
+const maxQuerySize = 1500 - 48
+
+// splitQuery splits a query whose known answers exceed the size limit into
+// several packets. The first one carries the questions; all but the last one
+// have the truncated bit set, so responders wait for the remaining known
+// answers (RFC 6762 section 7.2).
+func splitQuery(msg *dns.Msg, limit int) []*dns.Msg {
+	if msg.Len() <= limit {
+		return []*dns.Msg{msg}
+	}
+	parts := []*dns.Msg{emptyPart(msg)}
+	parts[0].Question = msg.Question
+	for _, rr := range msg.Answer {
+		part := parts[len(parts)-1]
+		part.Answer = append(part.Answer, rr)
+		if part.Len() > limit && len(part.Answer) > 1 {
+			part.Answer = part.Answer[:len(part.Answer)-1]
+			part.Truncated = true
+			part = emptyPart(msg)
+			part.Answer = []dns.RR{rr}
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// splitResponse splits a response which exceeds the size limit into several
+// responses, each of which fits into a single packet (RFC6762 section 17).
+// The answers are distributed in order; additional records are added to the
+// first response with enough room left, or left out. A single answer which
+// does not fit into a packet on its own is an error.
+func splitResponse(msg *dns.Msg, limit int) ([]*dns.Msg, error) {
+	if msg.Len() <= limit {
+		return []*dns.Msg{msg}, nil
+	}
+
+	var parts []*dns.Msg
+	part := emptyPart(msg)
+	part.Ns = msg.Ns
+	for _, rr := range msg.Answer {
+		part.Answer = append(part.Answer, rr)
+		if part.Len() > limit && len(part.Answer) > 1 {
+			part.Answer = part.Answer[:len(part.Answer)-1]
+			parts = append(parts, part)
+			part = emptyPart(msg)
+			part.Answer = []dns.RR{rr}
+		}
+		if part.Len() > limit {
+			return nil, fmt.Errorf("Record %s exceeds the maximum message size", rr.Header().Name)
+		}
+	}
+	parts = append(parts, part)
+
+	for _, rr := range msg.Extra {
+		for _, p := range parts {
+			p.Extra = append(p.Extra, rr)
+			if p.Len() <= limit {
+				break
+			}
+			p.Extra = p.Extra[:len(p.Extra)-1]
+		}
+	}
+	return parts, nil
+}
+
+// emptyPart returns a response with the header of msg and no records.
+func emptyPart(msg *dns.Msg) *dns.Msg {
+	part := new(dns.Msg)
+	part.MsgHdr = msg.MsgHdr
+	part.Compress = msg.Compress
+	return part
+}
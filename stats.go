@@ -0,0 +1,55 @@
+package zeroconf
+
+import "sync/atomic"
+
+// Stats holds the traffic counters of a server since it was started.
+type Stats struct {
+	// Queries received, counting a query continued over several packets
+	// with known answers once
+	QueriesReceived uint64
+	// Responses sent to a querier directly, including legacy unicast
+	UnicastResponses uint64
+	// Responses multicast, once per address family
+	MulticastResponses uint64
+	// Packets which could not be parsed
+	ParseErrors uint64
+	// Packets dropped because the queue of the worker pool was full
+	DroppedPackets uint64
+	// Answers not sent because they were multicast recently, by this or
+	// another responder
+	SuppressedAnswers uint64
+	// Bytes of mDNS packets received and sent
+	BytesIn  uint64
+	BytesOut uint64
+	// Name conflicts found while probing or after the announcement
+	Conflicts uint64
+}
+
+// serverStats holds the counters of a server. They are updated atomically,
+// so the struct is the first field of Server to keep it 64-bit aligned.
+type serverStats struct {
+	queries     uint64
+	unicast     uint64
+	multicast   uint64
+	parseErrors uint64
+	dropped     uint64
+	suppressed  uint64
+	bytesIn     uint64
+	bytesOut    uint64
+	conflicts   uint64
+}
+
+// Stats returns a snapshot of the server's traffic counters.
+func (s *Server) Stats() Stats {
+	return Stats{
+		QueriesReceived:    atomic.LoadUint64(&s.stats.queries),
+		UnicastResponses:   atomic.LoadUint64(&s.stats.unicast),
+		MulticastResponses: atomic.LoadUint64(&s.stats.multicast),
+		ParseErrors:        atomic.LoadUint64(&s.stats.parseErrors),
+		DroppedPackets:     atomic.LoadUint64(&s.stats.dropped),
+		SuppressedAnswers:  atomic.LoadUint64(&s.stats.suppressed),
+		BytesIn:            atomic.LoadUint64(&s.stats.bytesIn),
+		BytesOut:           atomic.LoadUint64(&s.stats.bytesOut),
+		Conflicts:          atomic.LoadUint64(&s.stats.conflicts),
+	}
+}
@@ -0,0 +1,178 @@
+package zeroconf
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Transport is the packet layer of a server for one address family. By
+// default, the server uses UDP sockets bound to the mDNS port. A custom
+// transport allows running the server on an in-memory network for tests,
+// simulating packet loss, or embedding it in a userspace network stack.
+type Transport interface {
+	// ReadFrom reads a packet into b. It returns the index of the interface
+	// the packet was received on and its IP TTL or hop limit; both are 0 if
+	// unknown. The source should be a *net.UDPAddr. Responses to sources of
+	// other types are multicast, since they cannot be sent by unicast.
+	ReadFrom(b []byte) (n, ifIndex, ttl int, src net.Addr, err error)
+	// WriteTo sends a packet to dst via the interface with the given index,
+	// or via the default interface if ifIndex is 0.
+	WriteTo(b []byte, ifIndex int, dst net.Addr) (int, error)
+	// JoinGroup joins the multicast group on the interface.
+	JoinGroup(iface *net.Interface, group net.Addr) error
+	// LeaveGroup leaves the multicast group on the interface.
+	LeaveGroup(iface *net.Interface, group net.Addr) error
+	// Close closes the transport; pending reads return an error.
+	Close() error
+}
+
+// WithTransport makes the server use the given transports instead of
+// opening UDP sockets. Either of them may be nil to disable the address
+// family. The server closes the transports on shutdown. Received packets are
+// not checked against off-link spoofing, see WithoutSourceCheck, since the
+// transport may not be attached to a local link.
+func WithTransport(ipv4, ipv6 Transport) ServerOption {
+	return func(o *serverOpts) {
+		o.transport4, o.transport6 = ipv4, ipv6
+		o.customTransport = true
+	}
+}
+
+// ipv4Transport is the Transport of an IPv4 UDP socket.
+type ipv4Transport struct {
+	conn *ipv4.PacketConn
+}
+
+func (t ipv4Transport) ReadFrom(b []byte) (n, ifIndex, ttl int, src net.Addr, err error) {
+	n, cm, src, err := t.conn.ReadFrom(b)
+	if cm != nil {
+		ifIndex, ttl = cm.IfIndex, cm.TTL
+	}
+	return n, ifIndex, ttl, src, err
+}
+
+func (t ipv4Transport) WriteTo(b []byte, ifIndex int, dst net.Addr) (int, error) {
+	return writeTo4(t.conn, b, ifIndex, dst)
+}
+
+func (t ipv4Transport) JoinGroup(iface *net.Interface, group net.Addr) error {
+	return t.conn.JoinGroup(iface, group)
+}
+
+func (t ipv4Transport) LeaveGroup(iface *net.Interface, group net.Addr) error {
+	return t.conn.LeaveGroup(iface, group)
+}
+
+func (t ipv4Transport) Close() error {
+	releaseSocket(t.conn)
+	return t.conn.Close()
+}
+
+// ipv6Transport is the Transport of an IPv6 UDP socket.
+type ipv6Transport struct {
+	conn *ipv6.PacketConn
+}
+
+func (t ipv6Transport) ReadFrom(b []byte) (n, ifIndex, ttl int, src net.Addr, err error) {
+	n, cm, src, err := t.conn.ReadFrom(b)
+	if cm != nil {
+		ifIndex, ttl = cm.IfIndex, cm.HopLimit
+	}
+	return n, ifIndex, ttl, src, err
+}
+
+func (t ipv6Transport) WriteTo(b []byte, ifIndex int, dst net.Addr) (int, error) {
+	return writeTo6(t.conn, b, ifIndex, dst)
+}
+
+func (t ipv6Transport) JoinGroup(iface *net.Interface, group net.Addr) error {
+	return t.conn.JoinGroup(iface, group)
+}
+
+func (t ipv6Transport) LeaveGroup(iface *net.Interface, group net.Addr) error {
+	return t.conn.LeaveGroup(iface, group)
+}
+
+func (t ipv6Transport) Close() error {
+	releaseSocket(t.conn)
+	return t.conn.Close()
+}
+
+// reopenTransport wraps the transport of a socket which is opened again when
+// it breaks, see WithSocketReopen.
+type reopenTransport struct {
+	lock   sync.RWMutex
+	conn   Transport
+	open   func() (Transport, error)
+	closed bool
+}
+
+func (t *reopenTransport) current() Transport {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.conn
+}
+
+func (t *reopenTransport) ReadFrom(b []byte) (n, ifIndex, ttl int, src net.Addr, err error) {
+	return t.current().ReadFrom(b)
+}
+
+func (t *reopenTransport) WriteTo(b []byte, ifIndex int, dst net.Addr) (int, error) {
+	return t.current().WriteTo(b, ifIndex, dst)
+}
+
+func (t *reopenTransport) JoinGroup(iface *net.Interface, group net.Addr) error {
+	return t.current().JoinGroup(iface, group)
+}
+
+func (t *reopenTransport) LeaveGroup(iface *net.Interface, group net.Addr) error {
+	return t.current().LeaveGroup(iface, group)
+}
+
+func (t *reopenTransport) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.closed = true
+	return t.conn.Close()
+}
+
+// reopen replaces the socket with a newly opened one.
+func (t *reopenTransport) reopen() error {
+	conn, err := t.open()
+	if err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		conn.Close()
+		return net.ErrClosed
+	}
+	t.conn.Close()
+	t.conn = conn
+	return nil
+}
+
+// isFatalReadError reports whether a read error means the socket is closed or
+// broken, so reading again would fail forever.
+func isFatalReadError(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.EBADF)
+}
+
+// nextReadBackoff returns the time to wait after a failed read, doubling the
+// previous wait up to readBackoffMax.
+func nextReadBackoff(prev time.Duration) time.Duration {
+	if prev < readBackoffMin {
+		return readBackoffMin
+	}
+	if prev *= 2; prev > readBackoffMax {
+		return readBackoffMax
+	}
+	return prev
+}
@@ -0,0 +1,169 @@
+package zeroconf
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// memAddr is the address of a peer on an in-memory network.
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+type memPacket struct {
+	data []byte
+	from net.Addr
+}
+
+// memTransport is an in-memory Transport: packets sent to the server are
+// queued with deliver, packets sent by the server are received from sent.
+// Transports joined to a memNetwork also receive each other's packets.
+type memTransport struct {
+	in     chan memPacket
+	sent   chan []byte
+	closed chan struct{}
+	once   sync.Once
+
+	network *memNetwork
+	addr    memAddr
+}
+
+func newMemTransport() *memTransport {
+	return &memTransport{
+		in:     make(chan memPacket, 64),
+		sent:   make(chan []byte, 64),
+		closed: make(chan struct{}),
+	}
+}
+
+// memNetwork links memTransports like hosts on one multicast link.
+type memNetwork struct {
+	lock       sync.Mutex
+	transports []*memTransport
+}
+
+// join returns a transport on the network sending from the given address.
+func (n *memNetwork) join(addr memAddr) *memTransport {
+	t := newMemTransport()
+	t.network, t.addr = n, addr
+	n.lock.Lock()
+	n.transports = append(n.transports, t)
+	n.lock.Unlock()
+	return t
+}
+
+// send delivers a packet to all other transports on the network.
+func (n *memNetwork) send(b []byte, from *memTransport) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for _, t := range n.transports {
+		if t == from {
+			continue
+		}
+		select {
+		case t.in <- memPacket{append([]byte(nil), b...), from.addr}:
+		default:
+		}
+	}
+}
+
+func (t *memTransport) deliver(msg *dns.Msg, from net.Addr) error {
+	buf, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	t.in <- memPacket{buf, from}
+	return nil
+}
+
+func (t *memTransport) ReadFrom(b []byte) (n, ifIndex, ttl int, src net.Addr, err error) {
+	select {
+	case p := <-t.in:
+		return copy(b, p.data), 0, 0, p.from, nil
+	case <-t.closed:
+		return 0, 0, 0, nil, net.ErrClosed
+	}
+}
+
+func (t *memTransport) WriteTo(b []byte, ifIndex int, dst net.Addr) (int, error) {
+	if t.network != nil {
+		t.network.send(b, t)
+	}
+	select {
+	case t.sent <- append([]byte(nil), b...):
+	default:
+	}
+	return len(b), nil
+}
+
+func (t *memTransport) JoinGroup(iface *net.Interface, group net.Addr) error  { return nil }
+func (t *memTransport) LeaveGroup(iface *net.Interface, group net.Addr) error { return nil }
+
+func (t *memTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+// awaitAnswer reads the packets sent on the transport until one answers with
+// a PTR record pointing to instance.
+func (t *memTransport) awaitAnswer(instance string, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case buf := <-t.sent:
+			msg := new(dns.Msg)
+			if err := msg.Unpack(buf); err != nil || !msg.Response {
+				continue
+			}
+			for _, rr := range msg.Answer {
+				if ptr, ok := rr.(*dns.PTR); ok && ptr.Ptr == instance {
+					return true
+				}
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func TestMemTransport(t *testing.T) {
+	transport := newMemTransport()
+	s, err := Register("test", "_test._tcp", "local.", 8080, nil, nil, 0,
+		WithTransport(transport, nil), WithIPs(net.ParseIP("192.0.2.1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	select {
+	case <-s.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("service not announced")
+	}
+	// Drain the announcements
+	for len(transport.sent) > 0 {
+		<-transport.sent
+	}
+
+	instance := "test._test._tcp.local."
+	sources := []net.Addr{
+		memAddr("peer"),
+		&net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 5353},
+	}
+	for _, from := range sources {
+		query := new(dns.Msg)
+		query.SetQuestion("_test._tcp.local.", dns.TypePTR)
+		query.Id = 0
+		if err := transport.deliver(query, from); err != nil {
+			t.Fatal(err)
+		}
+		if !transport.awaitAnswer(instance, 5*time.Second) {
+			t.Errorf("no answer to query from %v", from)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package zeroconf
+
+import "strings"
+
+// splitTXT splits a TXT string into key and value. A string without "=" is a
+// boolean attribute with an empty value.
+func splitTXT(s string) (key, value string) {
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// TXT returns the value of the given key from the TXT record. Keys are
+// compared case-insensitively as required by RFC 6763 section 6.4. For boolean
+// attributes without "=", an empty value is returned. If a key is present
+// multiple times, only the first occurrence counts.
+func (e *ServiceEntry) TXT(key string) (string, bool) {
+	for _, s := range e.Text {
+		k, v := splitTXT(s)
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// SetTXT sets the value of the given key in the TXT record, replacing an
+// existing value in place or appending the key otherwise. Further occurrences
+// of the key are removed. Keys and strings are validated like by TXTRecord;
+// the record is left unchanged if they are invalid.
+func (e *ServiceEntry) SetTXT(key, value string) error {
+	kv := key + "=" + value
+	if err := validateTXTString(key, kv); err != nil {
+		return err
+	}
+	text := make([]string, 0, len(e.Text)+1)
+	found := false
+	for _, s := range e.Text {
+		k, _ := splitTXT(s)
+		if !strings.EqualFold(k, key) {
+			text = append(text, s)
+			continue
+		}
+		if !found {
+			text = append(text, kv)
+			found = true
+		}
+	}
+	if !found {
+		text = append(text, kv)
+	}
+	e.Text = text
+	return nil
+}
+
+// DeleteTXT removes all occurrences of the given key from the TXT record.
+func (e *ServiceEntry) DeleteTXT(key string) {
+	text := make([]string, 0, len(e.Text))
+	for _, s := range e.Text {
+		if k, _ := splitTXT(s); !strings.EqualFold(k, key) {
+			text = append(text, s)
+		}
+	}
+	e.Text = text
+}
@@ -0,0 +1,181 @@
+package zeroconf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Limits of TXT records (RFC 6763 section 6)
+const (
+	// Maximum length of a single key/value string
+	maxTXTStringLength = 255
+	// Recommended maximum length of a key
+	recommendedTXTKeyLength = 9
+	// Recommended maximum total size of a TXT record
+	recommendedTXTSize = 200
+	// Total size above which a TXT record is not recommended, as it is
+	// likely to not fit into a single packet together with other records
+	maxTXTSize = 1300
+)
+
+// TXTRecord builds the strings of a TXT record while enforcing the
+// constraints of RFC 6763 section 6. Keys are case-insensitive; the order in
+// which keys were set is kept. Use Strings to fill ServiceEntry.Text or to
+// pass the record to Server.SetText.
+type TXTRecord struct {
+	text []string
+}
+
+// NewTXTRecord creates a TXT record from existing key/value strings, e.g.
+// ServiceEntry.Text. Only the first occurrence of a key is kept.
+func NewTXTRecord(text ...string) (*TXTRecord, error) {
+	r := new(TXTRecord)
+	for _, s := range text {
+		if s == "" {
+			continue
+		}
+		key, value := splitTXT(s)
+		if _, ok := r.Get(key); ok {
+			continue
+		}
+		var err error
+		if strings.IndexByte(s, '=') < 0 {
+			err = r.SetFlag(key)
+		} else {
+			err = r.Set(key, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// TXTRecord parses the TXT record of the entry.
+func (e *ServiceEntry) TXTRecord() (*TXTRecord, error) {
+	return NewTXTRecord(e.Text...)
+}
+
+// SetTXTRecord updates and announces the TXT record like SetText.
+func (s *Server) SetTXTRecord(r *TXTRecord) {
+	s.SetText(r.Strings())
+}
+
+// Set sets the value of the given key, replacing an existing value in place.
+func (r *TXTRecord) Set(key, value string) error {
+	return r.set(key, key+"="+value)
+}
+
+// SetFlag sets a boolean attribute, i.e. the key without any value.
+func (r *TXTRecord) SetFlag(key string) error {
+	return r.set(key, key)
+}
+
+func (r *TXTRecord) set(key, s string) error {
+	if err := validateTXTString(key, s); err != nil {
+		return err
+	}
+	if i := r.index(key); i >= 0 {
+		r.text[i] = s
+	} else {
+		r.text = append(r.text, s)
+	}
+	return nil
+}
+
+// Get returns the value of the given key. For boolean attributes, an empty
+// value is returned.
+func (r *TXTRecord) Get(key string) (string, bool) {
+	if i := r.index(key); i >= 0 {
+		_, value := splitTXT(r.text[i])
+		return value, true
+	}
+	return "", false
+}
+
+// Delete removes the given key.
+func (r *TXTRecord) Delete(key string) {
+	if i := r.index(key); i >= 0 {
+		r.text = append(r.text[:i], r.text[i+1:]...)
+	}
+}
+
+// Strings returns the key/value strings in the order the keys were set.
+func (r *TXTRecord) Strings() []string {
+	return append([]string(nil), r.text...)
+}
+
+// SortedStrings returns the key/value strings sorted by key.
+func (r *TXTRecord) SortedStrings() []string {
+	text := r.Strings()
+	sort.SliceStable(text, func(i, j int) bool {
+		ki, _ := splitTXT(text[i])
+		kj, _ := splitTXT(text[j])
+		return strings.ToLower(ki) < strings.ToLower(kj)
+	})
+	return text
+}
+
+// Size returns the size of the TXT record data on the wire.
+func (r *TXTRecord) Size() int {
+	size := 0
+	for _, s := range r.text {
+		size += 1 + len(s)
+	}
+	return size
+}
+
+// Warnings lists the recommendations of RFC 6763 section 6 the record does
+// not follow: keys should not exceed nine characters, and the record should
+// stay below 200 bytes, and must stay below 1300 bytes to be reliable.
+func (r *TXTRecord) Warnings() []string {
+	var warnings []string
+	for _, s := range r.text {
+		if key, _ := splitTXT(s); len(key) > recommendedTXTKeyLength {
+			warnings = append(warnings, fmt.Sprintf("TXT key %s is longer than %d characters", key, recommendedTXTKeyLength))
+		}
+	}
+	switch size := r.Size(); {
+	case size > maxTXTSize:
+		warnings = append(warnings, fmt.Sprintf("TXT record size of %d bytes exceeds %d bytes", size, maxTXTSize))
+	case size > recommendedTXTSize:
+		warnings = append(warnings, fmt.Sprintf("TXT record size of %d bytes exceeds the recommended %d bytes", size, recommendedTXTSize))
+	}
+	return warnings
+}
+
+func (r *TXTRecord) index(key string) int {
+	for i, s := range r.text {
+		if k, _ := splitTXT(s); strings.EqualFold(k, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateTXTString checks the key of a key/value string and that the string
+// fits into a TXT record.
+func validateTXTString(key, s string) error {
+	if err := validateTXTKey(key); err != nil {
+		return err
+	}
+	if len(s) > maxTXTStringLength {
+		return fmt.Errorf("TXT string for key %s exceeds %d bytes", key, maxTXTStringLength)
+	}
+	return nil
+}
+
+// validateTXTKey checks that a key is not empty and consists of printable
+// US-ASCII characters other than "=" (RFC 6763 section 6.4).
+func validateTXTKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("Missing TXT key")
+	}
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c < 0x20 || c > 0x7e || c == '=' {
+			return fmt.Errorf("Invalid character %q in TXT key %s", c, key)
+		}
+	}
+	return nil
+}
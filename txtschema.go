@@ -0,0 +1,169 @@
+package zeroconf
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// txtField describes a struct field mapped to a TXT key.
+type txtField struct {
+	index     int
+	key       string
+	omitEmpty bool
+	required  bool
+}
+
+// txtFields returns the fields of a struct type tagged with `txt:"key"`.
+// Tag options are "omitempty" and "required".
+func txtFields(t reflect.Type) []txtField {
+	var fields []txtField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("txt")
+		if !ok || tag == "-" || f.PkgPath != "" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		field := txtField{index: i, key: parts[0]}
+		if field.key == "" {
+			field.key = f.Name
+		}
+		for _, opt := range parts[1:] {
+			switch opt {
+			case "omitempty":
+				field.omitEmpty = true
+			case "required":
+				field.required = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// structValue dereferences v and panics if it is not a struct.
+func structValue(v interface{}, fn string) reflect.Value {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("zeroconf: %s of non-struct type %T", fn, v))
+	}
+	return rv
+}
+
+// MarshalTXT encodes the tagged fields of a struct as TXT strings, in field
+// order. Fields are tagged with the TXT key and options, e.g. `txt:"md"` or
+// `txt:"ff,omitempty"`. Supported field types are strings, booleans (encoded
+// as "1" or "0"), integers, unsigned integers, floats and byte slices.
+//
+// MarshalTXT panics if v is not a struct or a pointer to a struct, or if a
+// tagged field has an unsupported type.
+func MarshalTXT(v interface{}) []string {
+	rv := structValue(v, "MarshalTXT")
+	var text []string
+	for _, f := range txtFields(rv.Type()) {
+		fv := rv.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		text = append(text, f.key+"="+formatTXTValue(fv))
+	}
+	return text
+}
+
+func formatTXTValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		if v.Bool() {
+			return "1"
+		}
+		return "0"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+	}
+	panic(fmt.Sprintf("zeroconf: unsupported TXT field type %s", v.Type()))
+}
+
+// UnmarshalTXT decodes TXT strings into the tagged fields of the struct v
+// points to. Keys are matched case-insensitively and only their first
+// occurrence counts (RFC 6763 section 6.4). A key without value sets a boolean
+// field to true. Keys without a field are ignored; a missing key of a field
+// tagged "required" or a value that does not convert to the field type is an
+// error.
+func UnmarshalTXT(text []string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("UnmarshalTXT requires a non-nil pointer, got %T", v)
+	}
+	rv = structValue(v, "UnmarshalTXT")
+	entry := ServiceEntry{Text: text}
+	for _, f := range txtFields(rv.Type()) {
+		value, ok := entry.TXT(f.key)
+		if !ok {
+			if f.required {
+				return fmt.Errorf("Missing TXT key %q", f.key)
+			}
+			continue
+		}
+		if err := parseTXTValue(rv.Field(f.index), value); err != nil {
+			return fmt.Errorf("Invalid value for TXT key %q: %v", f.key, err)
+		}
+	}
+	return nil
+}
+
+func parseTXTValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		switch strings.ToLower(s) {
+		case "", "1", "t", "true", "y", "yes":
+			v.SetBool(true)
+		case "0", "f", "false", "n", "no":
+			v.SetBool(false)
+		default:
+			return fmt.Errorf("not a boolean: %q", s)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		v.SetBytes([]byte(s))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package zeroconf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Default location of the system resolver configuration
+const resolvConf = "/etc/resolv.conf"
+
+// isLocalDomain reports whether services of the domain are resolved via
+// multicast DNS.
+func isLocalDomain(domain string) bool {
+	return strings.EqualFold(trimDot(domain), "local")
+}
+
+// unicastServers returns the DNS servers used for wide-area DNS-SD.
+func (c *client) unicastServers() ([]string, error) {
+	if len(c.servers) > 0 {
+		return c.servers, nil
+	}
+	conf, err := dns.ClientConfigFromFile(resolvConf)
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]string, 0, len(conf.Servers))
+	for _, server := range conf.Servers {
+		servers = append(servers, net.JoinHostPort(server, conf.Port))
+	}
+	return servers, nil
+}
+
+// exchangeUnicast sends a conventional DNS query to the first server that
+// answers.
+func (c *client) exchangeUnicast(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	servers, err := c.unicastServers()
+	if err != nil {
+		return nil, err
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.RecursionDesired = true
+
+	var client dns.Client
+	err = fmt.Errorf("no DNS server configured")
+	for _, server := range servers {
+		var resp *dns.Msg
+		resp, _, err = client.ExchangeContext(ctx, m, server)
+		if err == nil && resp.Rcode != dns.RcodeServerFailure {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+// resolveUnicast browses or looks up a service in a wide-area DNS-SD domain
+// (RFC 6763) with conventional unicast DNS queries. Resolved entries are
+// delivered like multicast results; the entries channel is closed when the
+// context expires.
+func (c *client) resolveUnicast(ctx context.Context, params *LookupParams) {
+	defer params.done()
+
+	var instances []string
+	if params.Instance != "" {
+		instances = []string{params.ServiceInstanceName()}
+	} else if resp, err := c.exchangeUnicast(ctx, params.browseName(), dns.TypePTR); err == nil {
+		for _, rr := range resp.Answer {
+			if ptr, ok := rr.(*dns.PTR); ok {
+				instances = append(instances, ptr.Ptr)
+			}
+		}
+	}
+
+	var delivered int
+	for _, name := range instances {
+		entry := c.resolveUnicastInstance(ctx, params, name)
+		if entry == nil || (c.hideOwn && isOwnService(entry)) {
+			continue
+		}
+		select {
+		case params.Entries <- entry:
+		case params.events <- newDiscoveryEvent(EntryAdded, entry, nil):
+		case <-ctx.Done():
+			return
+		}
+		c.events.add(EntryAdded, entry, nil)
+		delivered++
+		if params.maxResults > 0 && delivered >= params.maxResults {
+			return
+		}
+	}
+	<-ctx.Done()
+}
+
+// resolveUnicastInstance queries the SRV, TXT and address records of a
+// service instance. It returns nil if the instance has no SRV record.
+func (c *client) resolveUnicastInstance(ctx context.Context, params *LookupParams, name string) *ServiceEntry {
+	resp, err := c.exchangeUnicast(ctx, name, dns.TypeSRV)
+	if err != nil {
+		return nil
+	}
+	entry := NewServiceEntry(
+		trimDot(strings.TrimSuffix(name, params.ServiceName())),
+		params.Service,
+		params.Domain)
+	entry.addrPref = c.addrPref
+	for _, rr := range resp.Answer {
+		if srv, ok := rr.(*dns.SRV); ok {
+			entry.HostName = srv.Target
+			entry.Port = int(srv.Port)
+			entry.priority = srv.Priority
+			entry.weight = srv.Weight
+			entry.TTL = srv.Hdr.Ttl
+			break
+		}
+	}
+	if entry.HostName == "" {
+		return nil
+	}
+	if resp, err := c.exchangeUnicast(ctx, name, dns.TypeTXT); err == nil {
+		for _, rr := range resp.Answer {
+			if txt, ok := rr.(*dns.TXT); ok {
+				entry.Text = append(entry.Text, txt.Txt...)
+			}
+		}
+	}
+	if c.addrPref.wantsIPv4() {
+		if resp, err := c.exchangeUnicast(ctx, entry.HostName, dns.TypeA); err == nil {
+			for _, rr := range resp.Answer {
+				if a, ok := rr.(*dns.A); ok {
+					entry.AddrIPv4 = append(entry.AddrIPv4, a.A)
+				}
+			}
+		}
+	}
+	if c.addrPref.wantsIPv6() {
+		if resp, err := c.exchangeUnicast(ctx, entry.HostName, dns.TypeAAAA); err == nil {
+			for _, rr := range resp.Answer {
+				if aaaa, ok := rr.(*dns.AAAA); ok {
+					entry.AddrIPv6 = append(entry.AddrIPv6, aaaa.AAAA)
+				}
+			}
+		}
+	}
+	return entry
+}
@@ -0,0 +1,58 @@
+package zeroconf
+
+import (
+	"net"
+	"sync"
+)
+
+// Queue size per worker if none is configured
+const defaultQueuePerWorker = 16
+
+// packet is a received packet queued for a worker.
+type packet struct {
+	buf     []byte
+	ifIndex int
+	from    net.Addr
+}
+
+// workerPool handles received packets on a bounded number of goroutines, so
+// reading continues while responses are composed and sent. Packets arriving
+// while the queue is full are dropped.
+type workerPool struct {
+	queue chan packet
+}
+
+// startWorkers starts the workers, which run until stop is closed. The wait
+// group tracks the running workers.
+func startWorkers(workers, queueSize int, stop <-chan struct{}, wg *sync.WaitGroup, handle func(packet)) *workerPool {
+	if queueSize <= 0 {
+		queueSize = workers * defaultQueuePerWorker
+	}
+	p := &workerPool{queue: make(chan packet, queueSize)}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case pk := <-p.queue:
+					handle(pk)
+				}
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues a copy of the packet. It reports false if the queue is full
+// and the packet was dropped.
+func (p *workerPool) submit(buf []byte, ifIndex int, from net.Addr) bool {
+	select {
+	case p.queue <- packet{append([]byte(nil), buf...), ifIndex, from}:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,131 @@
+package wsdiscovery
+
+import (
+	"context"
+	"encoding/xml"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// Browse sends a WS-Discovery probe for the given types (all devices if none
+// are given), repeating it as multicast messages may be lost, and reports
+// every distinct responding endpoint as a synthetic service entry until the
+// context is done. The entries channel is closed afterwards.
+//
+// Endpoints publishing a DNS-SD service type (see Publish) are reported with
+// that type, all others with ServiceType. The instance name is the endpoint
+// address, the TXT record holds the "types", "scopes" and "xaddrs" of the
+// endpoint, and the host, port and addresses are taken from the first
+// transport address.
+func Browse(ctx context.Context, entries chan<- *zeroconf.ServiceEntry, types ...string) error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return err
+	}
+
+	var body strings.Builder
+	body.WriteString("<wsd:Probe>")
+	if len(types) > 0 {
+		body.WriteString("<wsd:Types>" + escape(strings.Join(types, " ")) + "</wsd:Types>")
+	}
+	body.WriteString("</wsd:Probe>")
+	probe := message(actionProbe, toDiscovery, "", "", body.String())
+	if _, err := conn.WriteTo(probe, multicastAddr); err != nil {
+		conn.Close()
+		return err
+	}
+
+	go func() {
+		// Repeat the probe with the same message ID, as multicast messages
+		// may be lost (SOAP-over-UDP, appendix I)
+		for _, delay := range repeatDelays() {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-time.After(delay):
+			}
+			conn.WriteTo(probe, multicastAddr)
+		}
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		defer close(entries)
+		seen := make(map[string]bool)
+		buf := make([]byte, 65536)
+		for {
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := conn.ReadFrom(buf)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				continue
+			}
+			var env envelope
+			if err := xml.Unmarshal(buf[:n], &env); err != nil {
+				continue
+			}
+			if env.Header.Action != actionProbeMatches || env.Body.ProbeMatches == nil {
+				continue
+			}
+			for _, match := range env.Body.ProbeMatches.ProbeMatch {
+				if match.Address == "" || seen[match.Address] {
+					continue
+				}
+				seen[match.Address] = true
+				select {
+				case entries <- entryFor(match):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// entryFor converts a WS-Discovery endpoint into a service entry.
+func entryFor(t target) *zeroconf.ServiceEntry {
+	service := ServiceType
+	for _, typ := range localTypes(t.Types) {
+		if strings.HasPrefix(typ, "_") {
+			service = typ
+			break
+		}
+	}
+	entry := zeroconf.NewServiceEntry(t.Address, service, "local.")
+	entry.Text = []string{
+		"types=" + t.Types,
+		"scopes=" + t.Scopes,
+		"xaddrs=" + t.XAddrs,
+	}
+	for _, xaddr := range strings.Fields(t.XAddrs) {
+		u, err := url.Parse(xaddr)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		entry.HostName = u.Hostname()
+		entry.Port, _ = strconv.Atoi(u.Port())
+		if entry.Port == 0 && u.Scheme == "https" {
+			entry.Port = 443
+		} else if entry.Port == 0 {
+			entry.Port = 80
+		}
+		if ip := net.ParseIP(u.Hostname()); ip != nil {
+			if ip.To4() != nil {
+				entry.AddrIPv4 = append(entry.AddrIPv4, ip)
+			} else {
+				entry.AddrIPv6 = append(entry.AddrIPv6, ip)
+			}
+		}
+		break
+	}
+	return entry
+}
@@ -0,0 +1,162 @@
+package wsdiscovery
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"golang.org/x/net/ipv4"
+)
+
+// Publisher mirrors a registered service into WS-Discovery.
+type Publisher struct {
+	conn   *ipv4.PacketConn
+	target target
+	types  []string
+	// AppSequence of the sent messages: the instance ID changes with every
+	// Publish, the message number is incremented for every message
+	instanceID    uint64
+	messageNumber uint64
+
+	closeOnce sync.Once
+	done      sync.WaitGroup
+}
+
+// Publish announces the service of the given server via WS-Discovery and
+// answers matching probes until Close is called. The service type is
+// published as a type in the DNS-SD namespace, the service's addresses and
+// port as HTTP transport addresses.
+func Publish(server *zeroconf.Server, ifaces []net.Interface) (*Publisher, error) {
+	entry := server.Service()
+	if entry == nil {
+		return nil, fmt.Errorf("wsdiscovery: server has no service")
+	}
+
+	// Other WS-Discovery agents on the host may have bound the port already
+	lc := net.ListenConfig{Control: reuseControl}
+	udpConn, err := lc.ListenPacket(context.Background(), "udp4", fmt.Sprintf(":%d", multicastAddr.Port))
+	if err != nil {
+		return nil, err
+	}
+	conn := ipv4.NewPacketConn(udpConn)
+	if len(ifaces) == 0 {
+		ifaces, _ = net.Interfaces()
+	}
+	var joined int
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if err := conn.JoinGroup(&iface, &net.UDPAddr{IP: multicastAddr.IP}); err == nil {
+			joined++
+		}
+	}
+	if joined == 0 {
+		conn.Close()
+		return nil, fmt.Errorf("wsdiscovery: failed to join any of these interfaces: %v", ifaces)
+	}
+
+	serviceType := strings.Trim(entry.Service, ".")
+	p := &Publisher{
+		conn: conn,
+		target: target{
+			Address: "urn:uuid:" + endpointUUID(entry.ServiceInstanceName()),
+			Types:   "dn:" + serviceType,
+			XAddrs:  strings.Join(transportAddrs(entry), " "),
+		},
+		types:      []string{serviceType},
+		instanceID: uint64(time.Now().Unix()),
+	}
+
+	p.send(message(actionHello, toDiscovery, "", p.sequence(), "<wsd:Hello>"+targetBody(p.target)+"</wsd:Hello>"), multicastAddr)
+	p.done.Add(1)
+	go p.recv()
+	return p, nil
+}
+
+// Close sends a Bye message and stops answering probes.
+func (p *Publisher) Close() {
+	p.closeOnce.Do(func() {
+		bye := target{Address: p.target.Address}
+		p.send(message(actionBye, toDiscovery, "", p.sequence(), "<wsd:Bye>"+targetBody(bye)+"</wsd:Bye>"), multicastAddr)
+		p.conn.Close()
+		p.done.Wait()
+	})
+}
+
+// sequence returns the AppSequence header element of the next message.
+func (p *Publisher) sequence() string {
+	return appSequence(p.instanceID, atomic.AddUint64(&p.messageNumber, 1))
+}
+
+func (p *Publisher) send(msg []byte, to net.Addr) error {
+	_, err := p.conn.WriteTo(msg, nil, to)
+	return err
+}
+
+// recv is a long running routine answering probes.
+func (p *Publisher) recv() {
+	defer p.done.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, _, from, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			// Connection closed
+			return
+		}
+		var env envelope
+		if err := xml.Unmarshal(buf[:n], &env); err != nil {
+			continue
+		}
+		if env.Header.Action != actionProbe || env.Body.Probe == nil || !p.matches(env.Body.Probe) {
+			continue
+		}
+		body := "<wsd:ProbeMatches><wsd:ProbeMatch>" + targetBody(p.target) + "</wsd:ProbeMatch></wsd:ProbeMatches>"
+		p.send(message(actionProbeMatches, toAnonymous, env.Header.MessageID, p.sequence(), body), from)
+	}
+}
+
+// matches reports whether a probe asks for the published service. Probes
+// without types match every endpoint; scoped probes are not answered.
+func (p *Publisher) matches(pr *probe) bool {
+	if strings.TrimSpace(pr.Scopes) != "" {
+		return false
+	}
+	types := localTypes(pr.Types)
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		for _, own := range p.types {
+			if strings.EqualFold(t, own) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// transportAddrs returns HTTP transport addresses for the entry's addresses,
+// or for the addresses of this host if the entry has none.
+func transportAddrs(entry *zeroconf.ServiceEntry) []string {
+	ips := entry.AddrIPv4
+	if len(ips) == 0 {
+		addrs, _ := net.InterfaceAddrs()
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+	var xaddrs []string
+	for _, ip := range ips {
+		xaddrs = append(xaddrs, fmt.Sprintf("http://%s/", net.JoinHostPort(ip.String(), fmt.Sprint(entry.Port))))
+	}
+	return xaddrs
+}
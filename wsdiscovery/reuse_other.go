@@ -0,0 +1,9 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package wsdiscovery
+
+import "syscall"
+
+// reuseControl is nil, as socket options are not supported on this platform.
+var reuseControl func(network, address string, c syscall.RawConn) error
@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package wsdiscovery
+
+import "syscall"
+
+// reuseControl is a socket control function which allows other WS-Discovery
+// agents on the host, like the Function Discovery services, to bind port 3702
+// as well.
+func reuseControl(network, address string, c syscall.RawConn) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+// Package wsdiscovery bridges DNS-SD services and WS-Discovery (SOAP-over-UDP
+// on port 3702), as used by ONVIF devices and Windows network discovery.
+//
+// A Publisher mirrors a registered zeroconf service into WS-Discovery Hello,
+// Bye and ProbeMatch messages. Browse sends a WS-Discovery Probe and reports
+// the matching devices as synthetic zeroconf service entries.
+//
+// Only IPv4 is supported by now.
+package wsdiscovery
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	mrand "math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// ServiceType is the service type of entries for WS-Discovery devices whose
+	// types do not map to a DNS-SD service type.
+	ServiceType = "_wsdiscovery._udp"
+
+	// Namespace of WS-Discovery types mapped from DNS-SD service types
+	dnssdNamespace = "http://www.dns-sd.org/wsdiscovery"
+
+	actionHello        = "http://schemas.xmlsoap.org/ws/2005/04/discovery/Hello"
+	actionBye          = "http://schemas.xmlsoap.org/ws/2005/04/discovery/Bye"
+	actionProbe        = "http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe"
+	actionProbeMatches = "http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches"
+
+	toDiscovery = "urn:schemas-xmlsoap-org:ws:2005:04:discovery"
+	toAnonymous = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+
+	// Retransmission of multicast messages (SOAP-over-UDP, appendix I): a
+	// message is repeated after a random delay between udpMinDelay and
+	// udpMaxDelay, which doubles for every repetition up to udpUpperDelay.
+	multicastUDPRepeat = 2
+	udpMinDelay        = 50 * time.Millisecond
+	udpMaxDelay        = 250 * time.Millisecond
+	udpUpperDelay      = 500 * time.Millisecond
+)
+
+var (
+	// WS-Discovery multicast endpoint
+	multicastAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 3702}
+)
+
+// envelope is the subset of a SOAP envelope used by WS-Discovery.
+type envelope struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Header  struct {
+		Action    string `xml:"Action"`
+		MessageID string `xml:"MessageID"`
+		RelatesTo string `xml:"RelatesTo"`
+	} `xml:"Header"`
+	Body struct {
+		Hello        *target `xml:"Hello"`
+		Bye          *target `xml:"Bye"`
+		Probe        *probe  `xml:"Probe"`
+		ProbeMatches *struct {
+			ProbeMatch []target `xml:"ProbeMatch"`
+		} `xml:"ProbeMatches"`
+	} `xml:"Body"`
+}
+
+// target describes an endpoint in Hello, Bye and ProbeMatch messages.
+type target struct {
+	Address string `xml:"EndpointReference>Address"`
+	Types   string `xml:"Types"`
+	Scopes  string `xml:"Scopes"`
+	XAddrs  string `xml:"XAddrs"`
+}
+
+type probe struct {
+	Types  string `xml:"Types"`
+	Scopes string `xml:"Scopes"`
+}
+
+// message renders a SOAP envelope with the given header fields and body. The
+// sequence, if any, is an AppSequence header element.
+func message(action, to, relatesTo, sequence, body string) []byte {
+	var relates string
+	if relatesTo != "" {
+		relates = "<wsa:RelatesTo>" + escape(relatesTo) + "</wsa:RelatesTo>"
+	}
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"` +
+		` xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+		` xmlns:wsd="http://schemas.xmlsoap.org/ws/2005/04/discovery"` +
+		` xmlns:dn="` + dnssdNamespace + `">` +
+		`<soap:Header>` +
+		`<wsa:To>` + to + `</wsa:To>` +
+		`<wsa:Action>` + action + `</wsa:Action>` +
+		`<wsa:MessageID>urn:uuid:` + randomUUID() + `</wsa:MessageID>` +
+		relates +
+		sequence +
+		`</soap:Header>` +
+		`<soap:Body>` + body + `</soap:Body>` +
+		`</soap:Envelope>`)
+}
+
+// targetBody renders the endpoint description used in Hello, Bye and
+// ProbeMatch elements.
+func targetBody(t target) string {
+	body := "<wsa:EndpointReference><wsa:Address>" + escape(t.Address) + "</wsa:Address></wsa:EndpointReference>"
+	if t.Types != "" {
+		body += "<wsd:Types>" + escape(t.Types) + "</wsd:Types>"
+	}
+	if t.XAddrs != "" {
+		body += "<wsd:XAddrs>" + escape(t.XAddrs) + "</wsd:XAddrs>"
+	}
+	return body + "<wsd:MetadataVersion>1</wsd:MetadataVersion>"
+}
+
+// appSequence renders the AppSequence header element of a message sent by an
+// endpoint instance.
+func appSequence(instanceID, messageNumber uint64) string {
+	return fmt.Sprintf(`<wsd:AppSequence InstanceId="%d" MessageNumber="%d"/>`, instanceID, messageNumber)
+}
+
+// repeatDelays returns the delays before the repetitions of a multicast
+// message.
+func repeatDelays() []time.Duration {
+	delays := make([]time.Duration, multicastUDPRepeat)
+	delay := udpMinDelay + time.Duration(mrand.Int63n(int64(udpMaxDelay-udpMinDelay)))
+	for i := range delays {
+		delays[i] = delay
+		if delay *= 2; delay > udpUpperDelay {
+			delay = udpUpperDelay
+		}
+	}
+	return delays
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// localTypes returns the local parts of a list of qualified type names.
+func localTypes(types string) []string {
+	var names []string
+	for _, t := range strings.Fields(types) {
+		if i := strings.LastIndexByte(t, ':'); i >= 0 {
+			t = t[i+1:]
+		}
+		names = append(names, t)
+	}
+	return names
+}
+
+// endpointUUID derives a stable endpoint UUID from a name.
+func endpointUUID(name string) string {
+	sum := sha1.Sum([]byte(dnssdNamespace + name))
+	sum[6] = (sum[6] & 0x0f) | 0x50 // version 5
+	sum[8] = (sum[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return formatUUID(sum[:16])
+}
+
+func randomUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return formatUUID(b[:])
+}
+
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}